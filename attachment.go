@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"mime"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gioui.org/app"
+	"gioui.org/gesture"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+const (
+	// attachments are sent as envelopes with the file name and hash in
+	// the metadata
+	metaName   = "name"
+	metaSHA256 = "sha256"
+
+	// maxAttachmentSize limits attachments to small files, as each
+	// fragment is a separate message on the mixnet
+	maxAttachmentSize = 1 << 20
+
+	// attachmentBlobPrefix is the blob id prefix of received attachments,
+	// which are stored by contact and hash as attachment://nickname/sha256
+	attachmentBlobPrefix = "attachment://"
+
+	// attachmentIndexPrefix is the blob id prefix of the attachments stored
+	// for a contact, oldest first
+	attachmentIndexPrefix = "attachments://"
+
+	// maxStoredAttachments limits the size of the attachments stored for a
+	// contact, as the whole statefile is written again on every change. The
+	// oldest are removed to make room.
+	maxStoredAttachments = 4 * maxAttachmentSize
+)
+
+var (
+	errAttachmentTooLarge = fmt.Errorf("attachments are limited to %s", formatSize(maxAttachmentSize))
+	errAttachmentCorrupt  = errors.New("attachment does not match its hash")
+)
+
+// newAttachmentEnvelope returns an envelope holding the contents of a file
+func newAttachmentEnvelope(name string, data []byte) *Envelope {
+	contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	sum := sha256.Sum256(data)
+	return &Envelope{
+		ContentType: contentType,
+		Body:        data,
+		Metadata: map[string]string{
+			metaName:   filepath.Base(name),
+			metaSHA256: hex.EncodeToString(sum[:]),
+		},
+	}
+}
+
+// IsAttachment returns true if the envelope holds a file
+func (e *Envelope) IsAttachment() bool {
+	_, ok := e.Metadata[metaName]
+	return ok
+}
+
+// IsImage returns true if the envelope holds an image file
+func (e *Envelope) IsImage() bool {
+	return e.IsAttachment() && strings.HasPrefix(e.ContentType, "image/")
+}
+
+// Verify checks the attachment body against its hash
+func (e *Envelope) Verify() error {
+	sum := sha256.Sum256(e.Body)
+	if e.Metadata[metaSHA256] != hex.EncodeToString(sum[:]) {
+		return errAttachmentCorrupt
+	}
+	return nil
+}
+
+// sendAttachment reads the file at path and sends it to nickname
+func (a *App) sendAttachment(nickname, path string) ([]catshadow.MessageID, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxAttachmentSize {
+		return nil, errAttachmentTooLarge
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return a.sendEnvelope(nickname, newAttachmentEnvelope(path, data))
+}
+
+// storedAttachment is an attachment stored for a contact
+type storedAttachment struct {
+	SHA256 string
+	Size   int
+}
+
+// attachmentBlobID returns the blob id of the attachment of nickname with
+// the hash sum
+func attachmentBlobID(nickname, sum string) string {
+	return attachmentBlobPrefix + nickname + "/" + sum
+}
+
+// attachmentNickname returns the contact of the attachment blob id
+func attachmentNickname(id string) (string, bool) {
+	rest, ok := strings.CutPrefix(id, attachmentBlobPrefix)
+	i := strings.LastIndex(rest, "/")
+	if !ok || i < 0 {
+		return "", false
+	}
+	return rest[:i], true
+}
+
+// storedAttachments returns the attachments stored for nickname, oldest first
+func (a *App) storedAttachments(nickname string) []storedAttachment {
+	b, err := a.c.GetBlob(attachmentIndexPrefix + nickname)
+	if err != nil {
+		return nil
+	}
+	var stored []storedAttachment
+	if err := cbor.Unmarshal(b, &stored); err != nil {
+		return nil
+	}
+	return stored
+}
+
+// setStoredAttachments records the attachments stored for nickname
+func (a *App) setStoredAttachments(nickname string, stored []storedAttachment) {
+	if len(stored) == 0 {
+		a.c.DeleteBlob(attachmentIndexPrefix + nickname)
+		return
+	}
+	if b, err := cbor.Marshal(stored); err == nil {
+		a.c.AddBlob(attachmentIndexPrefix+nickname, b)
+	}
+}
+
+// storeAttachments saves the attachments from nickname that the message
+// received completes to the client blobs once they are verified, so that
+// they outlive the message expiration. The oldest stored attachments are
+// removed to keep them within maxStoredAttachments.
+func (a *App) storeAttachments(nickname string, received []byte) {
+	stored := a.storedAttachments(nickname)
+	size := 0
+	have := make(map[string]bool, len(stored))
+	for _, s := range stored {
+		have[s.SHA256] = true
+		size += s.Size
+	}
+	changed := false
+	r := newReassembler(a.payloadLen())
+	for _, msg := range r.Messages(a.visibleMessages(nickname, a.c.GetSortedConversation(nickname))) {
+		if msg.Outbound || len(msg.Plaintext) == 0 || !hasPart(r.Parts(msg), received) {
+			continue
+		}
+		e, err := decodeEnvelope(msg.Plaintext)
+		if err != nil || !e.IsAttachment() || e.Verify() != nil || have[e.Metadata[metaSHA256]] {
+			continue
+		}
+		sum := e.Metadata[metaSHA256]
+		a.c.AddBlob(attachmentBlobID(nickname, sum), e.Body)
+		have[sum] = true
+		stored = append(stored, storedAttachment{SHA256: sum, Size: len(e.Body)})
+		size += len(e.Body)
+		changed = true
+	}
+	for size > maxStoredAttachments && len(stored) > 1 {
+		a.c.DeleteBlob(attachmentBlobID(nickname, stored[0].SHA256))
+		size -= stored[0].Size
+		stored = stored[1:]
+	}
+	if changed {
+		a.setStoredAttachments(nickname, stored)
+	}
+}
+
+// hasPart returns true if one of parts holds plaintext
+func hasPart(parts catshadow.Messages, plaintext []byte) bool {
+	for _, part := range parts {
+		if part != nil && bytes.Equal(part.Plaintext, plaintext) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentBody returns the stored attachment of nickname with the hash sum
+func (a *App) attachmentBody(nickname, sum string) ([]byte, error) {
+	return a.c.GetBlob(attachmentBlobID(nickname, sum))
+}
+
+// forgetAttachments removes the attachments of nickname with the hashes
+// sums from the client blobs
+func (a *App) forgetAttachments(nickname string, sums ...string) {
+	forget := make(map[string]bool, len(sums))
+	for _, sum := range sums {
+		forget[sum] = true
+	}
+	stored := a.storedAttachments(nickname)
+	kept := stored[:0]
+	for _, s := range stored {
+		if forget[s.SHA256] {
+			a.c.DeleteBlob(attachmentBlobID(nickname, s.SHA256))
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if len(kept) < len(stored) {
+		a.setStoredAttachments(nickname, kept)
+	}
+}
+
+// deleteAttachments removes every attachment stored for nickname
+func (a *App) deleteAttachments(nickname string) {
+	for _, s := range a.storedAttachments(nickname) {
+		a.c.DeleteBlob(attachmentBlobID(nickname, s.SHA256))
+	}
+	a.c.DeleteBlob(attachmentIndexPrefix + nickname)
+}
+
+// attachmentSums returns the hashes of the attachments in msgs
+func attachmentSums(msgs catshadow.Messages) []string {
+	var sums []string
+	for _, msg := range msgs {
+		if e, err := decodeEnvelope(msg.Plaintext); err == nil && e.IsAttachment() {
+			sums = append(sums, e.Metadata[metaSHA256])
+		}
+	}
+	return sums
+}
+
+// saveAttachment writes the attachment in e to the downloads directory
+// without replacing existing files, and returns the path written
+func saveAttachment(e *Envelope) (string, error) {
+	if err := e.Verify(); err != nil {
+		return "", err
+	}
+	dir := downloadDir()
+	name := filepath.Base(e.Metadata[metaName])
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(e.Body); err != nil {
+			f.Close()
+			os.Remove(path)
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+// downloadDir returns the directory that saved attachments are written to
+func downloadDir() string {
+	if runtime.GOOS == "android" {
+		return "/sdcard/Download"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	dir := filepath.Join(home, "Downloads")
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		return dir
+	}
+	return home
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	}
+}
+
+// AttachmentPicker is a file chooser for attachments
+type AttachmentPicker struct {
+	a      *App
+	path   string
+	list   *layout.List
+	back   *widget.Clickable
+	up     *widget.Clickable
+	clicks map[string]*gesture.Click
+	files  []os.FileInfo
+	errMsg string
+}
+
+// ChooseAttachment is the event that opens the AttachmentPicker
+type ChooseAttachment struct {
+	nickname string
+}
+
+// AttachmentSelected is returned to the page that opened the
+// AttachmentPicker when a file is chosen
+type AttachmentSelected struct {
+	path string
+}
+
+// Layout displays the files in the current directory
+func (p *AttachmentPicker) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Attach File").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+				)
+			}),
+			// cwd and buttons
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(material.Button(th, p.up, "..").Layout),
+					layout.Flexed(1, material.Body1(th, p.path).Layout),
+				)
+			}),
+			layout.Rigid(func(gtx C) D {
+				if p.errMsg == "" {
+					return D{}
+				}
+				return material.Caption(th, p.errMsg).Layout(gtx)
+			}),
+			// list contents
+			layout.Flexed(1, func(gtx C) D {
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return p.list.Layout(gtx, len(p.files), func(gtx C, i int) D {
+					fn := p.files[i]
+					if _, ok := p.clicks[fn.Name()]; !ok {
+						p.clicks[fn.Name()] = new(gesture.Click)
+					}
+					in := layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)}
+					dims := in.Layout(gtx, func(gtx C) D {
+						if fn.IsDir() {
+							return material.Body1(th, fn.Name()+"/").Layout(gtx)
+						}
+						return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+							layout.Flexed(1, material.Body1(th, fn.Name()).Layout),
+							layout.Rigid(material.Caption(th, formatSize(fn.Size())).Layout),
+						)
+					})
+					a := clip.Rect(image.Rectangle{Max: dims.Size})
+					t := a.Push(gtx.Ops)
+					p.clicks[fn.Name()].Add(gtx.Ops)
+					t.Pop()
+					return dims
+				})
+			}),
+		)
+	})
+}
+
+func (p *AttachmentPicker) Event(gtx C) interface{} {
+	if p.up.Clicked(gtx) {
+		if u, err := filepath.Abs(filepath.Join(p.path, "..")); err == nil {
+			p.path = u
+			p.scan()
+		}
+	}
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	for filename, click := range p.clicks {
+		if e, ok := click.Update(gtx.Source); ok && e.Kind == gesture.KindClick {
+			u, err := filepath.Abs(filepath.Join(p.path, filename))
+			if err != nil {
+				continue
+			}
+			f, err := os.Stat(u)
+			if err != nil {
+				p.errMsg = err.Error()
+				continue
+			}
+			if f.IsDir() {
+				p.path = u
+				p.scan()
+				return RedrawEvent{}
+			}
+			if f.Size() > maxAttachmentSize {
+				p.errMsg = errAttachmentTooLarge.Error()
+				continue
+			}
+			return Return{Result: AttachmentSelected{path: u}}
+		}
+	}
+	return nil
+}
+
+func (p *AttachmentPicker) Start(stop <-chan struct{}) {
+}
+
+func (p *AttachmentPicker) scan() {
+	p.errMsg = ""
+	p.clicks = make(map[string]*gesture.Click)
+	entries, err := os.ReadDir(p.path)
+	if err != nil {
+		p.files = nil
+		p.errMsg = err.Error()
+		return
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		// skip hidden files and directories
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, fi)
+		}
+	}
+	// list directories first
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].IsDir() && !files[j].IsDir()
+	})
+	p.files = files
+}
+
+func init() {
+	handle(func(a *App, e ChooseAttachment) interface{} {
+		return Push{newAttachmentPicker(a, "")}
+	})
+}
+
+func newAttachmentPicker(a *App, path string) *AttachmentPicker {
+	if path == "" {
+		path, _ = os.UserHomeDir()
+		if runtime.GOOS == "android" {
+			path = "/sdcard/"
+		}
+		if path == "" {
+			path, _ = app.DataDir()
+		}
+	}
+	p := &AttachmentPicker{
+		a:    a,
+		path: path,
+		list: &layout.List{Axis: layout.Vertical},
+		back: &widget.Clickable{},
+		up:   &widget.Clickable{},
+	}
+	p.scan()
+	return p
+}
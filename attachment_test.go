@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentEnvelope(t *testing.T) {
+	e := newAttachmentEnvelope("/tmp/katzen.toml", []byte("[Logging]\n"))
+	if !e.IsAttachment() || e.IsText() || e.IsImage() {
+		t.Errorf("unexpected kind for %s attachment", e.ContentType)
+	}
+	if e.Metadata[metaName] != "katzen.toml" {
+		t.Errorf("name = %q, want the base name", e.Metadata[metaName])
+	}
+	if err := e.Verify(); err != nil {
+		t.Error(err)
+	}
+	e.Body[0] = '#'
+	if err := e.Verify(); err != errAttachmentCorrupt {
+		t.Errorf("got %v for a modified body, want errAttachmentCorrupt", err)
+	}
+	if !newAttachmentEnvelope("cat.png", nil).IsImage() {
+		t.Error("png attachment is not an image")
+	}
+}
+
+// envelopeParts returns e encoded and split as it would be sent to f
+func envelopeParts(t *testing.T, f *fakeMessenger, e *Envelope) [][]byte {
+	t.Helper()
+	b, err := e.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := splitMessage(b, f.payloadLen-payloadOverhead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parts
+}
+
+// receiveEnvelope delivers e from nickname as a contact would send it
+func receiveEnvelope(t *testing.T, f *fakeMessenger, nickname string, e *Envelope, ts time.Time) [][]byte {
+	t.Helper()
+	parts := envelopeParts(t, f, e)
+	for i, part := range parts {
+		f.receive(nickname, part, ts.Add(time.Duration(i)*time.Millisecond))
+	}
+	return parts
+}
+
+func TestSendAttachment(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 128
+	a := newTestApp(f)
+	path := filepath.Join(t.TempDir(), "trace.log")
+	data := bytes.Repeat([]byte("goroutine 1 [running]:\n"), 40)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	msgIds, err := a.sendAttachment("bob", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgIds) < 2 {
+		t.Errorf("sent %d messages, want the attachment split into parts", len(msgIds))
+	}
+
+	messages := newReassembler(a.payloadLen()).Messages(f.GetSortedConversation("bob"))
+	e, err := decodeEnvelope(messages[len(messages)-1].Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.IsAttachment() || !bytes.Equal(e.Body, data) || e.Verify() != nil {
+		t.Error("sent attachment does not match the file")
+	}
+
+	big := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(big, make([]byte, maxAttachmentSize+1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.sendAttachment("bob", big); err != errAttachmentTooLarge {
+		t.Errorf("got %v for a large file, want errAttachmentTooLarge", err)
+	}
+}
+
+func TestConversationPageAttachmentError(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 128
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "bob")
+	})
+	h.frames(2)
+	c := h.current().(*conversationPage)
+	sent := len(f.GetSortedConversation("bob"))
+
+	c.Result(AttachmentSelected{path: filepath.Join(t.TempDir(), "missing.txt")})
+	if !strings.HasPrefix(c.errMsg, "Failed to send attachment") {
+		t.Errorf("error shown is %q, want the send failure", c.errMsg)
+	}
+	if len(f.GetSortedConversation("bob")) != sent {
+		t.Error("sent a message for a missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("meow "), 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.Result(AttachmentSelected{path: path})
+	if c.errMsg != "" {
+		t.Errorf("error %q is still shown after sending", c.errMsg)
+	}
+	// sent attachments are kept only in the conversation
+	for id := range f.blobs {
+		if !strings.HasPrefix(id, "avatar://") {
+			t.Errorf("sending an attachment added blob %q", id)
+		}
+	}
+}
+
+func TestStoreAttachments(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 128
+	a := newTestApp(f)
+	e := newAttachmentEnvelope("notes.txt", bytes.Repeat([]byte("purr "), 60))
+	sum := e.Metadata[metaSHA256]
+	parts := envelopeParts(t, f, e)
+	now := time.Now()
+	for i, part := range parts[:len(parts)-1] {
+		f.receive("bob", part, now.Add(time.Duration(i)*time.Millisecond))
+		a.storeAttachments("bob", part)
+	}
+	if _, err := a.attachmentBody("bob", sum); err == nil {
+		t.Fatal("stored an incomplete attachment")
+	}
+	last := parts[len(parts)-1]
+	f.receive("bob", last, now.Add(time.Second))
+	a.storeAttachments("bob", last)
+	if b, err := a.attachmentBody("bob", sum); err != nil || !bytes.Equal(b, e.Body) {
+		t.Fatalf("stored attachment is %q, %v, want the body", b, err)
+	}
+	if stored := a.storedAttachments("bob"); len(stored) != 1 || stored[0].SHA256 != sum {
+		t.Errorf("stored attachments are %v", stored)
+	}
+
+	// the attachment is served from the blob after the message expires
+	if err := f.WipeConversation("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.attachmentBody("bob", sum); err != nil {
+		t.Error(err)
+	}
+
+	a.forgetAttachments("bob", sum)
+	for id := range f.blobs {
+		if strings.HasPrefix(id, attachmentBlobPrefix) || strings.HasPrefix(id, attachmentIndexPrefix) {
+			t.Errorf("blob %q is kept after forgetting the attachment", id)
+		}
+	}
+}
+
+func TestStoreAttachmentsBound(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 1 << 16
+	a := newTestApp(f)
+	now := time.Now()
+	var sums []string
+	for i := 0; i < 5; i++ {
+		body := bytes.Repeat([]byte{byte(i)}, maxAttachmentSize)
+		e := newAttachmentEnvelope("big.bin", body)
+		parts := receiveEnvelope(t, f, "alice", e, now.Add(time.Duration(i)*time.Second))
+		a.storeAttachments("alice", parts[len(parts)-1])
+		sums = append(sums, e.Metadata[metaSHA256])
+	}
+	if _, err := a.attachmentBody("alice", sums[0]); err == nil {
+		t.Error("the oldest attachment is kept past maxStoredAttachments")
+	}
+	for _, sum := range sums[1:] {
+		if _, err := a.attachmentBody("alice", sum); err != nil {
+			t.Errorf("attachment %s was removed: %v", sum, err)
+		}
+	}
+
+	a.deleteAttachments("alice")
+	for id := range f.blobs {
+		if strings.HasPrefix(id, attachmentBlobPrefix) || strings.HasPrefix(id, attachmentIndexPrefix) {
+			t.Errorf("blob %q is kept after deleting the attachments", id)
+		}
+	}
+}
+
+func TestSaveAttachment(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	e := newAttachmentEnvelope("cat.png", []byte("not really a png"))
+	first, err := saveAttachment(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := saveAttachment(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != filepath.Join(home, "cat.png") || second != filepath.Join(home, "cat (1).png") {
+		t.Errorf("saved to %s and %s", first, second)
+	}
+	if b, _ := os.ReadFile(second); !bytes.Equal(b, e.Body) {
+		t.Error("saved file does not match the attachment")
+	}
+
+	e.Body = []byte("tampered")
+	if _, err := saveAttachment(e); err != errAttachmentCorrupt {
+		t.Errorf("got %v saving a corrupt attachment, want errAttachmentCorrupt", err)
+	}
+}
+
+func TestConversationPageAttachmentsGolden(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 512
+	f.WipeConversation("alice")
+
+	b := new(bytes.Buffer)
+	ct := Contactal{SharedSecret: "golden secret"}
+	if err := png.Encode(b, ct.Render(image.Pt(96, 64))); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	receiveEnvelope(t, f, "alice", newAttachmentEnvelope("contactal.png", b.Bytes()), now.Add(-3*time.Hour))
+	// only the first part of a second attachment has arrived
+	parts := envelopeParts(t, f, newAttachmentEnvelope("trace.log", bytes.Repeat([]byte("x"), 2000)))
+	f.receive("alice", parts[0], now.Add(-2*time.Hour))
+
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "alice")
+	})
+	h.frames(2)
+	h.screenshot("attachments")
+}
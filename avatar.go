@@ -64,7 +64,7 @@ func (p *AvatarPicker) Layout(gtx layout.Context) layout.Dimensions {
 			// avatar icon
 			layout.Rigid(func(gtx C) D {
 				dims := layout.Center.Layout(gtx, func(gtx C) D {
-					return layoutAvatar(gtx, p.a.c, p.nickname)
+					return p.a.layoutAvatar(gtx, p.nickname)
 				})
 				a := clip.Rect(image.Rectangle{Max: dims.Size})
 				t := a.Push(gtx.Ops)
@@ -216,7 +216,7 @@ func (p *AvatarPicker) Event(gtx C) interface{} {
 			b := new(bytes.Buffer)
 			if err := png.Encode(b, i); err == nil {
 				p.a.c.AddBlob("avatar://"+p.nickname, b.Bytes())
-				delete(avatars, p.nickname)
+				p.a.state.ForgetAvatar(p.nickname)
 				return RedrawEvent{}
 			}
 		}
@@ -233,7 +233,7 @@ func (p *AvatarPicker) Event(gtx C) interface{} {
 							return ChooseAvatarPath{nickname: p.nickname, path: u}
 							p.path = u
 						} else {
-							p.a.setAvatar(p.nickname, u)
+							return Return{Result: AvatarSelected{path: u}}
 						}
 					}
 				}
@@ -248,6 +248,12 @@ type ChooseAvatarPath struct {
 	path     string
 }
 
+// AvatarSelected is returned to the page that opened the AvatarPicker when
+// an image file is chosen
+type AvatarSelected struct {
+	path string
+}
+
 type opThumb struct {
 	f    os.FileInfo
 	size int
@@ -277,10 +283,7 @@ func (p *AvatarPicker) makeThumb(fn os.FileInfo, sz int) {
 		return
 	}
 	if m, _, err := image.Decode(f); err == nil {
-		sx, sy := m.Bounds().Max.X, m.Bounds().Max.Y
-		aspect := float32(sy) / float32(sx)
-		rz := image.Rectangle{Max: image.Point{X: sz, Y: int(float32(sz) * aspect)}}
-		resized := scale(m, rz, draw.NearestNeighbor)
+		resized := thumbnail(m, sz)
 		p.tl.Lock()
 		p.thumbs[fn] = &resized
 		p.tl.Unlock()
@@ -313,6 +316,15 @@ func (p *AvatarPicker) scan() {
 	p.tl.Unlock()
 }
 
+func init() {
+	handle(func(a *App, e ChooseAvatar) interface{} {
+		return Push{newAvatarPicker(a, e.nickname, "")}
+	})
+	handle(func(a *App, e ChooseAvatarPath) interface{} {
+		return Replace{newAvatarPicker(a, e.nickname, e.path)}
+	})
+}
+
 func newAvatarPicker(a *App, nickname string, path string) *AvatarPicker {
 	if path == "" {
 		path, _ = app.DataDir()
@@ -336,6 +348,14 @@ func newAvatarPicker(a *App, nickname string, path string) *AvatarPicker {
 	return ap
 }
 
+// thumbnail scales m to sz pixels wide, preserving its aspect ratio
+func thumbnail(m image.Image, sz int) image.Image {
+	sx, sy := m.Bounds().Max.X, m.Bounds().Max.Y
+	aspect := float32(sy) / float32(sx)
+	rz := image.Rectangle{Max: image.Point{X: sz, Y: int(float32(sz) * aspect)}}
+	return scale(m, rz, draw.NearestNeighbor)
+}
+
 func scale(src image.Image, rect image.Rectangle, scale draw.Scaler) image.Image {
 	dst := image.NewRGBA(rect)
 	scale.Scale(dst, rect, src, src.Bounds(), draw.Over, nil)
@@ -351,7 +371,7 @@ func (a *App) setAvatar(nickname, path string) {
 			b := &bytes.Buffer{}
 			if err := png.Encode(b, resized); err == nil {
 				a.c.AddBlob("avatar://"+nickname, b.Bytes())
-				delete(avatars, nickname)
+				a.state.ForgetAvatar(nickname)
 			}
 		}
 	} else {
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/hpqc/rand"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// A backup archive is sealed with its own passphrase, as:
+//
+//	magic (8) || version (1) || salt (16) || nonce (24) || secretbox(CBOR(backupArchive), argon2id(passphrase, salt))
+//
+// The statefile is stored as it is on disk, still encrypted with the sign in
+// passphrase, so restoring a backup also requires that passphrase.
+const (
+	backupMagic     = "KATZENBK"
+	backupVersion   = 1
+	backupSaltSize  = 16
+	backupExtension = ".katzenbackup"
+)
+
+var (
+	errNotBackup        = errors.New("not a katzen backup")
+	errBackupVersion    = errors.New("backup was made by a newer version of katzen")
+	errBackupPassphrase = errors.New("the backup passphrase is not correct")
+	errBackupExists     = errors.New("a file already exists at the backup path")
+)
+
+// backupArchive is the contents of a backup
+type backupArchive struct {
+	Version int
+	Created time.Time
+	// Profile is the name of the profile that was backed up
+	Profile   string
+	Statefile []byte
+	// Config is the client configuration that was in effect
+	Config []byte
+}
+
+// backupKey derives the archive key from passphrase
+func backupKey(passphrase, salt []byte) *[32]byte {
+	var key [32]byte
+	copy(key[:], argon2.IDKey(passphrase, salt, 3, 64*1024, 4, 32))
+	return &key
+}
+
+// writeBackup seals b with passphrase to a new file at path
+func writeBackup(path string, b *backupArchive, passphrase []byte) error {
+	plaintext, err := cbor.Marshal(b)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(backupMagic)+1+backupSaltSize+stateNonceSize)
+	copy(header, backupMagic)
+	header[len(backupMagic)] = backupVersion
+	salt := header[len(backupMagic)+1 : len(backupMagic)+1+backupSaltSize]
+	if _, err := rand.Reader.Read(header[len(backupMagic)+1:]); err != nil {
+		return err
+	}
+	var nonce [stateNonceSize]byte
+	copy(nonce[:], header[len(header)-stateNonceSize:])
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return errBackupExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(secretbox.Seal(header, plaintext, &nonce, backupKey(passphrase, salt))); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+// readBackup opens the backup at path with passphrase
+func readBackup(path string, passphrase []byte) (*backupArchive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(backupMagic) + 1 + backupSaltSize + stateNonceSize
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(backupMagic)) {
+		return nil, errNotBackup
+	}
+	if data[len(backupMagic)] != backupVersion {
+		return nil, errBackupVersion
+	}
+	salt := data[len(backupMagic)+1 : len(backupMagic)+1+backupSaltSize]
+	var nonce [stateNonceSize]byte
+	copy(nonce[:], data[headerSize-stateNonceSize:headerSize])
+	plaintext, ok := secretbox.Open(nil, data[headerSize:], &nonce, backupKey(passphrase, salt))
+	if !ok {
+		return nil, errBackupPassphrase
+	}
+	b := new(backupArchive)
+	if err := cbor.Unmarshal(plaintext, b); err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotBackup, err)
+	}
+	if b.Version != backupVersion {
+		return nil, errBackupVersion
+	}
+	return b, nil
+}
+
+// effectiveConfig returns the client configuration used by the client, as
+// chosen by setupCatShadow
+func (a *App) effectiveConfig() ([]byte, error) {
+	if path := a.configFile(); path != "" {
+		return os.ReadFile(path)
+	}
+	if name := a.selectedNetwork(); name != "" {
+		return a.c.GetBlob(networkBlobPrefix + name)
+	}
+	if _, err := a.c.GetBlob("UseTor"); err == nil {
+		return cfgWithTor, nil
+	}
+	return cfgWithoutTor, nil
+}
+
+// exportBackup writes a backup of the statefile and client configuration
+// in use to path, sealed with passphrase
+func (a *App) exportBackup(path string, passphrase []byte) error {
+	statefile, err := a.statefile()
+	if err != nil {
+		return err
+	}
+	// the StateWriter replaces the statefile by renaming, so it is always
+	// read whole
+	state, err := os.ReadFile(statefile)
+	if err != nil {
+		return err
+	}
+	cfg, err := a.effectiveConfig()
+	if err != nil {
+		return err
+	}
+	b := &backupArchive{
+		Version:   backupVersion,
+		Created:   time.Now(),
+		Statefile: state,
+		Config:    cfg,
+	}
+	if a.profile != nil {
+		b.Profile = a.profile.name
+	}
+	return writeBackup(path, b, passphrase)
+}
+
+// defaultBackupPath returns a path in the download directory for a backup
+// of the current profile
+func (a *App) defaultBackupPath() string {
+	name := "katzen"
+	if a.profile != nil {
+		name += "-" + profileSlug(a.profile.name)
+	}
+	name += "-" + time.Now().Format("2006-01-02") + backupExtension
+	return filepath.Join(downloadDir(), name)
+}
+
+// ExportBackupPage asks for a backup passphrase and where to save the backup
+type ExportBackupPage struct {
+	a          *App
+	back       *widget.Clickable
+	submit     *widget.Clickable
+	path       *widget.Editor
+	passphrase *widget.Editor
+	confirm    *widget.Editor
+	strength   passphraseStrength
+	result     chan error
+	exported   string
+	busy       bool
+	errMsg     string
+}
+
+// ExportBackupClick is the event that opens the ExportBackupPage
+type ExportBackupClick struct{}
+
+// Layout returns the export form
+func (p *ExportBackupPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Export Backup").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Rigid(func(gtx C) D {
+				msg := "The backup holds your contacts, messages and client configuration. Restoring it also needs your sign in passphrase."
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			field(p.path, "Save to"),
+			field(p.passphrase, "Backup passphrase"),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, func(gtx C) D {
+					return layoutStrength(gtx, p.strength)
+				})
+			}),
+			field(p.confirm, "Repeat the backup passphrase"),
+			layout.Rigid(func(gtx C) D {
+				msg := p.errMsg
+				if p.busy {
+					msg = "Writing backup..."
+				}
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				if p.busy {
+					gtx = gtx.Disabled()
+				}
+				return material.Button(th, p.submit, "Export").Layout(gtx)
+			}),
+		)
+	})
+}
+
+func (p *ExportBackupPage) Event(gtx layout.Context) interface{} {
+	for _, ed := range []*widget.Editor{p.path, p.passphrase, p.confirm} {
+		if e, ok := ed.Update(gtx); ok {
+			switch e.(type) {
+			case widget.ChangeEvent:
+				if ed == p.passphrase {
+					p.strength = editorStrength(ed)
+				}
+			case widget.SubmitEvent:
+				p.submit.Click()
+			}
+		}
+	}
+	if p.back.Clicked(gtx) && !p.busy {
+		return BackEvent{}
+	}
+	if p.submit.Clicked(gtx) && !p.busy {
+		path := strings.TrimSpace(p.path.Text())
+		passphrase, err := readNewPassphrase(p.passphrase, p.confirm)
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		p.exported = path
+		p.errMsg = ""
+		p.busy = true
+		go func() {
+			defer passphrase.destroy()
+			p.result <- p.a.exportBackup(path, passphrase.Bytes())
+			p.a.w.Invalidate()
+		}()
+	}
+
+	select {
+	case err := <-p.result:
+		p.busy = false
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		p.errMsg = "Backup saved to " + p.exported
+		p.passphrase.SetText("")
+		p.confirm.SetText("")
+		p.strength = estimateStrength(nil)
+	default:
+	}
+	return nil
+}
+
+func (p *ExportBackupPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, _ ExportBackupClick) interface{} {
+		return Push{newExportBackupPage(a)}
+	})
+}
+
+func newExportBackupPage(a *App) *ExportBackupPage {
+	p := &ExportBackupPage{
+		a:          a,
+		back:       &widget.Clickable{},
+		submit:     &widget.Clickable{},
+		path:       &widget.Editor{SingleLine: true, Submit: true},
+		passphrase: &widget.Editor{SingleLine: true, Mask: '*', Submit: true},
+		confirm:    &widget.Editor{SingleLine: true, Mask: '*', Submit: true},
+		strength:   estimateStrength(nil),
+		result:     make(chan error, 1),
+	}
+	p.path.SetText(a.defaultBackupPath())
+	return p
+}
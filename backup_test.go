@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+func TestBackupArchive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup"+backupExtension)
+	b := &backupArchive{
+		Version:   backupVersion,
+		Created:   time.Now().Round(time.Second),
+		Profile:   "Work",
+		Statefile: []byte("statefile"),
+		Config:    cfgWithoutTor,
+	}
+	if err := writeBackup(path, b, []byte("backup passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBackup(path, b, []byte("backup passphrase")); err != errBackupExists {
+		t.Errorf("got %v writing over a file, want errBackupExists", err)
+	}
+
+	got, err := readBackup(path, []byte("backup passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Profile != b.Profile || !got.Created.Equal(b.Created) || !bytes.Equal(got.Statefile, b.Statefile) || !bytes.Equal(got.Config, b.Config) {
+		t.Errorf("got %+v, want %+v", got, b)
+	}
+	if _, err := readBackup(path, []byte("hunter2")); err != errBackupPassphrase {
+		t.Errorf("got %v for the wrong passphrase, want errBackupPassphrase", err)
+	}
+
+	// a later format version is refused before decrypting
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(backupMagic)] = backupVersion + 1
+	newer := filepath.Join(dir, "newer"+backupExtension)
+	if err := os.WriteFile(newer, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBackup(newer, []byte("backup passphrase")); err != errBackupVersion {
+		t.Errorf("got %v for a newer version, want errBackupVersion", err)
+	}
+
+	// statefiles are not backups
+	state := filepath.Join(dir, "statefile")
+	writeTestState(t, state, []byte("hunter2"))
+	if _, err := readBackup(state, []byte("hunter2")); err != errNotBackup {
+		t.Errorf("got %v for a statefile, want errNotBackup", err)
+	}
+}
+
+func TestExportRestore(t *testing.T) {
+	statefile := useStateFile(t)
+	writeTestState(t, statefile, []byte("hunter2"))
+	a := newTestApp(newPopulatedMessenger())
+	backup := filepath.Join(t.TempDir(), "backup"+backupExtension)
+	if err := a.exportBackup(backup, []byte("backup passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := readBackup(backup, []byte("backup passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Config, cfgWithoutTor) {
+		t.Error("backup does not hold the client configuration in use")
+	}
+
+	s := newProfileStore(filepath.Join(t.TempDir(), profilesDirName))
+	// a statefile that does not load is not restored
+	if _, err := s.Restore(b, "Home", []byte("hunter3"), false); err != errRestorePassphrase {
+		t.Errorf("got %v restoring with the wrong passphrase, want errRestorePassphrase", err)
+	}
+	if profiles, _ := s.List(); len(profiles) != 0 {
+		t.Error("failing to restore left a profile behind")
+	}
+	p, err := s.Restore(b, "Home", []byte("hunter2"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, p.statefile(), []byte("hunter2")); err != nil {
+		t.Errorf("restored statefile does not load: %v", err)
+	}
+	if p.configFile() != "" {
+		t.Error("default configuration was restored to the profile")
+	}
+
+	// an existing statefile is only replaced when confirmed
+	if err := os.WriteFile(p.statefile(), []byte("other statefile"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Restore(b, "home", []byte("hunter2"), false); err != errRestoreOverwrite {
+		t.Errorf("got %v restoring over a profile, want errRestoreOverwrite", err)
+	}
+	if data, _ := os.ReadFile(p.statefile()); string(data) != "other statefile" {
+		t.Error("statefile was replaced without confirmation")
+	}
+	if err := os.WriteFile(p.statefile()+"~", []byte("other backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Restore(b, "home", []byte("hunter3"), true); err != errRestorePassphrase {
+		t.Errorf("got %v restoring with the wrong passphrase, want errRestorePassphrase", err)
+	}
+	if data, _ := os.ReadFile(p.statefile()); string(data) != "other statefile" {
+		t.Error("statefile was replaced by one that does not load")
+	}
+	if _, err := os.Stat(p.statefile() + "~"); err != nil {
+		t.Error("backup of the statefile was removed by a failed restore")
+	}
+	if err := setDuressPassphrase(p.statefile(), []byte("duress")); err != nil {
+		t.Fatal(err)
+	}
+	b.Config = append(append([]byte{}, cfgWithTor...), "\n# custom\n"...)
+	if _, err := s.Restore(b, "home", []byte("hunter2"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.statefile() + "~"); !os.IsNotExist(err) {
+		t.Error("backup of the replaced statefile was kept")
+	}
+	if isDuressPassphrase(p.statefile(), []byte("duress")) {
+		t.Error("duress passphrase of the replaced statefile was kept")
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, p.statefile(), []byte("hunter2")); err != nil {
+		t.Errorf("statefile was not replaced: %v", err)
+	}
+	if p.configFile() == "" {
+		t.Error("custom configuration was not restored")
+	}
+	if profiles, _ := s.List(); len(profiles) != 1 {
+		t.Errorf("restoring over a profile made %d profiles", len(profiles))
+	}
+}
+
+func TestRestoreBackupPage(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	backup := filepath.Join(t.TempDir(), "backup"+backupExtension)
+	state := filepath.Join(t.TempDir(), "statefile")
+	writeTestState(t, state, []byte("hunter2"))
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &backupArchive{Version: backupVersion, Created: time.Now(), Profile: defaultProfileName, Statefile: data, Config: cfgWithoutTor}
+	if err := writeBackup(backup, b, []byte("backup passphrase")); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		return newSignInPage(a)
+	})
+	// the default profile already has a statefile
+	writeTestState(t, h.a.profile.statefile(), []byte("hunter3"))
+	h.a.navigate(ShowProfilesClick{})
+	h.a.navigate(RestoreBackupClick{})
+	p, ok := h.current().(*RestoreBackupPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *RestoreBackupPage", h.current())
+	}
+	// the backup is opened and restored in the background
+	wait := func() {
+		h.frames(2)
+		deadline := time.Now().Add(10 * time.Second)
+		for p.busy && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			h.frame()
+		}
+	}
+	p.path.SetText(backup)
+	p.passphrase.SetText("hunter2")
+	p.open.Click()
+	wait()
+	if p.archive != nil || p.errMsg != errBackupPassphrase.Error() {
+		t.Fatalf("got %q opening with the wrong passphrase", p.errMsg)
+	}
+	p.passphrase.SetText("backup passphrase")
+	p.open.Click()
+	wait()
+	if p.archive == nil || p.name.Text() != defaultProfileName {
+		t.Fatalf("backup was not opened: %q", p.errMsg)
+	}
+
+	p.signin.SetText("hunter2")
+	p.restore.Click()
+	wait()
+	if !p.confirmOverwrite || h.current() != Page(p) {
+		t.Fatal("restore replaced a statefile without confirmation")
+	}
+	p.restore.Click()
+	wait()
+	s, ok := h.current().(*signInPage)
+	if !ok || s.firstRun || h.a.profile.name != defaultProfileName {
+		t.Fatalf("current page is %T, want the sign in page for the restored profile", h.current())
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, h.a.profile.statefile(), []byte("hunter2")); err != nil {
+		t.Errorf("statefile was not restored: %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"gioui.org/layout"
 	"gioui.org/widget/material"
-	"github.com/katzenpost/katzenpost/catshadow"
 )
 
 type connectingPage struct {
@@ -27,7 +26,7 @@ type connectError struct {
 }
 
 type connectSuccess struct {
-	client *catshadow.Client
+	client Messenger
 }
 
 func (p *connectingPage) Event(gtx layout.Context) interface{} {
@@ -36,7 +35,7 @@ func (p *connectingPage) Event(gtx layout.Context) interface{} {
 		switch r := r.(type) {
 		case error:
 			return connectError{err: r}
-		case *catshadow.Client:
+		case Messenger:
 			return connectSuccess{client: r}
 		}
 	default:
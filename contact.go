@@ -299,6 +299,15 @@ func (p *AddContactPage) Event(gtx layout.Context) interface{} {
 func (p *AddContactPage) Start(stop <-chan struct{}) {
 }
 
+func init() {
+	handle(func(a *App, _ AddContactClick) interface{} {
+		return Push{newAddContactPage(a)}
+	})
+	handle(func(a *App, _ AddContactComplete) interface{} {
+		return Return{}
+	})
+}
+
 func newAddContactPage(a *App) *AddContactPage {
 	p := &AddContactPage{}
 	p.a = a
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/hako/durafmt"
 	"github.com/katzenpost/katzenpost/catshadow"
 	"golang.org/x/exp/shiny/materialdesign/icons"
@@ -10,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"gioui.org/font"
 	"gioui.org/gesture"
 	"gioui.org/io/clipboard"
 	"gioui.org/io/event"
@@ -18,20 +21,24 @@ import (
 	"gioui.org/io/transfer"
 	"gioui.org/layout"
 	"gioui.org/op/clip"
+	"gioui.org/op/paint"
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
+	"gioui.org/x/notify"
 )
 
 var (
-	messageList      = &layout.List{Axis: layout.Vertical, ScrollToEnd: true}
-	messageField     = &widget.Editor{SingleLine: true}
 	backIcon, _      = widget.NewIcon(icons.NavigationChevronLeft)
 	sendIcon, _      = widget.NewIcon(icons.NavigationChevronRight)
 	queuedIcon, _    = widget.NewIcon(icons.NotificationSync)
 	sentIcon, _      = widget.NewIcon(icons.ActionDone)
 	deliveredIcon, _ = widget.NewIcon(icons.ActionDoneAll)
 	pandaIcon, _     = widget.NewIcon(icons.ActionPets)
+	attachIcon, _    = widget.NewIcon(icons.EditorAttachFile)
+
+	// selectedColor is the background of the messages selected for deletion
+	selectedColor = rgb(0x335577)
 )
 
 type conversationPage struct {
@@ -39,15 +46,33 @@ type conversationPage struct {
 	nickname       string
 	avatar         *widget.Image
 	edit           *gesture.Click
+	messageList    *layout.List
+	fragments      *reassembler
+	attachments    map[*catshadow.Message]*attachmentView
+	requests       map[*catshadow.Message]bool
 	compose        *widget.Editor
 	send           *widget.Clickable
+	attach         *widget.Clickable
 	back           *widget.Clickable
 	cancel         *gesture.Click
 	msgcopy        *widget.Clickable
 	msgpaste       *LongPress
 	msgdetails     *widget.Clickable
+	msgsave        *widget.Clickable
+	msgdelete      *widget.Clickable
+	deleteSelected *widget.Clickable
+	cancelSelect   *widget.Clickable
+	askDelete      *widget.Bool
 	messageClicked *catshadow.Message
-	messageClicks  map[*catshadow.Message]*gesture.Click
+	selected       map[*catshadow.Message]bool
+	// highlight is the first part of the message opened from a search
+	// result, with the matches of query highlighted
+	highlight     *catshadow.Message
+	query         string
+	scrolled      bool
+	messageClicks map[*catshadow.Message]*gesture.Click
+	// errMsg is the error of the last action, shown above the compose bar
+	errMsg string
 }
 
 func (c *conversationPage) Start(stop <-chan struct{}) {
@@ -55,13 +80,20 @@ func (c *conversationPage) Start(stop <-chan struct{}) {
 
 type MessageSent struct {
 	nickname string
-	msgId    catshadow.MessageID
+	msgIds   []catshadow.MessageID
 }
 
 type EditContact struct {
 	nickname string
 }
 
+// attachmentView caches the decoded attachment of a message between frames
+type attachmentView struct {
+	e     *Envelope
+	err   error
+	thumb image.Image
+}
+
 func (c *conversationPage) Event(gtx layout.Context) interface{} {
 	// check for editor SubmitEvents
 	if e, ok := c.compose.Update(gtx); ok {
@@ -74,22 +106,22 @@ func (c *conversationPage) Event(gtx layout.Context) interface{} {
 
 	// catch clicks on send button, update list view position to bottom
 	if c.send.Clicked(gtx) {
-		messageList.ScrollToEnd = true
+		c.messageList.ScrollToEnd = true
 		// XXX: could do this in Layout where we know the # of messages
-		messageList.ScrollTo(0x1 << 32)
+		c.messageList.ScrollTo(0x1 << 32)
 
-		msg := []byte(c.compose.Text())
-		c.compose.SetText("")
-		if len(msg) == 0 {
+		if len(c.compose.Text()) == 0 {
 			return nil
 		}
-		// truncate messages
-		// TODO: this should split messages and return the set of message IDs sent
-		if len(msg)+4 > c.a.c.DoubleRatchetPayloadLength() {
-			msg = msg[:c.a.c.DoubleRatchetPayloadLength()-4]
+		// the text is kept to be sent again if it fails
+		msgIds, err := c.a.sendEnvelope(c.nickname, newTextEnvelope(c.compose.Text()))
+		if err != nil {
+			c.errMsg = fmt.Sprintf("Failed to send: %s", err)
+			return nil
 		}
-		msgId := c.a.c.SendMessage(c.nickname, msg)
-		return MessageSent{nickname: c.nickname, msgId: msgId}
+		c.errMsg = ""
+		c.compose.SetText("")
+		return MessageSent{nickname: c.nickname, msgIds: msgIds}
 	}
 
 	// check for long press
@@ -129,17 +161,73 @@ func (c *conversationPage) Event(gtx layout.Context) interface{} {
 	}
 	if c.msgcopy.Clicked(gtx) {
 		gtx.Source.Execute(clipboard.WriteCmd{
-			Data: io.NopCloser(strings.NewReader(string(c.messageClicked.Plaintext))),
+			Data: io.NopCloser(strings.NewReader(messageText(c.messageClicked.Plaintext))),
 		})
 		c.messageClicked = nil
 	}
-	if c.msgdetails.Clicked(gtx) {
-		c.messageClicked = nil // not implemented
+	if c.msgdetails.Clicked(gtx) && c.messageClicked != nil {
+		msg := c.messageClicked
+		c.messageClicked = nil
+		return ShowMessageDetails{nickname: c.nickname, msg: msg, parts: c.fragments.Parts(msg)}
+	}
+	if c.msgsave.Clicked(gtx) {
+		if v, ok := c.attachments[c.messageClicked]; ok && v != nil {
+			go func() {
+				msg := "Saved to "
+				path, err := saveAttachment(v.e)
+				if err != nil {
+					msg, path = "Failed to save: ", err.Error()
+				}
+				if n, err := notify.Push("Attachment", msg+path); err == nil {
+					<-time.After(notificationTimeout)
+					n.Cancel()
+				}
+			}()
+		}
+		c.messageClicked = nil
+	}
+	if c.msgdelete.Clicked(gtx) && c.messageClicked != nil {
+		// start selecting the messages to delete
+		c.selected = map[*catshadow.Message]bool{c.messageClicked: true}
+		c.messageClicked = nil
+	}
+	if c.deleteSelected.Clicked(gtx) {
+		var msgs catshadow.Messages
+		for msg := range c.selected {
+			for _, part := range c.fragments.Parts(msg) {
+				if part != nil {
+					msgs = append(msgs, part)
+				}
+			}
+		}
+		if err := c.a.deleteMessages(c.nickname, msgs, c.askDelete.Value); err != nil {
+			c.errMsg = fmt.Sprintf("Failed to delete: %s", err)
+		} else {
+			c.errMsg = ""
+			c.selected = nil
+		}
+	}
+	if c.cancelSelect.Clicked(gtx) {
+		c.selected = nil
+	}
+	if c.attach.Clicked(gtx) {
+		return ChooseAttachment{nickname: c.nickname}
 	}
 
 	for msg, click := range c.messageClicks {
 		if _, ok := click.Update(gtx.Source); ok {
-			c.messageClicked = msg
+			if c.selected == nil {
+				c.messageClicked = msg
+				continue
+			}
+			if c.selected[msg] {
+				delete(c.selected, msg)
+			} else {
+				c.selected[msg] = true
+			}
+			if len(c.selected) == 0 {
+				c.selected = nil
+			}
 		}
 	}
 
@@ -156,24 +244,24 @@ func (c *conversationPage) Event(gtx layout.Context) interface{} {
 			return EditContact{nickname: c.nickname}
 		}
 		if e.Name == key.NameUpArrow && e.State == key.Release {
-			messageList.ScrollToEnd = false
-			if messageList.Position.First > 0 {
-				messageList.Position.First = messageList.Position.First - 1
+			c.messageList.ScrollToEnd = false
+			if c.messageList.Position.First > 0 {
+				c.messageList.Position.First = c.messageList.Position.First - 1
 			}
 		}
 		if e.Name == key.NameDownArrow && e.State == key.Release {
-			messageList.ScrollToEnd = true
-			messageList.Position.First = messageList.Position.First + 1
+			c.messageList.ScrollToEnd = true
+			c.messageList.Position.First = c.messageList.Position.First + 1
 		}
 		if e.Name == key.NamePageUp && e.State == key.Release {
-			messageList.ScrollToEnd = false
-			if messageList.Position.First-messageList.Position.Count > 0 {
-				messageList.Position.First = messageList.Position.First - messageList.Position.Count
+			c.messageList.ScrollToEnd = false
+			if c.messageList.Position.First-c.messageList.Position.Count > 0 {
+				c.messageList.Position.First = c.messageList.Position.First - c.messageList.Position.Count
 			}
 		}
 		if e.Name == key.NamePageDown && e.State == key.Release {
-			messageList.ScrollToEnd = true
-			messageList.Position.First = messageList.Position.First + messageList.Position.Count
+			c.messageList.ScrollToEnd = true
+			c.messageList.Position.First = c.messageList.Position.First + c.messageList.Position.Count
 		}
 		return RedrawEvent{}
 	}
@@ -182,7 +270,7 @@ func (c *conversationPage) Event(gtx layout.Context) interface{} {
 	return nil
 }
 
-func layoutMessage(gtx C, msg *catshadow.Message, isSelected bool, expires time.Duration) D {
+func (c *conversationPage) layoutMessage(gtx C, msg *catshadow.Message, isSelected bool, expires time.Duration) D {
 
 	var statusIcon *widget.Icon
 	if msg.Outbound == true {
@@ -199,7 +287,9 @@ func layoutMessage(gtx C, msg *catshadow.Message, isSelected bool, expires time.
 	}
 
 	return layout.Flex{Axis: layout.Vertical, Alignment: layout.End, Spacing: layout.SpaceBetween}.Layout(gtx,
-		layout.Rigid(material.Body1(th, string(msg.Plaintext)).Layout),
+		layout.Rigid(func(gtx C) D {
+			return c.layoutBody(gtx, msg)
+		}),
 		layout.Rigid(func(gtx C) D {
 			in := layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(0), Left: unit.Dp(8), Right: unit.Dp(8)}
 			return in.Layout(gtx, func(gtx C) D {
@@ -238,15 +328,130 @@ func layoutMessage(gtx C, msg *catshadow.Message, isSelected bool, expires time.
 	)
 }
 
+// layoutBody lays out the text or attachment of msg, or the progress of its
+// transfer
+func (c *conversationPage) layoutBody(gtx C, msg *catshadow.Message) D {
+	if received, total, ok := c.fragments.Progress(msg); ok && received < total {
+		label := material.Body1(th, fmt.Sprintf("%d/%d parts received", received, total))
+		label.Font.Style = font.Italic
+		return layoutProgress(gtx, label, received, total)
+	}
+	v := c.attachment(gtx, msg)
+	if v == nil {
+		text := messageText(msg.Plaintext)
+		if c.highlight != nil && c.fragments.Parts(msg)[0] == c.highlight {
+			return layoutHighlight(gtx, text, matchRanges(text, c.query))
+		}
+		return material.Body1(th, text).Layout(gtx)
+	}
+
+	name := v.e.Metadata[metaName]
+	label := material.Body1(th, fmt.Sprintf("%s (%s)", name, formatSize(int64(len(v.e.Body)))))
+	if v.err != nil {
+		label = material.Body1(th, "Corrupt attachment: "+name)
+	}
+	if sent, total, ok := c.fragments.Sent(msg); ok && msg.Outbound && sent < total {
+		return layoutProgress(gtx, label, sent, total)
+	}
+	return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			if v.thumb == nil {
+				return D{}
+			}
+			sc := 1 / gtx.Metric.PxPerDp
+			return widget.Image{Scale: sc, Src: paint.NewImageOp(v.thumb)}.Layout(gtx)
+		}),
+		layout.Rigid(label.Layout),
+	)
+}
+
+// attachment returns the decoded attachment of msg, or nil if msg is not an
+// attachment. Image attachments are given a thumbnail.
+func (c *conversationPage) attachment(gtx C, msg *catshadow.Message) *attachmentView {
+	if v, ok := c.attachments[msg]; ok {
+		return v
+	}
+	var v *attachmentView
+	if e, err := decodeEnvelope(msg.Plaintext); err == nil && e.IsAttachment() {
+		// a received attachment is served from the blob it is stored in
+		if !msg.Outbound {
+			if b, err := c.a.attachmentBody(c.nickname, e.Metadata[metaSHA256]); err == nil {
+				e.Body = b
+			}
+		}
+		v = &attachmentView{e: e, err: e.Verify()}
+		if v.err == nil && e.IsImage() {
+			if m, _, err := image.Decode(bytes.NewReader(e.Body)); err == nil {
+				v.thumb = thumbnail(m, gtx.Dp(unit.Dp(200)))
+			}
+		}
+	}
+	c.attachments[msg] = v
+	return v
+}
+
+// isDeleteRequest returns true if msg is a delete request, which is not
+// displayed
+func (c *conversationPage) isDeleteRequest(msg *catshadow.Message) bool {
+	if len(msg.Plaintext) == 0 {
+		return false
+	}
+	r, ok := c.requests[msg]
+	if !ok {
+		r = isDeleteRequest(msg)
+		c.requests[msg] = r
+	}
+	return r
+}
+
+// layoutProgress lays out label above a progress bar
+func layoutProgress(gtx C, label material.LabelStyle, n, total int) D {
+	return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
+		layout.Rigid(label.Layout),
+		layout.Rigid(func(gtx C) D {
+			in := layout.Inset{Top: unit.Dp(4)}
+			return in.Layout(gtx, material.ProgressBar(th, float32(n)/float32(total)).Layout)
+		}),
+	)
+}
+
+// Result receives the file chosen with the AttachmentPicker
+func (c *conversationPage) Result(v interface{}) {
+	switch v := v.(type) {
+	case AttachmentSelected:
+		if _, err := c.a.sendAttachment(c.nickname, v.path); err != nil {
+			c.errMsg = fmt.Sprintf("Failed to send attachment: %s", err)
+			return
+		}
+		c.errMsg = ""
+		c.messageList.ScrollToEnd = true
+		c.messageList.ScrollTo(0x1 << 32)
+	}
+}
+
 func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 	// set focus on composition
 	gtx.Execute(key.FocusCmd{Tag: c.compose})
 	contact := c.a.c.GetContacts()[c.nickname]
-	if n, ok := notifications[c.nickname]; ok {
-		n.Cancel()
-		delete(notifications, c.nickname)
+	c.a.state.CancelNotification(c.nickname)
+	messages := c.fragments.Messages(c.a.visibleMessages(c.nickname, c.a.c.GetSortedConversation(c.nickname)))
+	visible := messages[:0]
+	for _, msg := range messages {
+		if !c.isDeleteRequest(msg) {
+			visible = append(visible, msg)
+		}
+	}
+	messages = visible
+	if c.highlight != nil && !c.scrolled {
+		// scroll to the message opened from a search result
+		for i, msg := range messages {
+			if c.fragments.Parts(msg)[0] == c.highlight {
+				c.messageList.ScrollToEnd = false
+				c.messageList.ScrollTo(i)
+				c.scrolled = true
+			}
+		}
 	}
-	messages := c.a.c.GetSortedConversation(c.nickname)
 	expires, _ := c.a.c.GetExpiration(c.nickname)
 	bgl := Background{
 		Color: th.Bg,
@@ -259,7 +464,7 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
 					layout.Rigid(button(th, c.back, backIcon).Layout),
 					layout.Rigid(func(gtx C) D {
-						dims := layoutAvatar(gtx, c.a.c, c.nickname)
+						dims := c.a.layoutAvatar(gtx, c.nickname)
 						a := clip.Rect(image.Rectangle{Max: dims.Size})
 						t := a.Push(gtx.Ops)
 						c.edit.Add(gtx.Ops)
@@ -284,7 +489,7 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 					return fill{th.Bg}.Layout(ctx)
 				}
 
-				dims := messageList.Layout(gtx, len(messages), func(gtx C, i int) layout.Dimensions {
+				dims := c.messageList.Layout(gtx, len(messages), func(gtx C, i int) layout.Dimensions {
 					if _, ok := c.messageClicks[messages[i]]; !ok {
 						c.messageClicks[messages[i]] = new(gesture.Click)
 					}
@@ -306,14 +511,17 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 						}
 					}
 					var dims D
-					isSelected := messages[i] == c.messageClicked
+					isSelected := messages[i] == c.messageClicked || c.selected[messages[i]]
+					if c.selected[messages[i]] {
+						bgSender.Color, bgReceiver.Color = selectedColor, selectedColor
+					}
 					if messages[i].Outbound {
 						dims = layout.Flex{Axis: layout.Horizontal, Alignment: layout.Baseline, Spacing: layout.SpaceAround}.Layout(gtx,
 							layout.Flexed(1, fill{th.Bg}.Layout),
 							layout.Flexed(5, func(gtx C) D {
 								return inbetween.Layout(gtx, func(gtx C) D {
 									return bgSender.Layout(gtx, func(gtx C) D {
-										return layoutMessage(gtx, messages[i], isSelected, expires)
+										return c.layoutMessage(gtx, messages[i], isSelected, expires)
 									})
 								})
 							}),
@@ -323,7 +531,7 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 							layout.Flexed(5, func(gtx C) D {
 								return inbetween.Layout(gtx, func(gtx C) D {
 									return bgReceiver.Layout(gtx, func(gtx C) D {
-										return layoutMessage(gtx, messages[i], isSelected, expires)
+										return c.layoutMessage(gtx, messages[i], isSelected, expires)
 									})
 								})
 							}),
@@ -346,17 +554,57 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 				return dims
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			msg := c.errMsg
+			if msg == "" && c.selected != nil {
+				msg = "Deleted messages are hidden now, and removed from the statefile at the next sign in"
+			}
+			if msg == "" {
+				return D{}
+			}
+			in := layout.Inset{Top: unit.Dp(4), Left: unit.Dp(12), Right: unit.Dp(12)}
+			return in.Layout(gtx, material.Caption(th, msg).Layout)
+		}),
 		layout.Rigid(func(gtx C) D {
 			bg := Background{
 				Color: th.ContrastBg,
 				Inset: layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(0), Left: unit.Dp(12), Right: unit.Dp(12)},
 			}
+			// return the menu laid out for the selected messages
+			if c.selected != nil {
+				return bg.Layout(gtx, func(gtx C) D {
+					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, material.Body2(th, fmt.Sprintf("%d selected", len(c.selected))).Layout),
+						layout.Rigid(func(gtx C) D {
+							cb := material.CheckBox(th, c.askDelete, "ask contact too")
+							cb.IconColor = th.Fg
+							return cb.Layout(gtx)
+						}),
+						layout.Rigid(func(gtx C) D {
+							in := layout.Inset{Left: unit.Dp(8), Right: unit.Dp(8)}
+							return in.Layout(gtx, material.Button(th, c.deleteSelected, "delete").Layout)
+						}),
+						layout.Rigid(material.Button(th, c.cancelSelect, "cancel").Layout),
+					)
+				})
+			}
 			// return the menu laid out for message actions
 			if c.messageClicked != nil {
 				return bg.Layout(gtx, func(gtx C) D {
 					return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
 						layout.Rigid(material.Button(th, c.msgcopy, "copy").Layout),
 						layout.Flexed(1, fill{th.Bg}.Layout),
+						layout.Rigid(func(gtx C) D {
+							if v := c.attachments[c.messageClicked]; v == nil || v.err != nil {
+								return D{}
+							}
+							in := layout.Inset{Right: unit.Dp(8)}
+							return in.Layout(gtx, material.Button(th, c.msgsave, "save").Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							in := layout.Inset{Right: unit.Dp(8)}
+							return in.Layout(gtx, material.Button(th, c.msgdelete, "delete").Layout)
+						}),
 						layout.Rigid(material.Button(th, c.msgdetails, "details").Layout),
 					)
 				})
@@ -373,7 +621,9 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 
 			return bgl.Layout(gtx, func(gtx C) D {
 				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
-					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Flexed(1, func(gtx C) D {
+						return layout.Center.Layout(gtx, button(th, c.attach, attachIcon).Layout)
+					}),
 					layout.Flexed(5, func(gtx C) D {
 						dims := bgSender.Layout(gtx, material.Editor(th, c.compose, "").Layout)
 						t := pointer.PassOp{}.Push(gtx.Ops)
@@ -393,6 +643,12 @@ func (c *conversationPage) Layout(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+func init() {
+	handle(func(a *App, e ChooseContactClick) interface{} {
+		return Push{newConversationPage(a, e.nickname)}
+	})
+}
+
 func newConversationPage(a *App, nickname string) *conversationPage {
 	ed := &widget.Editor{SingleLine: false, Submit: true}
 	if runtime.GOOS == "android" {
@@ -400,15 +656,25 @@ func newConversationPage(a *App, nickname string) *conversationPage {
 	}
 
 	p := &conversationPage{a: a, nickname: nickname,
-		compose:       ed,
-		messageClicks: make(map[*catshadow.Message]*gesture.Click),
-		back:          &widget.Clickable{},
-		msgcopy:       &widget.Clickable{},
-		msgpaste:      NewLongPress(a.w.Invalidate, 800*time.Millisecond),
-		msgdetails:    &widget.Clickable{},
-		cancel:        new(gesture.Click),
-		send:          &widget.Clickable{},
-		edit:          new(gesture.Click),
+		compose:        ed,
+		messageList:    &layout.List{Axis: layout.Vertical, ScrollToEnd: true},
+		fragments:      newReassembler(a.payloadLen()),
+		attachments:    make(map[*catshadow.Message]*attachmentView),
+		requests:       make(map[*catshadow.Message]bool),
+		messageClicks:  make(map[*catshadow.Message]*gesture.Click),
+		back:           &widget.Clickable{},
+		msgcopy:        &widget.Clickable{},
+		msgpaste:       NewLongPress(a.w.Invalidate, 800*time.Millisecond),
+		msgdetails:     &widget.Clickable{},
+		msgsave:        &widget.Clickable{},
+		msgdelete:      &widget.Clickable{},
+		deleteSelected: &widget.Clickable{},
+		cancelSelect:   &widget.Clickable{},
+		askDelete:      &widget.Bool{},
+		cancel:         new(gesture.Click),
+		send:           &widget.Clickable{},
+		attach:         &widget.Clickable{},
+		edit:           new(gesture.Click),
 	}
 	return p
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// catshadow can only wipe a whole conversation, so deleted messages are
+// recorded by key in a blob per contact and hidden from display while the
+// client runs. They are removed from the statefile when it is next opened,
+// before catshadow loads it, or once every message in the conversation is
+// deleted and the conversation is wiped.
+const (
+	// deletedBlobPrefix is the blob id prefix of the deleted message keys
+	// of a conversation
+	deletedBlobPrefix = "deleted://"
+
+	// contentTypeDelete is the content type of a request from a contact to
+	// delete the messages listed in the body
+	contentTypeDelete = "application/x-katzen-delete"
+)
+
+// messageKey identifies a message by its plaintext, which is the same in
+// the conversations of both contacts. Envelopes carry a random nonce so that
+// repeated messages have different keys.
+func messageKey(msg *catshadow.Message) string {
+	sum := sha256.Sum256(msg.Plaintext)
+	return hex.EncodeToString(sum[:16])
+}
+
+// newDeleteRequest returns an envelope asking a contact to delete the
+// messages with keys
+func newDeleteRequest(keys []string) (*Envelope, error) {
+	b, err := cbor.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{ContentType: contentTypeDelete, Body: b}, nil
+}
+
+// IsDeleteRequest returns true if the envelope asks to delete messages
+func (e *Envelope) IsDeleteRequest() bool {
+	return e.ContentType == contentTypeDelete
+}
+
+// deletionCache holds the deleted message keys of each conversation, and
+// the keys of the messages last looked up in it, so that the blob is not
+// decoded and the messages are not hashed again on every frame
+type deletionCache struct {
+	sync.Mutex
+	deleted  map[string]map[string]bool
+	messages map[string]map[*catshadow.Message]string
+}
+
+func newDeletionCache() *deletionCache {
+	return &deletionCache{
+		deleted:  make(map[string]map[string]bool),
+		messages: make(map[string]map[*catshadow.Message]string),
+	}
+}
+
+// forget drops the cached keys of the conversation with nickname
+func (d *deletionCache) forget(nickname string) {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.deleted, nickname)
+	delete(d.messages, nickname)
+}
+
+// keys returns the keys of messages in the conversation with nickname. Only
+// the keys of these messages are kept for the next call.
+func (d *deletionCache) keys(nickname string, messages catshadow.Messages) []string {
+	d.Lock()
+	defer d.Unlock()
+	prev := d.messages[nickname]
+	next := make(map[*catshadow.Message]string, len(messages))
+	keys := make([]string, len(messages))
+	for i, msg := range messages {
+		k, ok := prev[msg]
+		if !ok {
+			k = messageKey(msg)
+		}
+		next[msg], keys[i] = k, k
+	}
+	d.messages[nickname] = next
+	return keys
+}
+
+// deletedKeys returns the keys of the deleted messages in the conversation
+// with nickname, which must not be modified
+func (a *App) deletedKeys(nickname string) map[string]bool {
+	a.deletions.Lock()
+	defer a.deletions.Unlock()
+	if deleted, ok := a.deletions.deleted[nickname]; ok {
+		return deleted
+	}
+	deleted := make(map[string]bool)
+	if b, err := a.c.GetBlob(deletedBlobPrefix + nickname); err == nil {
+		var keys []string
+		if err := cbor.Unmarshal(b, &keys); err == nil {
+			for _, k := range keys {
+				deleted[k] = true
+			}
+		}
+	}
+	a.deletions.deleted[nickname] = deleted
+	return deleted
+}
+
+// isDeleted returns true if msg was deleted from the conversation with
+// nickname
+func (a *App) isDeleted(nickname string, msg *catshadow.Message) bool {
+	deleted := a.deletedKeys(nickname)
+	return len(deleted) > 0 && deleted[messageKey(msg)]
+}
+
+// visibleMessages returns messages without the deleted ones
+func (a *App) visibleMessages(nickname string, messages catshadow.Messages) catshadow.Messages {
+	deleted := a.deletedKeys(nickname)
+	if len(deleted) == 0 {
+		return messages
+	}
+	out := make(catshadow.Messages, 0, len(messages))
+	for i, k := range a.deletions.keys(nickname, messages) {
+		if !deleted[k] {
+			out = append(out, messages[i])
+		}
+	}
+	return out
+}
+
+// deleteMessages deletes msgs, which are stored messages and not reassembled
+// ones, from the conversation with nickname. If askContact is set, the
+// contact is asked to delete its copy too.
+func (a *App) deleteMessages(nickname string, msgs catshadow.Messages, askContact bool) error {
+	keys := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		keys = append(keys, messageKey(msg))
+	}
+	if askContact {
+		e, err := newDeleteRequest(keys)
+		if err != nil {
+			return err
+		}
+		if _, err := a.sendEnvelope(nickname, e); err != nil {
+			return err
+		}
+	}
+	a.forgetAttachments(nickname, attachmentSums(newReassembler(a.payloadLen()).Messages(msgs))...)
+	return a.deleteKeys(nickname, keys)
+}
+
+// deleteKeys adds keys to the deleted messages of the conversation with
+// nickname, and wipes the conversation once no message in it is left
+func (a *App) deleteKeys(nickname string, keys []string) error {
+	deleted := make(map[string]bool)
+	for k := range a.deletedKeys(nickname) {
+		deleted[k] = true
+	}
+	for _, k := range keys {
+		deleted[k] = true
+	}
+
+	// only the keys of messages still in the conversation are kept, as the
+	// others have expired
+	remaining := false
+	var kept []string
+	for _, msg := range a.c.GetSortedConversation(nickname) {
+		k := messageKey(msg)
+		switch {
+		case deleted[k]:
+			kept = append(kept, k)
+		case !isDeleteRequest(msg):
+			remaining = true
+		}
+	}
+	// the search index must not return the deleted messages
+	defer a.indexConversation(nickname)
+	defer a.deletions.forget(nickname)
+	if !remaining {
+		return a.wipeConversation(nickname)
+	}
+	b, err := cbor.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return a.c.AddBlob(deletedBlobPrefix+nickname, b)
+}
+
+// wipeConversation wipes the conversation with nickname and the keys of the
+// messages deleted from it
+func (a *App) wipeConversation(nickname string) error {
+	a.deleteAttachments(nickname)
+	a.c.DeleteBlob(deletedBlobPrefix + nickname)
+	a.deletions.forget(nickname)
+	return a.c.WipeConversation(nickname)
+}
+
+// isDeleteRequest returns true if msg is a delete request that was not
+// fragmented
+func isDeleteRequest(msg *catshadow.Message) bool {
+	e, err := decodeEnvelope(msg.Plaintext)
+	return err == nil && e.IsDeleteRequest()
+}
+
+// applyDeleteRequests deletes the messages that nickname asked to delete,
+// along with the requests themselves
+func (a *App) applyDeleteRequests(nickname string) {
+	r := newReassembler(a.payloadLen())
+	messages := r.Messages(a.visibleMessages(nickname, a.c.GetSortedConversation(nickname)))
+	var keys []string
+	for _, msg := range messages {
+		if msg.Outbound || len(msg.Plaintext) == 0 {
+			continue
+		}
+		e, err := decodeEnvelope(msg.Plaintext)
+		if err != nil || !e.IsDeleteRequest() {
+			continue
+		}
+		var requested []string
+		if err := cbor.Unmarshal(e.Body, &requested); err != nil {
+			continue
+		}
+		keys = append(keys, requested...)
+		for _, part := range r.Parts(msg) {
+			keys = append(keys, messageKey(part))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	// the attachments stored from the messages are removed with them
+	requested := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		requested[k] = true
+	}
+	var deleted catshadow.Messages
+	for _, msg := range messages {
+		for _, part := range r.Parts(msg) {
+			if part != nil && requested[messageKey(part)] {
+				deleted = append(deleted, msg)
+				break
+			}
+		}
+	}
+	a.forgetAttachments(nickname, attachmentSums(deleted)...)
+	a.deleteKeys(nickname, keys)
+}
+
+// purgeDeleted removes the deleted messages from the conversations in s, and
+// the blobs recording them. It returns true if s was changed.
+func purgeDeleted(s *catshadow.State) bool {
+	changed := false
+	for id, b := range s.Blob {
+		nickname, ok := strings.CutPrefix(id, deletedBlobPrefix)
+		if !ok {
+			continue
+		}
+		delete(s.Blob, id)
+		changed = true
+		var keys []string
+		if err := cbor.Unmarshal(b, &keys); err != nil {
+			continue
+		}
+		deleted := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			deleted[k] = true
+		}
+		conversation := s.Conversations[nickname]
+		for msgID, msg := range conversation {
+			if deleted[messageKey(msg)] {
+				// as catshadow wipes a conversation
+				clear(msg.Plaintext)
+				delete(conversation, msgID)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+func TestDeleteMessages(t *testing.T) {
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	a := newTestApp(f)
+	for _, text := range []string{"ok", "ok", "bye"} {
+		if _, err := a.sendEnvelope("bob", newTextEnvelope(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := f.GetSortedConversation("bob")
+	if len(messages) != 4 {
+		t.Fatalf("conversation has %d messages, want 4", len(messages))
+	}
+
+	// repeated messages are deleted one at a time
+	if err := a.deleteMessages("bob", catshadow.Messages{messages[1]}, false); err != nil {
+		t.Fatal(err)
+	}
+	visible := a.visibleMessages("bob", f.GetSortedConversation("bob"))
+	if len(visible) != 3 {
+		t.Fatalf("%d messages visible after deleting one, want 3", len(visible))
+	}
+	for _, msg := range visible {
+		if msg == messages[1] {
+			t.Error("deleted message is visible")
+		}
+	}
+	if !a.isDeleted("bob", messages[1]) || a.isDeleted("bob", messages[2]) {
+		t.Error("isDeleted does not match the deleted message")
+	}
+	if a.deletedKeys("alice")[messageKey(messages[1])] {
+		t.Error("deletion leaked into another conversation")
+	}
+
+	// deleting the remaining messages wipes the conversation
+	if err := a.deleteMessages("bob", visible, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.GetSortedConversation("bob")); n != 0 {
+		t.Errorf("%d messages left after deleting all of them", n)
+	}
+	if _, err := f.GetBlob(deletedBlobPrefix + "bob"); err == nil {
+		t.Error("deleted keys were kept after wiping the conversation")
+	}
+	if len(f.GetSortedConversation("alice")) == 0 {
+		t.Error("another conversation was wiped")
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 128
+	discardEvents(f)
+	a := newTestApp(f)
+	a.stack.Push(&stubPage{name: "home"})
+	now := time.Now()
+	first := receiveEnvelope(t, f, "bob", newTextEnvelope("delete me"), now)
+	receiveEnvelope(t, f, "bob", newTextEnvelope("keep me"), now.Add(time.Second))
+	discardEvents(f)
+
+	keys := make([]string, 0, len(first))
+	for _, part := range first {
+		keys = append(keys, messageKey(&catshadow.Message{Plaintext: part}))
+	}
+	// pad the request so that it is fragmented as well
+	for i := 0; i < 8; i++ {
+		keys = append(keys, messageKey(&catshadow.Message{Plaintext: []byte{byte(i)}}))
+	}
+	e, err := newDeleteRequest(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := receiveEnvelope(t, f, "bob", e, now.Add(2*time.Second)); len(parts) < 2 {
+		t.Fatal("delete request was not fragmented")
+	}
+	drainEvents(a, f)
+
+	messages := newReassembler(a.payloadLen()).Messages(a.visibleMessages("bob", f.GetSortedConversation("bob")))
+	var texts []string
+	for _, msg := range messages {
+		texts = append(texts, messageText(msg.Plaintext))
+	}
+	if strings.Join(texts, ", ") != "meow, keep me" {
+		t.Errorf("visible messages are %q, want the deleted message and the request hidden", texts)
+	}
+}
+
+func TestDeleteRequestNotifications(t *testing.T) {
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	a := newTestApp(f)
+	a.stack.Push(&stubPage{name: "home"})
+	e, err := newDeleteRequest([]string{messageKey(f.GetSortedConversation("bob")[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveEnvelope(t, f, "bob", e, time.Now())
+	drainEvents(a, f)
+	if n := len(f.GetSortedConversation("bob")); n != 0 {
+		t.Errorf("%d messages left, want the conversation wiped", n)
+	}
+	if a.state.notifications["bob"] != nil {
+		t.Error("delete request raised a notification")
+	}
+}
+
+func TestConversationPageDelete(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "alice")
+	})
+	h.frames(2)
+	c := h.current().(*conversationPage)
+	messages := f.GetSortedConversation("alice")
+	c.messageClicked = messages[0]
+	c.msgdelete.Click()
+	h.frames(2)
+	if !c.selected[messages[0]] || c.messageClicked != nil {
+		t.Fatal("delete did not start selecting messages")
+	}
+	c.askDelete.Value = true
+	c.deleteSelected.Click()
+	h.frames(2)
+	if c.selected != nil {
+		t.Error("selection was kept after deleting")
+	}
+
+	conversation := f.GetSortedConversation("alice")
+	last := conversation[len(conversation)-1]
+	e, err := decodeEnvelope(last.Plaintext)
+	if err != nil || !e.IsDeleteRequest() || !last.Outbound {
+		t.Fatal("contact was not asked to delete the message")
+	}
+	if !bytes.Contains(e.Body, []byte(messageKey(messages[0]))) {
+		t.Error("delete request does not list the message")
+	}
+	if !h.a.isDeleted("alice", messages[0]) || h.a.isDeleted("alice", messages[1]) {
+		t.Error("wrong messages were deleted")
+	}
+}
+
+func TestDeletedKeysCache(t *testing.T) {
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	a := newTestApp(f)
+	messages := f.GetSortedConversation("alice")
+	if len(a.visibleMessages("alice", messages)) != len(messages) {
+		t.Fatal("messages hidden before deleting any")
+	}
+
+	// the keys are not decoded again until they change
+	if err := f.AddBlob(deletedBlobPrefix+"alice", []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.visibleMessages("alice", messages)) != len(messages) {
+		t.Error("deleted keys were decoded again without a change")
+	}
+	if err := a.deleteMessages("alice", messages[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.visibleMessages("alice", messages)) != len(messages)-1 {
+		t.Error("deleting did not update the cached keys")
+	}
+
+	if err := a.wipeConversation("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.deletedKeys("alice")) != 0 {
+		t.Error("deleted keys were kept after wiping the conversation")
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	deleted := &catshadow.Message{Plaintext: []byte("delete me")}
+	kept := &catshadow.Message{Plaintext: []byte("keep me")}
+	keys, err := cbor.Marshal([]string{messageKey(deleted)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &catshadow.State{
+		Conversations: map[string]map[catshadow.MessageID]*catshadow.Message{
+			"alice": {{1}: deleted, {2}: kept},
+		},
+		Blob: map[string][]byte{deletedBlobPrefix + "alice": keys},
+	}
+	if !purgeDeleted(s) {
+		t.Fatal("state was not changed")
+	}
+	if len(s.Conversations["alice"]) != 1 || s.Conversations["alice"][catshadow.MessageID{2}] != kept {
+		t.Errorf("conversation is %v, want the kept message only", s.Conversations["alice"])
+	}
+	if !bytes.Equal(deleted.Plaintext, make([]byte, len("delete me"))) {
+		t.Errorf("deleted message still holds %q", deleted.Plaintext)
+	}
+	if purgeDeleted(s) {
+		t.Error("state was changed again")
+	}
+}
+
+func TestPurgeDeletedOnUnlock(t *testing.T) {
+	s := newBrokenState(t)
+	s.Conversations["alice"][catshadow.MessageID{3}] = &catshadow.Message{Plaintext: []byte("delete me"), Timestamp: time.Now()}
+	keys, err := cbor.Marshal([]string{messageKey(&catshadow.Message{Plaintext: []byte("delete me")})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Blob[deletedBlobPrefix+"alice"] = keys
+	statefile := filepath.Join(t.TempDir(), "statefile")
+	if err := saveState(statefile, s, []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statefile+"~", []byte("previous state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the issues of the broken state stop the unlock before the client starts
+	_, r := runUnlock(func(ctx context.Context, result chan interface{}) {
+		setupCatShadow(ctx, statefile, "", testSecret(t, "hunter2"), true, result)
+	})
+	report, ok := r.(*stateReport)
+	if !ok {
+		t.Fatalf("result is %v, want a *stateReport", r)
+	}
+	if len(report.state.Conversations["alice"]) != 1 {
+		t.Error("deleted message was loaded")
+	}
+	_, saved, err := catshadow.LoadStateWriter(nil, statefile, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Conversations["alice"][catshadow.MessageID{3}]; ok {
+		t.Error("deleted message is still in the statefile")
+	}
+	if len(saved.Conversations["alice"]) != 1 {
+		t.Error("message that was not deleted was removed")
+	}
+	if _, ok := saved.Blob[deletedBlobPrefix+"alice"]; ok {
+		t.Error("deleted keys were kept after removing the messages")
+	}
+	if _, err := os.Stat(statefile + "~"); !os.IsNotExist(err) {
+		t.Error("backup with the deleted message was kept")
+	}
+}
+
+func TestConversationPageDeleteError(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "alice")
+	})
+	h.frames(2)
+	c := h.current().(*conversationPage)
+	messages := f.GetSortedConversation("alice")
+	c.selected = map[*catshadow.Message]bool{messages[0]: true}
+	c.askDelete.Value = true
+	// the delete request does not fit in a payload this short
+	f.payloadLen = payloadOverhead + fragmentHeaderLen
+	c.deleteSelected.Click()
+	h.frames(2)
+	if !strings.Contains(c.errMsg, errMessageTooLong.Error()) {
+		t.Errorf("error shown is %q, want %q", c.errMsg, errMessageTooLong)
+	}
+	if c.selected == nil {
+		t.Error("selection was dropped after failing to delete")
+	}
+	if h.a.isDeleted("alice", messages[0]) {
+		t.Error("message was deleted without asking the contact")
+	}
+}
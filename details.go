@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// MessageDetailsPage shows the delivery details of a message, for debugging
+// delivery problems
+type MessageDetailsPage struct {
+	a        *App
+	nickname string
+	msg      *catshadow.Message
+	parts    catshadow.Messages
+	back     *widget.Clickable
+	list     *layout.List
+}
+
+// ShowMessageDetails is the event that opens the MessageDetailsPage for msg,
+// which was reassembled from parts
+type ShowMessageDetails struct {
+	nickname string
+	msg      *catshadow.Message
+	parts    catshadow.Messages
+}
+
+// detail is a row of the message details
+type detail struct {
+	name, value string
+}
+
+// details returns the rows shown for the message
+func (p *MessageDetailsPage) details() []detail {
+	msg := p.msg
+	rows := make([]detail, 0, 8+len(p.parts))
+	if msg.Outbound {
+		rows = append(rows, detail{"Direction", "Sent to " + p.nickname})
+	} else {
+		rows = append(rows, detail{"Direction", "Received from " + p.nickname})
+	}
+	rows = append(rows, detail{"Created", msg.Timestamp.Format(time.RFC1123)})
+	if msg.Outbound {
+		rows = append(rows, detail{"Sent", yesNo(msg.Sent)}, detail{"Delivered", yesNo(msg.Delivered)})
+	}
+
+	payloadLen := p.a.payloadLen()
+	size, received := 0, 0
+	for _, part := range p.parts {
+		if part != nil {
+			size += len(part.Plaintext)
+			received++
+		}
+	}
+	if len(p.parts) == 1 {
+		rows = append(rows, detail{"Size", fmt.Sprintf("%d of %d bytes", size, payloadLen)})
+	} else {
+		rows = append(rows, detail{"Size", fmt.Sprintf("%d bytes in %d/%d parts of at most %d bytes", size, received, len(p.parts), payloadLen)})
+	}
+
+	expires, err := p.a.c.GetExpiration(p.nickname)
+	switch {
+	case err != nil:
+		rows = append(rows, detail{"Expires", err.Error()})
+	case expires == 0:
+		rows = append(rows, detail{"Expires", "Never"})
+	default:
+		rows = append(rows, detail{"Expires", msg.Timestamp.Add(expires).Format(time.RFC1123)})
+	}
+
+	for i, part := range p.parts {
+		name := "Message ID"
+		if len(p.parts) > 1 {
+			name = fmt.Sprintf("Part %d/%d", i+1, len(p.parts))
+		}
+		if part == nil {
+			rows = append(rows, detail{name, "not received"})
+			continue
+		}
+		r, ok := p.a.messages.Lookup(p.nickname, part)
+		if !ok {
+			if part.Outbound {
+				rows = append(rows, detail{name, "unknown, sent before katzen was started"})
+			} else {
+				rows = append(rows, detail{name, "not available for received messages"})
+			}
+			continue
+		}
+		value := fmt.Sprintf("%x", r.id[:])
+		if !r.sent.IsZero() {
+			value += "\nsent " + r.sent.Format(time.Kitchen)
+		}
+		if !r.delivered.IsZero() {
+			value += "\ndelivered " + r.delivered.Format(time.Kitchen)
+		}
+		rows = append(rows, detail{name, value})
+		for _, f := range r.failures {
+			rows = append(rows, detail{"Not sent", f.when.Format(time.Kitchen) + ": " + f.err.Error()})
+		}
+	}
+	return rows
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
+
+// Layout returns the message details as a list of name and value rows
+func (p *MessageDetailsPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	rows := p.details()
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.End}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Message Details").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(rows), func(gtx C, i int) D {
+					return layout.Flex{Alignment: layout.Start}.Layout(gtx,
+						layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+							return inset.Layout(gtx, material.Body2(th, rows[i].name).Layout)
+						}),
+						layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+							return inset.Layout(gtx, material.Body2(th, rows[i].value).Layout)
+						}),
+					)
+				})
+			}),
+		)
+	})
+}
+
+func (p *MessageDetailsPage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	return nil
+}
+
+func (p *MessageDetailsPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, e ShowMessageDetails) interface{} {
+		return Push{newMessageDetailsPage(a, e.nickname, e.msg, e.parts)}
+	})
+}
+
+func newMessageDetailsPage(a *App, nickname string, msg *catshadow.Message, parts catshadow.Messages) *MessageDetailsPage {
+	return &MessageDetailsPage{
+		a:        a,
+		nickname: nickname,
+		msg:      msg,
+		parts:    parts,
+		back:     &widget.Clickable{},
+		list:     &layout.List{Axis: layout.Vertical},
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// drainEvents passes the events emitted by f to the App, as App.run does
+func drainEvents(a *App, f *fakeMessenger) {
+	for len(f.events) > 0 {
+		a.handleCatshadowEvent(<-f.events)
+	}
+}
+
+// discardEvents drops the events emitted while populating f
+func discardEvents(f *fakeMessenger) {
+	for len(f.events) > 0 {
+		<-f.events
+	}
+}
+
+func detailValue(rows []detail, name string) (string, bool) {
+	for _, r := range rows {
+		if r.name == name {
+			return r.value, true
+		}
+	}
+	return "", false
+}
+
+func TestMessageLogLookup(t *testing.T) {
+	l := newMessageLog()
+	var first, second catshadow.MessageID
+	first[0], second[0] = 1, 2
+	l.Sent("alice", first, []byte("ok"))
+	time.Sleep(time.Millisecond)
+	l.Sent("alice", second, []byte("ok"))
+	l.Event(&catshadow.MessageNotSentEvent{Nickname: "alice", MessageID: second, Err: errors.New("no route")})
+
+	// identical messages are matched in the order they were sent
+	m1 := &catshadow.Message{Plaintext: []byte("ok"), Outbound: true}
+	m2 := &catshadow.Message{Plaintext: []byte("ok"), Outbound: true}
+	r1, ok := l.Lookup("alice", m1)
+	if !ok || r1.id != first {
+		t.Fatalf("first message matched %x, want %x", r1.id, first)
+	}
+	r2, ok := l.Lookup("alice", m2)
+	if !ok || r2.id != second {
+		t.Fatalf("second message matched %x, want %x", r2.id, second)
+	}
+	if len(r2.failures) != 1 {
+		t.Errorf("got %d failures, want 1", len(r2.failures))
+	}
+	if r, _ := l.Lookup("alice", m1); r.id != first {
+		t.Error("lookup is not stable")
+	}
+
+	if _, ok := l.Lookup("alice", &catshadow.Message{Plaintext: []byte("ok")}); ok {
+		t.Error("received message matched a sent record")
+	}
+	if _, ok := l.Lookup("bob", &catshadow.Message{Plaintext: []byte("ok"), Outbound: true}); ok {
+		t.Error("message to bob matched a record for alice")
+	}
+}
+
+func TestMessageDetails(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.ChangeExpiration("bob", 24*time.Hour)
+	discardEvents(f)
+	a := newTestApp(f)
+	msgIds, err := a.sendEnvelope("bob", newTextEnvelope("purr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainEvents(a, f)
+
+	messages := f.GetSortedConversation("bob")
+	msg := messages[len(messages)-1]
+	p := newMessageDetailsPage(a, "bob", msg, catshadow.Messages{msg})
+	rows := p.details()
+	want := map[string]string{
+		"Direction":  "Sent to bob",
+		"Sent":       "Yes",
+		"Delivered":  "No",
+		"Size":       fmt.Sprintf("%d of %d bytes", len(msg.Plaintext), f.payloadLen-payloadOverhead),
+		"Expires":    msg.Timestamp.Add(24 * time.Hour).Format(time.RFC1123),
+		"Message ID": fmt.Sprintf("%x", msgIds[0][:]),
+	}
+	for name, value := range want {
+		got, ok := detailValue(rows, name)
+		if !ok {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if !strings.HasPrefix(got, value) {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	p = newMessageDetailsPage(a, "bob", messages[0], catshadow.Messages{messages[0]})
+	if v, _ := detailValue(p.details(), "Message ID"); v != "not available for received messages" {
+		t.Errorf("received message ID = %q", v)
+	}
+}
+
+func TestMessageDetailsFailures(t *testing.T) {
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	a := newTestApp(f)
+	// carol is pending a key exchange, a real client refuses to send
+	var id catshadow.MessageID
+	id[0] = 0xca
+	a.messages.Sent("carol", id, []byte("hello?"))
+	a.handleCatshadowEvent(&catshadow.MessageNotSentEvent{Nickname: "carol", MessageID: id, Err: catshadow.ErrPendingKeyExchange})
+
+	msg := &catshadow.Message{Plaintext: []byte("hello?"), Outbound: true, Timestamp: time.Now()}
+	p := newMessageDetailsPage(a, "carol", msg, catshadow.Messages{msg})
+	v, ok := detailValue(p.details(), "Not sent")
+	if !ok || !strings.Contains(v, catshadow.ErrPendingKeyExchange.Error()) {
+		t.Errorf("failure = %q, want %v", v, catshadow.ErrPendingKeyExchange)
+	}
+}
+
+func TestConversationPageDetails(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "alice")
+	})
+	h.frames(2)
+	c := h.current().(*conversationPage)
+	messages := f.GetSortedConversation("alice")
+	// use a fixed timestamp so that the screenshot is stable
+	messages[0].Timestamp = time.Date(2024, time.September, 1, 12, 0, 0, 0, time.UTC)
+	c.messageClicked = messages[0]
+	c.msgdetails.Click()
+	h.frames(2)
+	p, ok := h.current().(*MessageDetailsPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *MessageDetailsPage", h.current())
+	}
+	if p.msg != messages[0] {
+		t.Error("details opened for the wrong message")
+	}
+	h.screenshot("details")
+}
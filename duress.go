@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"os"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/katzenpost/hpqc/rand"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"golang.org/x/crypto/argon2"
+)
+
+// The duress passphrase is checked before the statefile is unlocked, so its
+// verifier is kept next to the statefile rather than in it, as:
+//
+//	salt (16) || argon2id(passphrase, salt)
+//
+// Removing the duress passphrase fills the verifier with random bytes, so
+// that the file does not tell whether a duress passphrase is set. Entering
+// the duress passphrase at sign in wipes the statefile, and setupCatShadow
+// then creates an empty one with the duress passphrase, so that the real
+// passphrase no longer unlocks anything.
+const (
+	duressExtension = ".verify"
+	duressSaltSize  = 16
+	duressHashSize  = 32
+)
+
+var errDuressIsPassphrase = errors.New("the duress passphrase must differ from the sign in passphrase")
+
+// duressFile returns the path of the duress verifier of statefile
+func duressFile(statefile string) string {
+	return statefile + duressExtension
+}
+
+// duressHash derives the verifier of passphrase
+func duressHash(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, 3, 64*1024, 4, duressHashSize)
+}
+
+// setDuressPassphrase sets the duress passphrase of statefile, or removes it
+// if passphrase is nil
+func setDuressPassphrase(statefile string, passphrase []byte) error {
+	v := make([]byte, duressSaltSize+duressHashSize)
+	if _, err := io.ReadFull(rand.Reader, v); err != nil {
+		return err
+	}
+	if passphrase != nil {
+		copy(v[duressSaltSize:], duressHash(passphrase, v[:duressSaltSize]))
+	}
+	tmp := duressFile(statefile) + ".tmp"
+	os.Remove(tmp)
+	if err := writeFileSync(tmp, v); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, duressFile(statefile))
+}
+
+// isDuressPassphrase returns true if passphrase is the duress passphrase of
+// statefile. The key derivation is run whenever a verifier exists, so that
+// the time taken does not tell whether a duress passphrase is set.
+func isDuressPassphrase(statefile string, passphrase []byte) bool {
+	v, err := os.ReadFile(duressFile(statefile))
+	if err != nil || len(v) != duressSaltSize+duressHashSize {
+		return false
+	}
+	h := duressHash(passphrase, v[:duressSaltSize])
+	return subtle.ConstantTimeCompare(h, v[duressSaltSize:]) == 1
+}
+
+// wipeStatefile overwrites and removes statefile and the files kept next to
+// it: the backup of the StateWriter, the files left by interrupted writes,
+// the duress verifier and the failed unlocks. Nothing else is touched, and
+// links are removed without writing to their target. Overwriting does not
+// reach copies kept by the filesystem or the disk, which is why the
+// statefile is encrypted.
+func wipeStatefile(statefile string) error {
+	var errs []error
+	for _, path := range []string{
+		statefile,
+		statefile + "~",
+		statefile + ".tmp",
+		statefile + ".rekey",
+		statefile + ".restore",
+		duressFile(statefile),
+		duressFile(statefile) + ".tmp",
+		unlockDelayFile(statefile),
+		unlockDelayFile(statefile) + ".tmp",
+	} {
+		if err := wipeFile(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// wipeFile overwrites the regular file at path with random bytes and removes
+// it. Anything other than a regular file is only removed.
+func wipeFile(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().IsRegular() {
+		// O_NOFOLLOW is not needed, as the file was not a link when checked,
+		// and a link put in its place is only removed below
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			if fi2, err := f.Stat(); err == nil && os.SameFile(fi, fi2) {
+				io.CopyN(f, rand.Reader, fi.Size())
+				f.Sync()
+			}
+			f.Close()
+		}
+	}
+	return os.Remove(path)
+}
+
+// unlock wipes the statefile if passphrase is its duress passphrase, and then
+// unlocks or creates the statefile with setupCatShadow. A wipe that fails is
+// not reported: whatever is left of the statefile then fails to decrypt as
+// with a wrong passphrase. The replacement statefile is created without
+// checking the strength of the duress passphrase, as refusing it after
+// -min-score was raised would tell that the statefile was wiped. The
+// passphrase is destroyed when it returns.
+func unlock(ctx context.Context, statefile, configFile string, passphrase *secureBuffer, result chan interface{}) {
+	defer passphrase.destroy()
+	duress := isDuressPassphrase(statefile, passphrase.Bytes())
+	if duress {
+		wipeStatefile(statefile)
+	}
+	setupCatShadow(ctx, statefile, configFile, passphrase, !duress, result)
+}
+
+// setDuress checks that passphrase does not unlock the statefile, and sets it
+// as the duress passphrase
+func (a *App) setDuress(passphrase []byte) error {
+	statefile, err := a.statefile()
+	if err != nil {
+		return err
+	}
+	if passphrase != nil {
+		// the StateWriter is only used to load the state and is not started
+		if _, _, err := catshadow.LoadStateWriter(nil, statefile, passphrase); err == nil {
+			return errDuressIsPassphrase
+		}
+	}
+	return setDuressPassphrase(statefile, passphrase)
+}
+
+// DuressPage sets or removes the duress passphrase
+type DuressPage struct {
+	a       *App
+	back    *widget.Clickable
+	submit  *widget.Clickable
+	remove  *widget.Clickable
+	newpw   *widget.Editor
+	confirm *widget.Editor
+	result  chan error
+	busy    bool
+	// strength is the estimated strength of the duress passphrase
+	strength passphraseStrength
+	errMsg   string
+}
+
+// DuressClick is the event that opens the DuressPage
+type DuressClick struct{}
+
+// Layout returns the duress passphrase form
+func (p *DuressPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Duress Passphrase").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Rigid(func(gtx C) D {
+				msg := "Signing in with the duress passphrase destroys the statefile and opens an empty one. Only the statefile and the files kept next to it are wiped: exported backups, saved attachments and copies kept by the system or the disk are not."
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			field(p.newpw, "Duress passphrase"),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, func(gtx C) D {
+					return layoutStrength(gtx, p.strength)
+				})
+			}),
+			field(p.confirm, "Repeat the duress passphrase"),
+			layout.Rigid(func(gtx C) D {
+				msg := p.errMsg
+				if p.busy {
+					msg = "Saving..."
+				}
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				if p.busy {
+					gtx = gtx.Disabled()
+				}
+				return layout.Flex{Spacing: layout.SpaceEvenly}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.submit, "Set").Layout)
+					}),
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.remove, "Remove").Layout)
+					}),
+				)
+			}),
+		)
+	})
+}
+
+func (p *DuressPage) Event(gtx layout.Context) interface{} {
+	for _, ed := range []*widget.Editor{p.newpw, p.confirm} {
+		if e, ok := ed.Update(gtx); ok {
+			switch e.(type) {
+			case widget.ChangeEvent:
+				if ed == p.newpw {
+					p.strength = editorStrength(ed)
+				}
+			case widget.SubmitEvent:
+				p.submit.Click()
+			}
+		}
+	}
+	if p.back.Clicked(gtx) && !p.busy {
+		return BackEvent{}
+	}
+	var passphrase *secureBuffer
+	switch {
+	case p.busy:
+		// a click while saving is dropped
+		p.submit.Clicked(gtx)
+		p.remove.Clicked(gtx)
+	case p.submit.Clicked(gtx):
+		// the duress passphrase creates the new statefile, so it meets the
+		// same policy as the sign in passphrase
+		var err error
+		if passphrase, err = readNewPassphrase(p.newpw, p.confirm); err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		fallthrough
+	case p.remove.Clicked(gtx):
+		p.errMsg = ""
+		p.busy = true
+		go func() {
+			var b []byte
+			if passphrase != nil {
+				defer passphrase.destroy()
+				b = passphrase.Bytes()
+			}
+			p.result <- p.a.setDuress(b)
+			p.a.w.Invalidate()
+		}()
+	}
+
+	select {
+	case err := <-p.result:
+		p.busy = false
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		return BackEvent{}
+	default:
+	}
+	return nil
+}
+
+func (p *DuressPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, _ DuressClick) interface{} {
+		return Push{newDuressPage(a)}
+	})
+}
+
+func newDuressPage(a *App) *DuressPage {
+	editor := func() *widget.Editor {
+		return &widget.Editor{SingleLine: true, Mask: '*', Submit: true}
+	}
+	return &DuressPage{
+		a:        a,
+		back:     &widget.Clickable{},
+		submit:   &widget.Clickable{},
+		remove:   &widget.Clickable{},
+		newpw:    editor(),
+		confirm:  editor(),
+		result:   make(chan error, 1),
+		strength: estimateStrength(nil),
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+const testDuress = "purple elephant umbrella sings"
+
+// writeUnrelated fills dir with files that a wipe of statefile must not
+// touch, and returns their contents by path
+func writeUnrelated(t *testing.T, dir, statefile string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{
+		filepath.Join(dir, "notes.txt"):                       []byte("notes"),
+		filepath.Join(dir, profileConfigFile):                 []byte("config"),
+		filepath.Join(dir, profileNameFile):                   []byte("Default"),
+		statefile + ".old":                                    []byte("similar name"),
+		statefile + "~~":                                      []byte("similar name"),
+		filepath.Join(dir, "other", filepath.Base(statefile)): []byte("another profile"),
+	}
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// checkUnrelated fails t if any file written by writeUnrelated was changed
+func checkUnrelated(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("unrelated file %s: %v", path, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("unrelated file %s was changed", path)
+		}
+	}
+}
+
+func TestDuressPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statefile")
+	if isDuressPassphrase(path, []byte(testDuress)) {
+		t.Fatal("duress passphrase matched without a verifier")
+	}
+	if err := setDuressPassphrase(path, []byte(testDuress)); err != nil {
+		t.Fatal(err)
+	}
+	if !isDuressPassphrase(path, []byte(testDuress)) {
+		t.Error("duress passphrase does not match")
+	}
+	if isDuressPassphrase(path, []byte("hunter2")) {
+		t.Error("another passphrase matches")
+	}
+	set, err := os.ReadFile(duressFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a removed duress passphrase leaves a verifier of the same size
+	if err := setDuressPassphrase(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := os.ReadFile(duressFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != len(set) {
+		t.Errorf("removed verifier is %d bytes, set one is %d", len(removed), len(set))
+	}
+	if isDuressPassphrase(path, []byte(testDuress)) || isDuressPassphrase(path, nil) {
+		t.Error("duress passphrase matches after removal")
+	}
+}
+
+func TestWipeStatefile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, profileStateFile)
+	writeTestState(t, path, []byte("hunter2"))
+	if err := setDuressPassphrase(path, []byte(testDuress)); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{"~", ".tmp", ".rekey", ".restore"} {
+		if err := os.WriteFile(path+ext, []byte("state"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unrelated := writeUnrelated(t, dir, path)
+
+	// a link in place of the backup is removed without writing to its
+	// target
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(target, []byte("target"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	unrelated[target] = []byte("target")
+	os.Remove(path + "~")
+	if err := os.Symlink(target, path+"~"); err != nil {
+		t.Skip(err)
+	}
+
+	if err := wipeStatefile(path); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := make(map[string]bool)
+	for _, e := range entries {
+		left[filepath.Join(dir, e.Name())] = true
+	}
+	for _, p := range []string{path, path + "~", path + ".tmp", path + ".rekey", path + ".restore", duressFile(path)} {
+		if left[p] {
+			t.Errorf("%s was not removed", p)
+		}
+	}
+	for p := range left {
+		if _, ok := unrelated[p]; !ok && p != filepath.Join(dir, "other") {
+			t.Errorf("unexpected file %s", p)
+		}
+	}
+	checkUnrelated(t, unrelated)
+
+	// wiping again finds nothing to do
+	if err := wipeStatefile(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignInDuress(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("hunter2"))
+	if err := setDuressPassphrase(path, []byte(testDuress)); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := writeUnrelated(t, filepath.Dir(path), path)
+	// the configuration is missing, so setupCatShadow stops before it
+	// creates a statefile
+	missing := filepath.Join(t.TempDir(), "missing.toml")
+
+	runUnlock(func(ctx context.Context, result chan interface{}) {
+		unlock(ctx, path, missing, testSecret(t, "wrong passphrase"), result)
+	})
+	if _, _, err := catshadow.LoadStateWriter(nil, path, []byte("hunter2")); err != nil {
+		t.Fatalf("statefile was changed by a wrong passphrase: %v", err)
+	}
+	if !isDuressPassphrase(path, []byte(testDuress)) {
+		t.Fatal("duress passphrase was changed by a wrong passphrase")
+	}
+
+	runUnlock(func(ctx context.Context, result chan interface{}) {
+		unlock(ctx, path, missing, testSecret(t, testDuress), result)
+	})
+	for _, p := range []string{path, path + "~", duressFile(path)} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was not wiped", p)
+		}
+	}
+	checkUnrelated(t, unrelated)
+	// the new statefile is created with the duress passphrase
+	if err := checkPassphraseStrength([]byte(testDuress)); err != nil {
+		t.Error(err)
+	}
+}
+
+// unlockStages runs unlock until it reaches stageTor, and returns the error
+// it stopped with before that, if any
+func unlockStages(t *testing.T, statefile string, passphrase string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlock(ctx, statefile, "", testSecret(t, passphrase), result)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for r := range result {
+		switch r := r.(type) {
+		case unlockProgress:
+			if r.stage == stageTor {
+				return nil
+			}
+		case error:
+			return r
+		default:
+			t.Fatalf("unexpected result %v", r)
+		}
+	}
+	return nil
+}
+
+func TestSignInDuressStrength(t *testing.T) {
+	prev := *minScore
+	defer func() { *minScore = prev }()
+	*minScore = 3
+	// scores 2, and could be set as the duress passphrase before the
+	// minimum score was raised
+	duress := "kitten mittens"
+
+	// a new statefile is not created with a weak passphrase
+	path := filepath.Join(t.TempDir(), "statefile")
+	if err := unlockStages(t, path, duress); !errors.Is(err, errWeakPassphrase) {
+		t.Errorf("got %v creating a statefile with a weak passphrase, want errWeakPassphrase", err)
+	}
+
+	// but the one replacing a wiped statefile is
+	writeTestState(t, path, []byte("hunter2"))
+	if err := setDuressPassphrase(path, []byte(duress)); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlockStages(t, path, duress); err != nil {
+		t.Errorf("duress passphrase was refused after the wipe: %v", err)
+	}
+}
+
+func TestDuressPage(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("correct horse battery staple"))
+	h := newPageHarness(t, newPopulatedMessenger(), func(a *App) Page {
+		return newSettingsPage(a)
+	})
+	h.a.navigate(DuressClick{})
+	p, ok := h.current().(*DuressPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *DuressPage", h.current())
+	}
+	wait := func() {
+		h.frames(2)
+		deadline := time.Now().Add(10 * time.Second)
+		for p.busy && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			h.frame()
+		}
+	}
+
+	p.newpw.SetText("correct horse battery staple")
+	p.confirm.SetText("correct horse battery staple")
+	p.submit.Click()
+	wait()
+	if p.errMsg != errDuressIsPassphrase.Error() {
+		t.Fatalf("got %q setting the sign in passphrase as duress passphrase", p.errMsg)
+	}
+
+	p.newpw.SetText(testDuress)
+	p.confirm.SetText(testDuress)
+	p.submit.Click()
+	wait()
+	if _, ok := h.current().(*SettingsPage); !ok {
+		t.Fatalf("current page is %T after setting, want *SettingsPage: %q", h.current(), p.errMsg)
+	}
+	if !isDuressPassphrase(path, []byte(testDuress)) {
+		t.Fatal("duress passphrase was not set")
+	}
+
+	// the sign in passphrase cannot be changed to the duress passphrase
+	if r := h.a.changePassphrase([]byte("correct horse battery staple"), []byte(testDuress)); r.err != errDuressIsPassphrase || r.stopped {
+		t.Errorf("changing to the duress passphrase: %+v", r)
+	}
+
+	h.a.navigate(DuressClick{})
+	p = h.current().(*DuressPage)
+	p.remove.Click()
+	wait()
+	if isDuressPassphrase(path, []byte(testDuress)) {
+		t.Error("duress passphrase was not removed")
+	}
+}
@@ -103,7 +103,7 @@ func (p *EditContactPage) Event(gtx layout.Context) interface{} {
 	}
 	if p.clear.Clicked(gtx) {
 		// TODO: confirmation dialog
-		p.a.c.WipeConversation(p.nickname)
+		p.a.wipeConversation(p.nickname)
 		return EditContactComplete{nickname: p.nickname}
 	}
 	if p.expiry.Update(gtx) {
@@ -115,9 +115,7 @@ func (p *EditContactPage) Event(gtx layout.Context) interface{} {
 	if p.remove.Clicked(gtx) {
 		// TODO: confirmation dialog
 		p.a.c.RemoveContact(p.nickname)
-		p.a.c.DeleteBlob("avatar://" + p.nickname)
-		// remove avatar cache
-		delete(avatars, p.nickname)
+		p.a.deleteContactBlobs(p.nickname)
 		return EditContactComplete{nickname: p.nickname}
 	}
 	if p.apply.Clicked(gtx) {
@@ -130,6 +128,24 @@ func (p *EditContactPage) Event(gtx layout.Context) interface{} {
 func (p *EditContactPage) Start(stop <-chan struct{}) {
 }
 
+func init() {
+	handle(func(a *App, e EditContact) interface{} {
+		return Push{newEditContactPage(a, e.nickname)}
+	})
+	// the conversation was wiped, or the contact renamed or removed
+	handle(func(a *App, _ EditContactComplete) interface{} {
+		return popTo[*HomePage]()
+	})
+}
+
+// Result receives the image chosen with the AvatarPicker
+func (p *EditContactPage) Result(v interface{}) {
+	switch v := v.(type) {
+	case AvatarSelected:
+		p.a.setAvatar(p.nickname, v.path)
+	}
+}
+
 func newEditContactPage(a *App, contact string) *EditContactPage {
 	p := &EditContactPage{a: a, nickname: contact, back: &widget.Clickable{},
 		avatar: &gesture.Click{}, clear: &widget.Clickable{},
@@ -142,7 +158,7 @@ func newEditContactPage(a *App, contact string) *EditContactPage {
 	p.widgets = []layout.Widget{
 		func(gtx C) D {
 			dims := layout.Center.Layout(gtx, func(gtx C) D {
-				return layoutAvatar(gtx, p.a.c, p.nickname)
+				return p.a.layoutAvatar(gtx, p.nickname)
 			})
 			a := clip.Rect(image.Rectangle{Max: dims.Size})
 			t := a.Push(gtx.Ops)
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// EditProfilePage renames a profile, sets its client configuration, or
+// deletes it
+type EditProfilePage struct {
+	a       *App
+	profile *profile
+	back    *widget.Clickable
+	name    *widget.Editor
+	config  *widget.Editor
+	save    *widget.Clickable
+	delete  *widget.Clickable
+	// confirmDelete is set after the first click on delete, as deleting a
+	// profile destroys its statefile
+	confirmDelete bool
+	errMsg        string
+}
+
+// Layout returns the profile form
+func (p *EditProfilePage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Edit Profile").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			field(p.name, "Profile name"),
+			layout.Rigid(func(gtx C) D {
+				msg := "Uses the default client configuration"
+				if p.profile.configFile() != "" {
+					msg = "Uses its own client configuration, clear to use the default"
+				}
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			field(p.config, "Client configuration file"),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, material.Caption(th, p.errMsg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, material.Button(th, p.save, "Save").Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				label := "Delete"
+				if p.confirmDelete {
+					label = "Delete profile and its statefile"
+				}
+				b := material.Button(th, p.delete, label)
+				b.Background = rgb(0x993333)
+				return inset.Layout(gtx, b.Layout)
+			}),
+		)
+	})
+}
+
+// Event handles the form
+func (p *EditProfilePage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	for _, ed := range []*widget.Editor{p.name, p.config} {
+		if ev, ok := ed.Update(gtx); ok {
+			if _, ok := ev.(widget.SubmitEvent); ok {
+				p.save.Click()
+			}
+		}
+	}
+	if p.save.Clicked(gtx) {
+		p.confirmDelete = false
+		if err := p.a.profiles.Rename(p.profile, p.name.Text()); err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		// the configuration is copied into the profile, so it is only
+		// changed when a path is entered or the field is cleared
+		if path := p.config.Text(); path != "" || p.profile.configFile() != "" {
+			if err := p.a.profiles.SetConfig(p.profile, path); err != nil {
+				p.errMsg = err.Error()
+				return nil
+			}
+		}
+		return BackEvent{}
+	}
+	if p.delete.Clicked(gtx) {
+		if !p.confirmDelete {
+			p.confirmDelete = true
+			p.errMsg = fmt.Sprintf("The contacts and messages of %s will be lost", p.profile.name)
+			return nil
+		}
+		if err := p.a.profiles.Delete(p.profile); err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		return BackEvent{}
+	}
+	return nil
+}
+
+func (p *EditProfilePage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, e EditProfileClick) interface{} {
+		return Push{newEditProfilePage(a, e.profile)}
+	})
+}
+
+func newEditProfilePage(a *App, pr *profile) *EditProfilePage {
+	p := &EditProfilePage{
+		a:       a,
+		profile: pr,
+		back:    &widget.Clickable{},
+		name:    &widget.Editor{SingleLine: true, Submit: true},
+		config:  &widget.Editor{SingleLine: true, Submit: true},
+		save:    &widget.Clickable{},
+		delete:  &widget.Clickable{},
+	}
+	p.name.SetText(pr.name)
+	if path := pr.configFile(); path != "" {
+		p.config.SetText(path)
+	}
+	return p
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/hpqc/rand"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// Messages are sent wrapped in an envelope that carries a content type, the
+// body and optional metadata, encoded as:
+//
+//	magic (4) || version (1) || CBOR(Envelope)
+//
+// Plaintext without the magic was sent by an older katzen and is treated as
+// a text message.
+const (
+	envelopeMagic   = "\x00kze"
+	envelopeVersion = 1
+
+	contentTypeText = "text/plain"
+
+	// metaNonce is a random value added to each envelope sent, so that
+	// repeated messages have different plaintexts
+	metaNonce = "nonce"
+)
+
+var errUnsupportedEnvelope = errors.New("unsupported message envelope")
+
+// Envelope is the versioned container for a message body
+type Envelope struct {
+	ContentType string            `cbor:"1,keyasint"`
+	Body        []byte            `cbor:"2,keyasint"`
+	Metadata    map[string]string `cbor:"3,keyasint,omitempty"`
+}
+
+// newTextEnvelope returns an envelope holding a text message
+func newTextEnvelope(text string) *Envelope {
+	return &Envelope{ContentType: contentTypeText, Body: []byte(text)}
+}
+
+// Marshal returns the wire encoding of the envelope
+func (e *Envelope) Marshal() ([]byte, error) {
+	b, err := cbor.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(envelopeMagic)+1+len(b))
+	out = append(out, envelopeMagic...)
+	out = append(out, envelopeVersion)
+	return append(out, b...), nil
+}
+
+// IsText returns true if the body should be displayed as text
+func (e *Envelope) IsText() bool {
+	return !e.IsAttachment() && strings.HasPrefix(e.ContentType, "text/")
+}
+
+// decodeEnvelope returns the envelope encoded in plaintext. Plaintext
+// without the envelope magic is returned as a text envelope.
+func decodeEnvelope(plaintext []byte) (*Envelope, error) {
+	if !bytes.HasPrefix(plaintext, []byte(envelopeMagic)) {
+		return &Envelope{ContentType: contentTypeText, Body: plaintext}, nil
+	}
+	b := plaintext[len(envelopeMagic):]
+	if len(b) == 0 || b[0] != envelopeVersion {
+		return nil, errUnsupportedEnvelope
+	}
+	e := new(Envelope)
+	if err := cbor.Unmarshal(b[1:], e); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedEnvelope, err)
+	}
+	return e, nil
+}
+
+// messageText returns the text displayed for plaintext
+func messageText(plaintext []byte) string {
+	e, err := decodeEnvelope(plaintext)
+	if err != nil {
+		return "Unsupported message, please upgrade katzen"
+	}
+	if e.IsAttachment() {
+		return "Attachment: " + e.Metadata[metaName]
+	}
+	if e.IsDeleteRequest() {
+		return "Messages deleted"
+	}
+	if !e.IsText() {
+		return fmt.Sprintf("Unsupported %s message", e.ContentType)
+	}
+	return string(e.Body)
+}
+
+// sendEnvelope sends e to nickname, split into as many messages as needed to
+// fit the payload, and returns their message IDs
+func (a *App) sendEnvelope(nickname string, e *Envelope) ([]catshadow.MessageID, error) {
+	var nonce [8]byte
+	if _, err := rand.Reader.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]string)
+	}
+	e.Metadata[metaNonce] = hex.EncodeToString(nonce[:])
+	msg, err := e.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	parts, err := splitMessage(msg, a.payloadLen())
+	if err != nil {
+		return nil, err
+	}
+	msgIds := make([]catshadow.MessageID, 0, len(parts))
+	for _, part := range parts {
+		id := a.c.SendMessage(nickname, part)
+		a.messages.Sent(nickname, id, part)
+		msgIds = append(msgIds, id)
+	}
+	return msgIds, nil
+}
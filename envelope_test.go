@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	e := &Envelope{
+		ContentType: "image/png",
+		Body:        []byte{0x89, 'P', 'N', 'G'},
+		Metadata:    map[string]string{"name": "cat.png"},
+	}
+	b, err := e.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeEnvelope(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ContentType != e.ContentType || !bytes.Equal(got.Body, e.Body) || got.Metadata["name"] != "cat.png" {
+		t.Errorf("decoded %+v, want %+v", got, e)
+	}
+	if got.IsText() {
+		t.Error("image envelope reported as text")
+	}
+}
+
+func TestEnvelopeLegacyPlaintext(t *testing.T) {
+	e, err := decodeEnvelope([]byte("hello from an old katzen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.IsText() || string(e.Body) != "hello from an old katzen" {
+		t.Errorf("legacy plaintext decoded as %+v", e)
+	}
+}
+
+func TestEnvelopeUnsupported(t *testing.T) {
+	b, err := newTextEnvelope("from the future").Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(envelopeMagic)] = envelopeVersion + 1
+	if _, err := decodeEnvelope(b); err != errUnsupportedEnvelope {
+		t.Errorf("got %v for a newer version, want errUnsupportedEnvelope", err)
+	}
+	if _, err := decodeEnvelope([]byte(envelopeMagic + "\x01garbage")); err == nil {
+		t.Error("decoded a corrupt envelope")
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	b, err := newTextEnvelope("meow").Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageText(b); got != "meow" {
+		t.Errorf("messageText = %q, want meow", got)
+	}
+	if got := messageText([]byte("purr")); got != "purr" {
+		t.Errorf("messageText of legacy plaintext = %q, want purr", got)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/hpqc/rand"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"github.com/katzenpost/katzenpost/client/config"
+	"github.com/katzenpost/katzenpost/core/pki"
+	memspoolclient "github.com/katzenpost/katzenpost/memspool/client"
+)
+
+// fakeMessenger is an in-memory Messenger used to exercise pages without a
+// mixnet connection or a statefile.
+type fakeMessenger struct {
+	sync.Mutex
+
+	events     chan interface{}
+	haltCh     chan interface{}
+	haltOnce   sync.Once
+	online     bool
+	onlineErr  error
+	payloadLen int
+	providers  []string
+	doc        *pki.Document
+	cfg        *config.Config
+	spool      *memspoolclient.SpoolWriteDescriptor
+	contacts   map[string]*catshadow.Contact
+	expiration map[string]time.Duration
+	convos     map[string]map[catshadow.MessageID]*catshadow.Message
+	blobs      map[string][]byte
+
+	// connecting, if set, delays Online until it is closed
+	connecting chan struct{}
+}
+
+func newFakeMessenger() *fakeMessenger {
+	return &fakeMessenger{
+		events:     make(chan interface{}, catshadow.EventChannelSize),
+		haltCh:     make(chan interface{}),
+		payloadLen: 1000,
+		contacts:   make(map[string]*catshadow.Contact),
+		expiration: make(map[string]time.Duration),
+		convos:     make(map[string]map[catshadow.MessageID]*catshadow.Message),
+		blobs:      make(map[string][]byte),
+	}
+}
+
+func (f *fakeMessenger) Events() <-chan interface{} {
+	return f.events
+}
+
+func (f *fakeMessenger) Start() {
+}
+
+func (f *fakeMessenger) Shutdown() {
+	f.haltOnce.Do(func() { close(f.haltCh) })
+}
+
+func (f *fakeMessenger) Wait() {
+	<-f.haltCh
+}
+
+func (f *fakeMessenger) HaltCh() <-chan interface{} {
+	return f.haltCh
+}
+
+func (f *fakeMessenger) Online(ctx context.Context) error {
+	f.Lock()
+	connecting := f.connecting
+	f.Unlock()
+	if connecting != nil {
+		select {
+		case <-connecting:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.onlineErr != nil {
+		return f.onlineErr
+	}
+	f.online = true
+	return nil
+}
+
+func (f *fakeMessenger) Offline() error {
+	f.Lock()
+	f.online = false
+	f.Unlock()
+	return nil
+}
+
+func (f *fakeMessenger) SpoolWriteDescriptor() *memspoolclient.SpoolWriteDescriptor {
+	f.Lock()
+	defer f.Unlock()
+	return f.spool
+}
+
+func (f *fakeMessenger) GetSpoolProviders() ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if !f.online {
+		return nil, catshadow.ErrNotOnline
+	}
+	return f.providers, nil
+}
+
+func (f *fakeMessenger) GetPKIDocument() (*pki.Document, error) {
+	f.Lock()
+	defer f.Unlock()
+	if !f.online {
+		return nil, catshadow.ErrNotOnline
+	}
+	if f.doc == nil {
+		return nil, catshadow.ErrNoCurrentDocument
+	}
+	return f.doc, nil
+}
+
+func (f *fakeMessenger) ClientConfig() *config.Config {
+	return f.cfg
+}
+
+func (f *fakeMessenger) CreateRemoteSpoolOn(provider string) error {
+	f.Lock()
+	defer f.Unlock()
+	for _, p := range f.providers {
+		if p == provider {
+			f.spool = &memspoolclient.SpoolWriteDescriptor{Provider: provider}
+			return nil
+		}
+	}
+	return catshadow.ErrProviderNotFound
+}
+
+func (f *fakeMessenger) NewContact(nickname string, sharedSecret []byte) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.contacts[nickname]; ok {
+		return
+	}
+	f.contacts[nickname] = &catshadow.Contact{Nickname: nickname, IsPending: true}
+	f.convos[nickname] = make(map[catshadow.MessageID]*catshadow.Message)
+}
+
+func (f *fakeMessenger) GetContacts() map[string]*catshadow.Contact {
+	f.Lock()
+	defer f.Unlock()
+	contacts := make(map[string]*catshadow.Contact, len(f.contacts))
+	for nickname, contact := range f.contacts {
+		contacts[nickname] = contact
+	}
+	return contacts
+}
+
+func (f *fakeMessenger) RemoveContact(nickname string) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.contacts[nickname]; !ok {
+		return catshadow.ErrContactNotFound
+	}
+	delete(f.contacts, nickname)
+	delete(f.convos, nickname)
+	delete(f.expiration, nickname)
+	return nil
+}
+
+func (f *fakeMessenger) RenameContact(oldname, newname string) error {
+	f.Lock()
+	defer f.Unlock()
+	contact, ok := f.contacts[oldname]
+	if !ok {
+		return catshadow.ErrContactNotFound
+	}
+	if _, ok := f.contacts[newname]; ok {
+		return errors.New("Contact with that nickname already exists")
+	}
+	contact.Nickname = newname
+	f.contacts[newname] = contact
+	f.convos[newname] = f.convos[oldname]
+	f.expiration[newname] = f.expiration[oldname]
+	delete(f.contacts, oldname)
+	delete(f.convos, oldname)
+	delete(f.expiration, oldname)
+	return nil
+}
+
+func (f *fakeMessenger) GetExpiration(nickname string) (time.Duration, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.contacts[nickname]; !ok {
+		return 0, catshadow.ErrContactNotFound
+	}
+	return f.expiration[nickname], nil
+}
+
+func (f *fakeMessenger) ChangeExpiration(nickname string, expiration time.Duration) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.contacts[nickname]; !ok {
+		return catshadow.ErrContactNotFound
+	}
+	f.expiration[nickname] = expiration
+	return nil
+}
+
+func (f *fakeMessenger) DoubleRatchetPayloadLength() int {
+	return f.payloadLen
+}
+
+func (f *fakeMessenger) SendMessage(nickname string, message []byte) catshadow.MessageID {
+	var id catshadow.MessageID
+	rand.Reader.Read(id[:])
+	f.Lock()
+	defer f.Unlock()
+	contact, ok := f.contacts[nickname]
+	if !ok {
+		f.events <- &catshadow.MessageNotSentEvent{Nickname: nickname, MessageID: id, Err: catshadow.ErrContactNotFound}
+		return id
+	}
+	msg := &catshadow.Message{
+		Plaintext: message,
+		Timestamp: time.Now(),
+		Outbound:  true,
+		Sent:      true,
+	}
+	f.convos[nickname][id] = msg
+	contact.LastMessage = msg
+	f.events <- &catshadow.MessageSentEvent{Nickname: nickname, MessageID: id}
+	return id
+}
+
+// receive adds an inbound message from nickname and emits a MessageReceivedEvent
+func (f *fakeMessenger) receive(nickname string, message []byte, ts time.Time) catshadow.MessageID {
+	var id catshadow.MessageID
+	rand.Reader.Read(id[:])
+	f.Lock()
+	defer f.Unlock()
+	msg := &catshadow.Message{Plaintext: message, Timestamp: ts}
+	f.convos[nickname][id] = msg
+	f.contacts[nickname].LastMessage = msg
+	f.events <- &catshadow.MessageReceivedEvent{Nickname: nickname, Message: message, Timestamp: ts}
+	return id
+}
+
+func (f *fakeMessenger) GetSortedConversation(nickname string) catshadow.Messages {
+	f.Lock()
+	defer f.Unlock()
+	messages := make(catshadow.Messages, 0, len(f.convos[nickname]))
+	for _, msg := range f.convos[nickname] {
+		messages = append(messages, msg)
+	}
+	sort.Sort(messages)
+	return messages
+}
+
+func (f *fakeMessenger) WipeConversation(nickname string) error {
+	f.Lock()
+	defer f.Unlock()
+	contact, ok := f.contacts[nickname]
+	if !ok {
+		return catshadow.ErrContactNotFound
+	}
+	f.convos[nickname] = make(map[catshadow.MessageID]*catshadow.Message)
+	contact.LastMessage = nil
+	return nil
+}
+
+func (f *fakeMessenger) AddBlob(id string, blob []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	f.blobs[id] = blob
+	return nil
+}
+
+func (f *fakeMessenger) GetBlob(id string) ([]byte, error) {
+	f.Lock()
+	defer f.Unlock()
+	b, ok := f.blobs[id]
+	if !ok {
+		return nil, catshadow.ErrBlobNotFound
+	}
+	return b, nil
+}
+
+func (f *fakeMessenger) DeleteBlob(id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.blobs[id]; !ok {
+		return catshadow.ErrBlobNotFound
+	}
+	delete(f.blobs, id)
+	return nil
+}
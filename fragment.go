@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/katzenpost/hpqc/rand"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// Messages longer than the double ratchet payload are sent as a group of
+// fragments, each starting with a header:
+//
+//	magic (4) || group id (8) || index (2) || total (2) || body
+//
+// The magic begins with a NUL byte so that it cannot be confused with a
+// typed message. Messages that fit in a single payload are sent unchanged.
+const (
+	fragmentMagic     = "\x00kzf"
+	fragmentHeaderLen = len(fragmentMagic) + 8 + 2 + 2
+	maxFragments      = math.MaxUint16
+
+	// payloadOverhead is reserved from DoubleRatchetPayloadLength for the
+	// length prefix added by catshadow
+	payloadOverhead = 4
+
+	// maxMessageSize is the size of the largest message sent, an attachment
+	// in its envelope, and limits the groups of fragments reassembled
+	maxMessageSize = maxAttachmentSize + 4<<10
+)
+
+var errMessageTooLong = errors.New("message is too long to send")
+
+type fragmentHeader struct {
+	group uint64
+	index uint16
+	total uint16
+}
+
+// splitMessage returns msg split into fragments of at most payloadLen bytes.
+// A message that fits in payloadLen is returned as a single unframed part.
+func splitMessage(msg []byte, payloadLen int) ([][]byte, error) {
+	if len(msg) <= payloadLen {
+		return [][]byte{msg}, nil
+	}
+	if len(msg) > maxMessageSize {
+		return nil, errMessageTooLong
+	}
+	bodyLen := payloadLen - fragmentHeaderLen
+	if bodyLen <= 0 {
+		return nil, errMessageTooLong
+	}
+	total := (len(msg) + bodyLen - 1) / bodyLen
+	if total > maxFragments {
+		return nil, errMessageTooLong
+	}
+
+	var id [8]byte
+	if _, err := rand.Reader.Read(id[:]); err != nil {
+		return nil, err
+	}
+	group := binary.BigEndian.Uint64(id[:])
+	parts := make([][]byte, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * bodyLen
+		if end > len(msg) {
+			end = len(msg)
+		}
+		h := fragmentHeader{group: group, index: uint16(i), total: uint16(total)}
+		parts = append(parts, append(h.marshal(), msg[i*bodyLen:end]...))
+	}
+	return parts, nil
+}
+
+// payloadLen returns the length of the messages sent by splitMessage
+func (a *App) payloadLen() int {
+	return a.c.DoubleRatchetPayloadLength() - payloadOverhead
+}
+
+func (h fragmentHeader) marshal() []byte {
+	b := make([]byte, fragmentHeaderLen)
+	copy(b, fragmentMagic)
+	binary.BigEndian.PutUint64(b[4:], h.group)
+	binary.BigEndian.PutUint16(b[12:], h.index)
+	binary.BigEndian.PutUint16(b[14:], h.total)
+	return b
+}
+
+// parseFragment returns the header and body of a fragment, or false if b is
+// not a well formed fragment
+func parseFragment(b []byte) (fragmentHeader, []byte, bool) {
+	if len(b) < fragmentHeaderLen || !bytes.HasPrefix(b, []byte(fragmentMagic)) {
+		return fragmentHeader{}, nil, false
+	}
+	h := fragmentHeader{
+		group: binary.BigEndian.Uint64(b[4:]),
+		index: binary.BigEndian.Uint16(b[12:]),
+		total: binary.BigEndian.Uint16(b[14:]),
+	}
+	if h.total == 0 || h.index >= h.total {
+		return fragmentHeader{}, nil, false
+	}
+	return h, b[fragmentHeaderLen:], true
+}
+
+// messagePreview returns the text shown for msg in the contact list
+func messagePreview(msg *catshadow.Message) string {
+	if h, _, ok := parseFragment(msg.Plaintext); ok {
+		return fmt.Sprintf("Message in %d parts", h.total)
+	}
+	return messageText(msg.Plaintext)
+}
+
+type groupKey struct {
+	outbound bool
+	group    uint64
+}
+
+// fragmentGroup is a logical message being reassembled from fragments
+type fragmentGroup struct {
+	msg      *catshadow.Message
+	msgs     []*catshadow.Message
+	parts    [][]byte
+	received int
+	sent     int
+}
+
+// reassembler joins the fragments in a conversation into logical messages.
+// It keeps the reassembled messages between frames so that pages can use
+// them as stable keys for click handlers and selection.
+type reassembler struct {
+	groups map[groupKey]*fragmentGroup
+	byMsg  map[*catshadow.Message]*fragmentGroup
+	// maxTotal is the number of fragments of payloadLen that hold a
+	// message of maxMessageSize
+	maxTotal int
+}
+
+// newReassembler returns a reassembler for fragments of at most payloadLen
+// bytes
+func newReassembler(payloadLen int) *reassembler {
+	maxTotal := 0
+	if bodyLen := payloadLen - fragmentHeaderLen; bodyLen > 0 {
+		maxTotal = (maxMessageSize + bodyLen - 1) / bodyLen
+	}
+	return &reassembler{
+		groups:   make(map[groupKey]*fragmentGroup),
+		byMsg:    make(map[*catshadow.Message]*fragmentGroup),
+		maxTotal: maxTotal,
+	}
+}
+
+// Messages returns messages with each group of fragments replaced by a
+// single message, placed where the first fragment of the group appears.
+// The plaintext of a group is empty until all of its fragments arrive.
+func (r *reassembler) Messages(messages catshadow.Messages) catshadow.Messages {
+	out := make(catshadow.Messages, 0, len(messages))
+	seen := make(map[groupKey]bool)
+	for _, m := range messages {
+		h, body, ok := parseFragment(m.Plaintext)
+		if !ok {
+			out = append(out, m)
+			continue
+		}
+		// the total is chosen by the peer, and a group is never larger
+		// than the largest message sent
+		if int(h.total) > r.maxTotal {
+			continue
+		}
+		k := groupKey{outbound: m.Outbound, group: h.group}
+		g, ok := r.groups[k]
+		if !ok {
+			g = &fragmentGroup{
+				msg:   &catshadow.Message{Timestamp: m.Timestamp, Outbound: m.Outbound},
+				msgs:  make([]*catshadow.Message, h.total),
+				parts: make([][]byte, h.total),
+			}
+			r.groups[k] = g
+			r.byMsg[g.msg] = g
+		}
+		if len(g.parts) != int(h.total) {
+			// inconsistent with the fragments already received
+			continue
+		}
+		if !seen[k] {
+			seen[k] = true
+			g.msg.Sent, g.msg.Delivered = true, true
+			g.sent = 0
+			out = append(out, g.msg)
+		}
+		if m.Sent {
+			g.sent++
+		}
+		g.msg.Sent = g.msg.Sent && m.Sent
+		g.msg.Delivered = g.msg.Delivered && m.Delivered
+		if g.parts[h.index] == nil {
+			g.msgs[h.index] = m
+			g.parts[h.index] = body
+			g.received++
+			if g.received == len(g.parts) {
+				g.msg.Plaintext = bytes.Join(g.parts, nil)
+			}
+		}
+	}
+	return out
+}
+
+// Progress returns the number of fragments received and expected for msg,
+// and false if msg is not a reassembled message
+func (r *reassembler) Progress(msg *catshadow.Message) (int, int, bool) {
+	g, ok := r.byMsg[msg]
+	if !ok {
+		return 0, 0, false
+	}
+	return g.received, len(g.parts), true
+}
+
+// Sent returns the number of fragments of msg sent so far, and false if msg
+// is not a reassembled message
+func (r *reassembler) Sent(msg *catshadow.Message) (int, int, bool) {
+	g, ok := r.byMsg[msg]
+	if !ok {
+		return 0, 0, false
+	}
+	return g.sent, len(g.parts), true
+}
+
+// Parts returns the messages that make up msg, in fragment order, with nil
+// for fragments not yet received. A message that was not fragmented is its
+// only part.
+func (r *reassembler) Parts(msg *catshadow.Message) catshadow.Messages {
+	g, ok := r.byMsg[msg]
+	if !ok {
+		return catshadow.Messages{msg}
+	}
+	return append(catshadow.Messages(nil), g.msgs...)
+}
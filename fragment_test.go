@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gioui.org/io/key"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+func TestSplitMessageShort(t *testing.T) {
+	msg := []byte("meow")
+	parts, err := splitMessage(msg, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || !bytes.Equal(parts[0], msg) {
+		t.Errorf("short message was framed: %q", parts)
+	}
+	if _, _, ok := parseFragment(parts[0]); ok {
+		t.Error("plain text parsed as a fragment")
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	msg := []byte(strings.Repeat("stack trace line\n", 20))
+	parts, err := splitMessage(msg, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyLen := 64 - fragmentHeaderLen
+	if want := (len(msg) + bodyLen - 1) / bodyLen; len(parts) != want {
+		t.Fatalf("got %d parts, want %d", len(parts), want)
+	}
+	var joined []byte
+	var group uint64
+	for i, part := range parts {
+		if len(part) > 64 {
+			t.Errorf("part %d is %d bytes, larger than the payload", i, len(part))
+		}
+		h, body, ok := parseFragment(part)
+		if !ok {
+			t.Fatalf("part %d is not a fragment", i)
+		}
+		if i == 0 {
+			group = h.group
+		}
+		if h.group != group || int(h.index) != i || int(h.total) != len(parts) {
+			t.Errorf("part %d has header %+v", i, h)
+		}
+		joined = append(joined, body...)
+	}
+	if !bytes.Equal(joined, msg) {
+		t.Error("fragments do not join to the original message")
+	}
+
+	if _, err := splitMessage(msg, fragmentHeaderLen); err != errMessageTooLong {
+		t.Errorf("payload without room for a body: got %v, want errMessageTooLong", err)
+	}
+}
+
+func TestReassembler(t *testing.T) {
+	msg := []byte(strings.Repeat("0123456789", 10))
+	parts, err := splitMessage(msg, fragmentHeaderLen+30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 {
+		t.Fatalf("got %d parts, want 4", len(parts))
+	}
+	now := time.Now()
+	before := &catshadow.Message{Plaintext: []byte("before"), Timestamp: now}
+	after := &catshadow.Message{Plaintext: []byte("after"), Timestamp: now.Add(time.Minute)}
+	fragment := func(i int) *catshadow.Message {
+		return &catshadow.Message{Plaintext: parts[i], Timestamp: now.Add(time.Duration(i) * time.Second)}
+	}
+
+	r := newReassembler(fragmentHeaderLen + 30)
+	// fragments may arrive out of order
+	messages := r.Messages(catshadow.Messages{before, fragment(2), fragment(0), after})
+	if len(messages) != 3 || messages[0] != before || messages[2] != after {
+		t.Fatalf("got %d messages, want the group between before and after", len(messages))
+	}
+	group := messages[1]
+	if received, total, ok := r.Progress(group); !ok || received != 2 || total != 4 {
+		t.Errorf("progress is %d/%d, want 2/4", received, total)
+	}
+	if len(group.Plaintext) != 0 {
+		t.Error("incomplete group has plaintext")
+	}
+	if _, _, ok := r.Progress(before); ok {
+		t.Error("plain message reported as a group")
+	}
+
+	messages = r.Messages(catshadow.Messages{before, fragment(2), fragment(0), fragment(3), fragment(1), after})
+	if len(messages) != 3 || messages[1] != group {
+		t.Fatal("reassembled message is not stable between calls")
+	}
+	if received, total, _ := r.Progress(group); received != total {
+		t.Errorf("progress is %d/%d after all fragments arrived", received, total)
+	}
+	if !bytes.Equal(group.Plaintext, msg) {
+		t.Errorf("reassembled %q, want %q", group.Plaintext, msg)
+	}
+}
+
+func TestReassemblerLimit(t *testing.T) {
+	payloadLen := 1000
+	if _, err := splitMessage(make([]byte, maxMessageSize+1), payloadLen); err != errMessageTooLong {
+		t.Errorf("got %v splitting a message over the limit, want errMessageTooLong", err)
+	}
+	parts, err := splitMessage(make([]byte, maxMessageSize), payloadLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReassembler(payloadLen)
+	first := &catshadow.Message{Plaintext: parts[0], Timestamp: time.Now()}
+	if messages := r.Messages(catshadow.Messages{first}); len(messages) != 1 {
+		t.Fatal("the largest message sent is not reassembled")
+	}
+
+	// a peer claiming more fragments than the largest message needs
+	h, body, _ := parseFragment(parts[0])
+	h.total = maxFragments
+	forged := &catshadow.Message{Plaintext: append(h.marshal(), body...), Timestamp: time.Now()}
+	if messages := r.Messages(catshadow.Messages{forged}); len(messages) != 0 {
+		t.Error("group over the limit was reassembled")
+	}
+	if len(r.groups) != 1 {
+		t.Errorf("reassembler holds %d groups, want 1", len(r.groups))
+	}
+}
+
+func TestConversationPageSendLong(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 64
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "bob")
+	})
+	h.frames(2)
+	msg := strings.Repeat("purr", 40)
+	h.typeText(msg)
+	h.press(key.NameReturn)
+
+	sent := f.GetSortedConversation("bob")
+	if len(sent) < 3 {
+		t.Fatalf("conversation has %d messages, want the message split into parts", len(sent))
+	}
+	p := h.current().(*conversationPage)
+	messages := p.fragments.Messages(sent)
+	if len(messages) != 2 {
+		t.Fatalf("conversation shows %d messages, want 2", len(messages))
+	}
+	if got := messageText(messages[1].Plaintext); got != msg {
+		t.Errorf("reassembled %q, want %q", got, msg)
+	}
+}
+
+func TestConversationPageSendError(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "bob")
+	})
+	h.frames(2)
+	// no fragment fits in a payload this short
+	f.payloadLen = payloadOverhead + fragmentHeaderLen
+	msg := strings.Repeat("purr", 40)
+	h.typeText(msg)
+	h.press(key.NameReturn)
+
+	p := h.current().(*conversationPage)
+	if !strings.Contains(p.errMsg, errMessageTooLong.Error()) {
+		t.Errorf("error shown is %q, want %q", p.errMsg, errMessageTooLong)
+	}
+	if p.compose.Text() != msg {
+		t.Errorf("editor holds %q after failing to send, want the message", p.compose.Text())
+	}
+	if len(f.GetSortedConversation("bob")) != 1 {
+		t.Error("a message was sent")
+	}
+
+	f.payloadLen = 1000
+	h.press(key.NameReturn)
+	if p.errMsg != "" || p.compose.Text() != "" {
+		t.Errorf("error %q and text %q are kept after sending", p.errMsg, p.compose.Text())
+	}
+}
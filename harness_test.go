@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gioui.org/f32"
+	"gioui.org/gpu/headless"
+	"gioui.org/io/event"
+	"gioui.org/io/input"
+	"gioui.org/io/key"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden screenshots in testdata/")
+
+const (
+	// goldenChannelTolerance is the per channel difference below which two
+	// pixels are considered equal, to absorb rasterizer differences.
+	goldenChannelTolerance = 16
+	// goldenPixelTolerance is the fraction of pixels allowed to differ.
+	goldenPixelTolerance = 0.005
+)
+
+// pageHarness drives an App headlessly: it lays out the current page,
+// routes synthetic input through an input.Router, and renders frames for
+// comparison against golden images.
+type pageHarness struct {
+	t      *testing.T
+	a      *App
+	router *input.Router
+	ops    *op.Ops
+	size   image.Point
+	now    time.Time
+}
+
+// newPageHarness returns a harness with a 400x400 window, matching the
+// default window size set in uiMain, with page on top of the stack.
+func newPageHarness(t *testing.T, m Messenger, page func(a *App) Page) *pageHarness {
+	t.Helper()
+	a := newTestApp(m)
+	a.stack.Push(page(a))
+	t.Cleanup(func() {
+		for a.stack.Len() > 0 {
+			a.stack.Pop()
+		}
+	})
+	h := &pageHarness{
+		t:      t,
+		a:      a,
+		router: new(input.Router),
+		ops:    new(op.Ops),
+		size:   image.Pt(400, 400),
+		now:    time.Now(),
+	}
+	h.frame()
+	return h
+}
+
+// frame processes pending events and lays out the current page
+func (h *pageHarness) frame() {
+	h.t.Helper()
+	h.ops.Reset()
+	h.now = h.now.Add(time.Second)
+	gtx := layout.Context{
+		Ops:         h.ops,
+		Now:         h.now,
+		Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+		Constraints: layout.Exact(h.size),
+		Source:      h.router.Source(),
+	}
+	h.a.Layout(gtx)
+	h.router.Frame(h.ops)
+}
+
+// frames lays out n consecutive frames, giving multi-step widget
+// interactions (focus, submit, click) a chance to propagate.
+func (h *pageHarness) frames(n int) {
+	h.t.Helper()
+	for i := 0; i < n; i++ {
+		h.frame()
+	}
+}
+
+// queue delivers each event followed by a frame, the way app.Window does
+func (h *pageHarness) queue(events ...event.Event) {
+	h.t.Helper()
+	for _, e := range events {
+		h.router.Queue(e)
+		h.frame()
+	}
+	h.frame()
+}
+
+// click presses and releases the primary pointer button at pt
+func (h *pageHarness) click(pt image.Point) {
+	h.t.Helper()
+	pos := f32.Pt(float32(pt.X), float32(pt.Y))
+	h.queue(
+		pointer.Event{Kind: pointer.Move, Source: pointer.Mouse, Position: pos, Time: h.elapsed()},
+		pointer.Event{Kind: pointer.Press, Source: pointer.Mouse, Buttons: pointer.ButtonPrimary, Position: pos, Time: h.elapsed()},
+		pointer.Event{Kind: pointer.Release, Source: pointer.Mouse, Buttons: pointer.ButtonPrimary, Position: pos, Time: h.elapsed()},
+	)
+}
+
+// press sends a key press followed by a release
+func (h *pageHarness) press(name key.Name) {
+	h.t.Helper()
+	h.queue(
+		key.Event{Name: name, State: key.Press},
+		key.Event{Name: name, State: key.Release},
+	)
+}
+
+// typeText inserts s into the focused editor
+func (h *pageHarness) typeText(s string) {
+	h.t.Helper()
+	h.queue(key.EditEvent{Text: s})
+}
+
+func (h *pageHarness) elapsed() time.Duration {
+	return time.Duration(h.now.UnixNano())
+}
+
+// current returns the page on top of the stack
+func (h *pageHarness) current() Page {
+	return h.a.stack.Current()
+}
+
+// screenshot renders the current frame and compares it against
+// testdata/<name>.png. Run `go test -update` to regenerate golden images.
+// The comparison runs as a subtest, so that it is skipped without ending
+// the test when headless rendering is unavailable.
+func (h *pageHarness) screenshot(name string) {
+	h.t.Helper()
+	h.frame()
+	h.t.Run(name, func(t *testing.T) {
+		w, err := headless.NewWindow(h.size.X, h.size.Y)
+		if err != nil {
+			// mesa renders in software with EGL_PLATFORM=surfaceless
+			t.Skipf("headless rendering unavailable: %v", err)
+		}
+		defer w.Release()
+
+		if err := w.Frame(h.ops); err != nil {
+			t.Fatalf("frame %s: %v", name, err)
+		}
+		img := image.NewRGBA(image.Rectangle{Max: h.size})
+		if err := w.Screenshot(img); err != nil {
+			t.Fatalf("screenshot %s: %v", name, err)
+		}
+
+		golden := filepath.Join("testdata", name+".png")
+		if *updateGolden {
+			if err := writePNG(golden, img); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		want, err := readPNG(golden)
+		if err != nil {
+			t.Fatalf("%v (run go test -update to create it)", err)
+		}
+		if err := compareImages(want, img); err != nil {
+			actual := filepath.Join(t.TempDir(), name+".png")
+			writePNG(actual, img)
+			t.Errorf("%s: %v, actual frame written to %s", golden, err, actual)
+		}
+	})
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compareImages returns an error if more than goldenPixelTolerance of the
+// pixels in got differ from want by more than goldenChannelTolerance
+func compareImages(want, got image.Image) error {
+	if want.Bounds() != got.Bounds() {
+		return fmt.Errorf("size %v does not match golden %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	diff := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			if channelDiff(w.R, g.R) > goldenChannelTolerance ||
+				channelDiff(w.G, g.G) > goldenChannelTolerance ||
+				channelDiff(w.B, g.B) > goldenChannelTolerance ||
+				channelDiff(w.A, g.A) > goldenChannelTolerance {
+				diff++
+			}
+		}
+	}
+	if frac := float64(diff) / float64(b.Dx()*b.Dy()); frac > goldenPixelTolerance {
+		return fmt.Errorf("%d pixels (%.2f%%) differ", diff, frac*100)
+	}
+	return nil
+}
+
+func channelDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"fmt"
 	"gioui.org/font"
 	"gioui.org/gesture"
 	"gioui.org/io/key"
@@ -25,15 +26,13 @@ import (
 )
 
 var (
-	contactList       = &layout.List{Axis: layout.Vertical, ScrollToEnd: false}
-	selectedIdx       = 0
 	connectIcon, _    = widget.NewIcon(icons.DeviceSignalWiFi4Bar)
 	disconnectIcon, _ = widget.NewIcon(icons.DeviceSignalWiFiOff)
 	settingsIcon, _   = widget.NewIcon(icons.ActionSettings)
 	addContactIcon, _ = widget.NewIcon(icons.SocialPersonAdd)
+	lockIcon, _       = widget.NewIcon(icons.ActionLock)
 	logo              = getLogo()
 	units, _          = durafmt.UnitsCoder{PluralSep: ":", UnitsSep: ","}.Decode("y:y,w:w,d:d,h:h,m:m,s:s,ms:ms,us:us")
-	avatars           = make(map[string]layout.Widget)
 )
 
 type HomePage struct {
@@ -41,18 +40,29 @@ type HomePage struct {
 	a             *App
 	updateCh      chan interface{}
 	contacts      []*catshadow.Contact
+	contactList   *layout.List
+	selectedIdx   int
 	addContact    *widget.Clickable
 	connect       *widget.Clickable
 	showSettings  *widget.Clickable
+	search        *widget.Clickable
+	lock          *widget.Clickable
 	av            map[string]*widget.Image
 	contactClicks map[string]*gesture.Click
+
+	// c is the client the page was opened for, which the contact list
+	// worker uses instead of App.c as that is replaced when locking
+	c Messenger
 }
 
 type AddContactClick struct{}
 type ShowSettingsClick struct{}
 
 func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
+	// contacts is replaced by UpdateContacts from the page worker
+	p.l.Lock()
 	contacts := p.contacts
+	p.l.Unlock()
 	// xxx do not request this every frame...
 	bg := Background{
 		Color: th.Bg,
@@ -60,20 +70,20 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 	}
 
 	if len(contacts) == 0 {
-		selectedIdx = 0
-	} else if selectedIdx < 0 {
-		selectedIdx = len(contacts) - 1
+		p.selectedIdx = 0
+	} else if p.selectedIdx < 0 {
+		p.selectedIdx = len(contacts) - 1
 	} else {
-		selectedIdx = selectedIdx % len(contacts)
+		p.selectedIdx = p.selectedIdx % len(contacts)
 	}
 
 	// re-center list view for keyboard contact selection
-	if selectedIdx < contactList.Position.First || selectedIdx >= contactList.Position.First+contactList.Position.Count {
+	if p.selectedIdx < p.contactList.Position.First || p.selectedIdx >= p.contactList.Position.First+p.contactList.Position.Count {
 		// list doesn't wrap around view to end, so do not give negative value for First
-		if selectedIdx < contactList.Position.Count-1 {
-			contactList.Position.First = 0
+		if p.selectedIdx < p.contactList.Position.Count-1 {
+			p.contactList.Position.First = 0
 		} else {
-			contactList.Position.First = (selectedIdx - contactList.Position.Count + 1)
+			p.contactList.Position.First = (p.selectedIdx - p.contactList.Position.Count + 1)
 		}
 	}
 
@@ -86,12 +96,23 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 					gtx,
 					layout.Rigid(layoutLogo),
 					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(func(gtx C) D {
+						if t, ok := p.a.state.TorStatus(); ok {
+							return material.Caption(th, fmt.Sprintf("Tor %d%%", t.progress)).Layout(gtx)
+						}
+						if at, ok := p.a.state.RetryAt(); ok {
+							return material.Caption(th, retryText(gtx, at)).Layout(gtx)
+						}
+						return D{}
+					}),
 					func() layout.FlexChild {
-						if isConnected {
+						if p.a.state.Connected() {
 							return layout.Rigid(button(th, p.connect, connectIcon).Layout)
 						}
 						return layout.Rigid(button(th, p.connect, disconnectIcon).Layout)
 					}(),
+					layout.Rigid(button(th, p.lock, lockIcon).Layout),
+					layout.Rigid(button(th, p.search, searchIcon).Layout),
 					layout.Rigid(button(th, p.showSettings, settingsIcon).Layout),
 					layout.Rigid(button(th, p.addContact, addContactIcon).Layout),
 				)
@@ -101,7 +122,7 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 			layout.Flexed(1, func(gtx C) D {
 				gtx.Constraints.Min.X = gtx.Dp(unit.Dp(300))
 				// the contactList
-				return contactList.Layout(gtx, len(contacts), func(gtx C, i int) layout.Dimensions {
+				return p.contactList.Layout(gtx, len(contacts), func(gtx C, i int) layout.Dimensions {
 					lastMsg := contacts[i].LastMessage
 
 					// inset each contact Flex
@@ -109,7 +130,7 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 
 					// if the layout is selected, change background color
 					bg := Background{Inset: in}
-					if i == selectedIdx {
+					if i == p.selectedIdx {
 						bg.Color = th.ContrastBg
 					} else {
 						bg.Color = th.Bg
@@ -125,7 +146,7 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 						dims := layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceEvenly}.Layout(gtx,
 							// contact avatar
 							layout.Rigid(func(gtx C) D {
-								return layoutAvatar(gtx, p.a.c, contacts[i].Nickname)
+								return p.a.layoutAvatar(gtx, contacts[i].Nickname)
 							}),
 							// contact name and last message
 							layout.Flexed(1, func(gtx C) D {
@@ -164,7 +185,10 @@ func (p *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 										if lastMsg != nil {
 											return in.Layout(gtx, func(gtx C) D {
 												// TODO: set the color based on sent or received
-												return material.Body2(th, string(lastMsg.Plaintext)).Layout(gtx)
+												if p.a.isDeleted(contacts[i].Nickname, lastMsg) {
+													return material.Body2(th, "Message deleted").Layout(gtx)
+												}
+												return material.Body2(th, messagePreview(lastMsg)).Layout(gtx)
 											})
 										} else {
 											return fill{th.Bg}.Layout(gtx)
@@ -205,16 +229,16 @@ func layoutLogo(gtx C) D {
 	})
 }
 
-func layoutAvatar(gtx C, c *catshadow.Client, nickname string) D {
+func (a *App) layoutAvatar(gtx C, nickname string) D {
 	return layout.Center.Layout(gtx, func(gtx C) D {
 		cc := clipCircle{}
 		return cc.Layout(gtx, func(gtx C) D {
 			sz := image.Point{X: gtx.Dp(42), Y: gtx.Dp(42)}
 			gtx.Constraints = layout.Exact(gtx.Constraints.Constrain(sz))
-			if w, ok := avatars[nickname]; ok {
+			if w, ok := a.state.Avatar(nickname); ok {
 				return w(gtx)
 			} else {
-				if b, err := c.GetBlob("avatar://" + nickname); err == nil {
+				if b, err := a.c.GetBlob("avatar://" + nickname); err == nil {
 					if m, _, err := image.Decode(bytes.NewReader(b)); err == nil {
 						w = func(gtx C) D {
 							return widget.Image{Fit: widget.Contain, Src: paint.NewImageOp(m)}.Layout(gtx)
@@ -225,13 +249,13 @@ func layoutAvatar(gtx C, c *catshadow.Client, nickname string) D {
 					i := co.Render(sz)
 					b := &bytes.Buffer{}
 					if err := png.Encode(b, i); err == nil {
-						c.AddBlob("avatar://"+nickname, b.Bytes())
+						a.c.AddBlob("avatar://"+nickname, b.Bytes())
 					}
 					w = func(gtx C) D {
 						return widget.Image{Fit: widget.Contain, Src: paint.NewImageOp(i)}.Layout(gtx)
 					}
 				}
-				avatars[nickname] = w
+				a.state.SetAvatar(nickname, w)
 				return w(gtx)
 			}
 		})
@@ -255,10 +279,7 @@ type OfflineClick struct {
 // Event returns a ChooseContactClick event when a contact is chosen
 func (p *HomePage) Event(gtx layout.Context) interface{} {
 	if p.connect.Clicked(gtx) {
-		if !isConnected && !isConnecting {
-			return OnlineClick{}
-		}
-		return OfflineClick{}
+		return ShowStatusClick{}
 	}
 	// listen for pointer right click events on the addContact widget
 	if p.addContact.Clicked(gtx) {
@@ -267,6 +288,12 @@ func (p *HomePage) Event(gtx layout.Context) interface{} {
 	if p.showSettings.Clicked(gtx) {
 		return ShowSettingsClick{}
 	}
+	if p.search.Clicked(gtx) {
+		return ShowSearchClick{}
+	}
+	if p.lock.Clicked(gtx) {
+		return LockClick{}
+	}
 	for nickname, click := range p.contactClicks {
 		if e, ok := click.Update(gtx.Source); ok {
 			if e.Kind == gesture.KindClick {
@@ -282,25 +309,31 @@ func (p *HomePage) Event(gtx layout.Context) interface{} {
 		if e.Name == key.NameF3 {
 			return ShowSettingsClick{}
 		}
+		if e.Name == "F" && e.Modifiers.Contain(key.ModShortcut) {
+			return ShowSearchClick{}
+		}
+		if e.Name == "L" && e.Modifiers.Contain(key.ModShortcut) {
+			return LockClick{}
+		}
 		if e.Name == key.NameF4 {
-			if !isConnected {
+			if !p.a.state.Connected() {
 				return OnlineClick{}
 			}
 			return OfflineClick{}
 		}
 		if e.Name == key.NameUpArrow {
-			selectedIdx = selectedIdx - 1
+			p.selectedIdx = p.selectedIdx - 1
 		}
 		if e.Name == key.NameDownArrow {
-			selectedIdx = selectedIdx + 1
+			p.selectedIdx = p.selectedIdx + 1
 		}
 		if e.Name == key.NameReturn {
 			p.l.Lock()
 			defer p.l.Unlock()
-			if len(p.contacts) < selectedIdx+1 {
+			if len(p.contacts) < p.selectedIdx+1 {
 				return nil
 			}
-			return ChooseContactClick{nickname: p.contacts[selectedIdx].Nickname}
+			return ChooseContactClick{nickname: p.contacts[p.selectedIdx].Nickname}
 		}
 	}
 
@@ -308,6 +341,12 @@ func (p *HomePage) Event(gtx layout.Context) interface{} {
 }
 
 func (p *HomePage) Start(stop <-chan struct{}) {
+	// refresh the contacts when returning to the page, as they may have
+	// been added, renamed or removed
+	select {
+	case p.updateCh <- struct{}{}:
+	default:
+	}
 	// receive commands to update the contact list, e.g. from KeyExchangeCompleted events
 	go func() {
 		for {
@@ -327,13 +366,13 @@ func (h *HomePage) UpdateContacts() {
 	if h.a == nil {
 		return
 	}
-	if h.a.c == nil {
+	if h.c == nil {
 		return
 	}
 	contacts := make(sortedContacts, 0)
 
 	// GetContacts() returns map[string]*Contact
-	for _, contact := range h.a.c.GetContacts() {
+	for _, contact := range h.c.GetContacts() {
 		contacts = append(contacts, contact)
 	}
 	sort.Sort(contacts)
@@ -342,16 +381,18 @@ func (h *HomePage) UpdateContacts() {
 }
 
 func newHomePage(a *App) *HomePage {
-	updateCh := make(chan interface{}, 1)
-	updateCh <- struct{}{} // prod page worker to fetch contacts from catshadow
 	return &HomePage{
 		a:             a,
+		c:             a.c,
 		l:             new(sync.Mutex),
-		updateCh:      updateCh,
+		updateCh:      make(chan interface{}, 1),
 		contacts:      []*catshadow.Contact{},
+		contactList:   &layout.List{Axis: layout.Vertical, ScrollToEnd: false},
 		addContact:    &widget.Clickable{},
 		connect:       &widget.Clickable{},
 		showSettings:  &widget.Clickable{},
+		search:        &widget.Clickable{},
+		lock:          &widget.Clickable{},
 		contactClicks: make(map[string]*gesture.Click),
 		av:            make(map[string]*widget.Image),
 	}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHomePageUpdateContacts(t *testing.T) {
+	f := newFakeMessenger()
+	f.NewContact("carol", []byte("secret"))
+	f.NewContact("alice", []byte("secret"))
+	f.NewContact("bob", []byte("secret"))
+	now := time.Now()
+	f.receive("bob", []byte("older"), now.Add(-time.Hour))
+	f.receive("carol", []byte("newer"), now)
+
+	p := newHomePage(newTestApp(f))
+	p.UpdateContacts()
+
+	// contacts with messages come first, most recent first, then alphabetically
+	want := []string{"carol", "bob", "alice"}
+	if len(p.contacts) != len(want) {
+		t.Fatalf("got %d contacts, want %d", len(p.contacts), len(want))
+	}
+	for i, nickname := range want {
+		if p.contacts[i].Nickname != nickname {
+			t.Errorf("contacts[%d] = %s, want %s", i, p.contacts[i].Nickname, nickname)
+		}
+	}
+}
+
+func TestSettingsPageReadsBlobs(t *testing.T) {
+	f := newFakeMessenger()
+	f.AddBlob("AutoConnect", []byte{1})
+
+	p := newSettingsPage(newTestApp(f))
+	if !p.switchAutoConnect.Value {
+		t.Error("AutoConnect switch should be enabled")
+	}
+	if p.switchUseTor.Value {
+		t.Error("UseTor switch should be disabled")
+	}
+}
+
+func TestEditContactPageExpiration(t *testing.T) {
+	f := newFakeMessenger()
+	f.NewContact("alice", []byte("secret"))
+	f.ChangeExpiration("alice", 7*24*time.Hour)
+
+	p := newEditContactPage(newTestApp(f), "alice")
+	if p.duration != 7*24*time.Hour {
+		t.Errorf("duration = %v, want 168h", p.duration)
+	}
+	if valueToDuration(p.expiry.Value) != p.duration {
+		t.Errorf("slider value %v does not round trip to %v", p.expiry.Value, p.duration)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"flag"
@@ -37,48 +38,90 @@ var (
 	clientConfigFile = flag.String("f", "", "Path to the client config file.")
 	stateFile        = flag.String("s", "catshadow_statefile", "Path to the client state file.")
 	debug            = flag.Int("d", 0, "Enable golang debug service.")
+	minScore         = flag.Int("min-score", 2, "Minimum passphrase strength score (0-4) for a new statefile.")
+	checkStateFlag   = flag.Bool("check-state", false, "Check the statefile for issues, reading the passphrase from standard input, and exit.")
+	repairState      = flag.Bool("repair", false, "With -check-state, repair the issues found.")
 
 	th *material.Theme
 
 	minPasswordLen = 5 // XXX pick something reasonable
 
-	notifications = make(map[string]notify.Notification)
-
 	//go:embed default_config_without_tor.toml
 	cfgWithoutTor []byte
 	//go:embed default_config_with_tor.toml
 	cfgWithTor []byte
-
-	isConnected  bool
-	isConnecting bool
 )
 
 type App struct {
-	endBg func()
-	w     *app.Window
-	ops   *op.Ops
-	c     *catshadow.Client
-	stack pageStack
+	endBg    func()
+	w        *app.Window
+	ops      *op.Ops
+	c        Messenger
+	state    *appState
+	messages *messageLog
+	index    *searchIndex
+	// deletions caches the deleted messages of each conversation
+	deletions *deletionCache
+	stack     pageStack
+
+	// lastActivity is the time of the last input or page event, and the tag
+	// of the pointer input recorded as activity
+	lastActivity time.Time
+
+	// profile is the profile signed in to, or nil if a statefile was given
+	// with -s
+	profile  *profile
+	profiles *profileStore
+
+	// failedUnlocks counts the wrong passphrases entered in a row, and
+	// signing in is not allowed again before retryUnlockAt
+	failedUnlocks int
+	retryUnlockAt time.Time
+	// unlockDelayOf is the statefile they were loaded for
+	unlockDelayOf string
+
+	// cancelConnect cancels the connection attempt in progress, and
+	// connectDone is closed once the last attempt returned
+	cancelConnect context.CancelFunc
+	connectDone   chan struct{}
+	// connectFailures receives the failures of connection attempts
+	connectFailures chan interface{}
+
+	// wantOnline is set while the user wants the client connected, and
+	// reconnect is the reconnection scheduled after the client lost its
+	// connection for the reconnects-th time in a row
+	wantOnline bool
+	reconnect  *time.Timer
+	reconnects int
 }
 
 func newApp(w *app.Window) *App {
 	a := &App{
-		w:   w,
-		ops: &op.Ops{},
+		w:         w,
+		ops:       &op.Ops{},
+		state:     newAppState(),
+		messages:  newMessageLog(),
+		index:     newSearchIndex(),
+		deletions: newDeletionCache(),
+
+		connectFailures: make(chan interface{}),
 	}
+	// redraw when the connection state changes
+	a.state.OnChange(w.Invalidate)
 	return a
 }
 
 func (a *App) Layout(gtx layout.Context) {
 	a.update(gtx)
 	a.stack.Current().Layout(gtx)
+	a.layoutActivity(gtx)
 }
 
 func (a *App) update(gtx layout.Context) {
 	// handle global shortcuts
 	if backEvent(gtx) {
-		// XXX: this means that after signin, the top level page is homescreen
-		// and therefore pressing back won't logout
+		// after signin, the top level page is homescreen and pressing back
+		// does not logout, which is done with LockClick instead
 		if a.stack.Len() > 1 {
 			a.stack.Pop()
 			return
@@ -90,81 +133,145 @@ func (a *App) update(gtx layout.Context) {
 		return
 	}
 
-	page := a.stack.Current()
-	if e := page.Event(gtx); e != nil {
-		switch e := e.(type) {
-		case RedrawEvent:
-			a.w.Invalidate()
-		case BackEvent:
-			a.stack.Pop()
-		case signInStarted:
-			p := newUnlockPage(e.result)
-			a.stack.Clear(p)
-		case unlockError:
-			isConnected = false
-			isConnecting = false
-			fmt.Printf("unlockError: %s\n", e.err)
-			a.stack.Clear(newSignInPage(a))
-		case restartClient:
-			isConnected = false
-			isConnecting = false
-			fmt.Printf("restartClient\n")
-			a.stack.Clear(newSignInPage(a))
-		case unlockSuccess:
-			// validate the statefile somehow
-			a.c = e.client
-			a.c.Start()
-			a.stack.Clear(newHomePage(a))
-			if _, err := a.c.GetBlob("AutoConnect"); err == nil {
-				go a.c.Online(context.TODO())
-				isConnecting = true
-				// if the client does not already have a spool
-				// descriptor, prompt to create one
-				spool := a.c.SpoolWriteDescriptor()
-				if spool == nil {
-					a.stack.Push(newSpoolPage(a))
-				}
+	if e := a.stack.Current().Event(gtx); e != nil {
+		a.lastActivity = time.Now()
+		a.navigate(e)
+	}
+}
+
+func init() {
+	handle(func(a *App, _ RedrawEvent) interface{} {
+		a.w.Invalidate()
+		return nil
+	})
+	handle(func(a *App, _ BackEvent) interface{} {
+		return Return{}
+	})
+	handle(func(a *App, e unlockError) interface{} {
+		a.state.SetConnected(false)
+		fmt.Fprintf(os.Stderr, "failed to unlock: %v\n", e.err)
+		p := newSignInPage(a)
+		problem := a.unlockFailed(e.err)
+		p.problem = &problem
+		return Reset{p}
+	})
+	handle(func(a *App, _ restartClient) interface{} {
+		// the client was shut down by the page restarting it
+		a.c = nil
+		a.state.SetConnected(false)
+		return Reset{newSignInPage(a)}
+	})
+	handle(func(a *App, e unlockSuccess) interface{} {
+		// the statefile was checked by setupCatShadow
+		a.failedUnlocks = 0
+		if err := a.saveUnlockDelay(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save the unlock delay: %v\n", err)
+		}
+		a.resetCaches()
+		a.c = e.client
+		a.c.Start()
+		a.lastActivity = time.Now()
+		a.stack.Clear(newHomePage(a))
+		if _, err := a.c.GetBlob("AutoConnect"); err == nil {
+			return a.online()
+		}
+		return nil
+	})
+	handle(func(a *App, _ OnlineClick) interface{} {
+		return a.online()
+	})
+	handle(func(a *App, _ OfflineClick) interface{} {
+		a.stayOffline()
+		c, done := a.c, a.connectDone
+		go func() {
+			// a canceled attempt may still bring the client online
+			// before it returns
+			if done != nil {
+				<-done
 			}
-		case OfflineClick:
-			go a.c.Offline()
-			isConnected = false
-			isConnecting = false
-		case OnlineClick:
-			go a.c.Online(context.TODO())
-			isConnecting = true
-			spool := a.c.SpoolWriteDescriptor()
-			if spool == nil {
-				a.stack.Push(newSpoolPage(a))
+			c.Offline()
+		}()
+		a.state.SetConnected(false)
+		return nil
+	})
+}
+
+// online connects the client, and if the client does not already have a
+// spool descriptor, prompts to create one
+func (a *App) online() interface{} {
+	a.wantOnline = true
+	a.cancelReconnect()
+	a.connect(false)
+	if a.c.SpoolWriteDescriptor() == nil {
+		return Push{newSpoolPage(a)}
+	}
+	return nil
+}
+
+// connect starts a connection attempt, first closing the session the client
+// lost its connection with if restart is set. With Use Tor and a Tor control
+// port set, the client connects once Tor has bootstrapped.
+func (a *App) connect(restart bool) {
+	a.stopConnecting()
+	a.state.SetConnecting()
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancelConnect = cancel
+	prev, done := a.connectDone, make(chan struct{})
+	a.connectDone = done
+	c := a.c
+	s := a.proxySettings()
+	_, err := c.GetBlob("UseTor")
+	waitTor := err == nil && s.ControlAddress != ""
+	go func() {
+		defer close(done)
+		// the client only goes online once, so the previous attempt
+		// must have returned
+		if prev != nil {
+			<-prev
+		}
+		if restart {
+			// the client keeps the session until it goes offline, and
+			// does not go online again before
+			c.Offline()
+		}
+		if waitTor {
+			err := waitForTor(ctx, s.ControlAddress, s.ControlPassword, a.state.SetTorStatus)
+			a.state.ClearTorStatus()
+			if ctx.Err() != nil {
+				return
 			}
-		case ShowSettingsClick:
-			a.stack.Push(newSettingsPage(a))
-		case AddContactClick:
-			a.stack.Push(newAddContactPage(a))
-		case AddContactComplete:
-			a.stack.Pop()
-			p := a.stack.Current()
-			// return to home page, and update the contacts page
-			if h, ok := p.(*HomePage); ok {
-				h.UpdateContacts()
+			if err != nil {
+				// the control port is only used to wait, and the
+				// proxy may still work
+				warnTorControl(err)
+			}
+		}
+		if err := c.Online(ctx); err != nil && ctx.Err() == nil {
+			select {
+			case a.connectFailures <- connectFailed{err: err}:
+			case <-ctx.Done():
 			}
-		case ChooseContactClick:
-			a.stack.Push(newConversationPage(a, e.nickname))
-		case ChooseAvatar:
-			a.stack.Push(newAvatarPicker(a, e.nickname, ""))
-		case ChooseAvatarPath:
-			a.stack.Pop()
-			a.stack.Push(newAvatarPicker(a, e.nickname, e.path))
-		case RenameContact:
-			a.stack.Push(newRenameContactPage(a, e.nickname))
-		case EditContact:
-			a.stack.Push(newEditContactPage(a, e.nickname))
-		case EditContactComplete:
-			a.stack.Clear(newHomePage(a))
-		case MessageSent:
 		}
+	}()
+}
+
+// stopConnecting cancels the connection attempt in progress
+func (a *App) stopConnecting() {
+	if a.cancelConnect != nil {
+		a.cancelConnect()
+		a.cancelConnect = nil
 	}
 }
 
+// stayOffline cancels the connection attempt in progress and any
+// reconnection, as the user no longer wants the client connected
+func (a *App) stayOffline() {
+	a.wantOnline = false
+	a.cancelReconnect()
+	a.reconnects = 0
+	a.stopConnecting()
+}
+
 func (a *App) run() error {
 	// on Android, this will start a foreground service, and does nothing on other platforms
 	cancelForeground, err := app.Start("Background Connection", "")
@@ -203,10 +310,15 @@ func (a *App) run() error {
 		}
 	}()
 
+	redraw := time.NewTicker(1 * time.Minute)
+	defer redraw.Stop()
+	idle := time.NewTicker(idleCheckInterval)
+	defer idle.Stop()
+
 	// select from all event sources
 	for {
 		select {
-		case e := <-a.c.EventSink:
+		case e := <-a.events():
 			if err := a.handleCatshadowEvent(e); err != nil {
 				return err
 			}
@@ -216,15 +328,30 @@ func (a *App) run() error {
 				return err
 			}
 			ackCh <- struct{}{}
-		case <-time.After(1 * time.Minute):
+		case <-redraw.C:
 			// redraw the screen to update the message timestamps once per minute
 			a.w.Invalidate()
+		case <-idle.C:
+			a.checkIdle()
+		case e := <-a.connectFailures:
+			if err := a.handleCatshadowEvent(e); err != nil {
+				return err
+			}
+		case <-a.reconnectC():
+			a.retry()
 		}
 	}
 }
 
 func main() {
 	flag.Parse()
+	if err := checkMinScore(*minScore); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *checkStateFlag {
+		os.Exit(checkStateMain(os.Stdin, os.Stdout, *repairState))
+	}
 	fmt.Println("Katzenpost is still pre-alpha.  DO NOT DEPEND ON IT FOR STRONG SECURITY OR ANONYMITY.")
 
 	if *debug != 0 {
@@ -249,15 +376,7 @@ func uiMain() {
 		)
 
 		// theme must be declared AFTER NewWindow on android
-		th = func() *material.Theme {
-			th := material.NewTheme()
-			th.Shaper = text.NewShaper(text.WithCollection(gofont.Collection()))
-			th.Bg = rgb(0x0)
-			th.Fg = rgb(0xFFFFFFFF)
-			th.ContrastBg = rgb(0x22222222)
-			th.ContrastFg = rgb(0x77777777)
-			return th
-		}()
+		th = newTheme()
 
 		if err := newApp(w).run(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed: %v\n", err)
@@ -267,6 +386,17 @@ func uiMain() {
 	app.Main()
 }
 
+// newTheme returns the katzen color scheme and font collection
+func newTheme() *material.Theme {
+	th := material.NewTheme()
+	th.Shaper = text.NewShaper(text.WithCollection(gofont.Collection()))
+	th.Bg = rgb(0x0)
+	th.Fg = rgb(0xFFFFFFFF)
+	th.ContrastBg = rgb(0x22222222)
+	th.ContrastFg = rgb(0x77777777)
+	return th
+}
+
 type (
 	C = layout.Context
 	D = layout.Dimensions
@@ -275,9 +405,14 @@ type (
 func (a *App) handleCatshadowEvent(e interface{}) error {
 	switch event := e.(type) {
 	case *client.ConnectionStatusEvent:
-		isConnecting = false
+		a.state.AddConnectionEvent(connectionEvent{when: time.Now(), connected: event.IsConnected, err: event.Err})
+		if !event.IsConnected && a.state.Connecting() {
+			// the session closed before reconnecting
+			break
+		}
+		a.state.SetConnected(event.IsConnected)
 		if event.IsConnected {
-			isConnected = true
+			a.reconnects = 0
 			go func() {
 				if n, err := notify.Push("Connected", "Katzen has connected"); err == nil {
 					<-time.After(notificationTimeout)
@@ -285,7 +420,7 @@ func (a *App) handleCatshadowEvent(e interface{}) error {
 				}
 			}()
 		} else {
-			isConnected = false
+			a.scheduleReconnect()
 			go func() {
 				if n, err := notify.Push("Disconnected", "Katzen has disconnected"); err == nil {
 					<-time.After(notificationTimeout)
@@ -301,6 +436,10 @@ func (a *App) handleCatshadowEvent(e interface{}) error {
 				}
 			}()
 		}
+	case connectFailed:
+		a.state.AddConnectionEvent(connectionEvent{when: time.Now(), err: event.err})
+		a.state.SetConnected(false)
+		a.scheduleReconnect()
 	case *catshadow.KeyExchangeCompletedEvent:
 		if event.Err != nil {
 			if n, err := notify.Push("Key Exchange", fmt.Sprintf("Failed: %s", event.Err)); err == nil {
@@ -312,10 +451,22 @@ func (a *App) handleCatshadowEvent(e interface{}) error {
 			}
 		}
 	case *catshadow.MessageNotSentEvent:
+		a.messages.Event(event)
 		if n, err := notify.Push("Message Not Sent", fmt.Sprintf("Failed to send message to %s", event.Nickname)); err == nil {
 			go func() { <-time.After(notificationTimeout); n.Cancel() }()
 		}
 	case *catshadow.MessageReceivedEvent:
+		// keep completed attachments after the messages expire, and
+		// honour the requests to delete messages
+		if _, _, ok := parseFragment(event.Message); ok || bytes.HasPrefix(event.Message, []byte(envelopeMagic)) {
+			a.storeAttachments(event.Nickname, event.Message)
+			a.applyDeleteRequests(event.Nickname)
+		}
+		a.indexConversation(event.Nickname)
+		if e, err := decodeEnvelope(event.Message); err == nil && e.IsDeleteRequest() {
+			// do not notify for delete requests
+			break
+		}
 		// do not notify for the focused conversation
 		p := a.stack.Current()
 		switch p := p.(type) {
@@ -329,14 +480,13 @@ func (a *App) handleCatshadowEvent(e interface{}) error {
 		}
 		// emit a notification in all other cases
 		if n, err := notify.Push("Message Received", fmt.Sprintf("Message Received from %s", event.Nickname)); err == nil {
-			if o, ok := notifications[event.Nickname]; ok {
-				// cancel old notification before replacing with a new one
-				o.Cancel()
-			}
-			notifications[event.Nickname] = n
+			a.state.SetNotification(event.Nickname, n)
 		}
 	case *catshadow.MessageSentEvent:
+		a.messages.Event(event)
+		a.indexConversation(event.Nickname)
 	case *catshadow.MessageDeliveredEvent:
+		a.messages.Event(event)
 	default:
 		// do not invalidate window for events we do not care about
 		return nil
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"gioui.org/app"
+)
+
+func TestMain(m *testing.M) {
+	th = newTheme()
+	os.Exit(m.Run())
+}
+
+// newTestApp returns an App without a platform window, backed by m
+func newTestApp(m Messenger) *App {
+	a := newApp(new(app.Window))
+	a.c = m
+	return a
+}
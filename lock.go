@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"time"
+
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+)
+
+// lockTimeoutBlob is the blob holding the idle time, in minutes, after which
+// katzen locks. It locks only when asked if the blob is missing.
+const lockTimeoutBlob = "LockTimeout"
+
+// lockTimeouts are the idle times that can be chosen in the settings
+var lockTimeouts = []time.Duration{0, time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// idleCheckInterval is how often the idle time is compared to the timeout
+const idleCheckInterval = 10 * time.Second
+
+// LockClick is the event that locks katzen
+type LockClick struct{}
+
+// lockTimeout returns the idle time after which katzen locks, or 0 if it
+// does not lock by itself
+func (a *App) lockTimeout() time.Duration {
+	b, err := a.c.GetBlob(lockTimeoutBlob)
+	if err != nil {
+		return 0
+	}
+	minutes, err := strconv.Atoi(string(b))
+	if err != nil || minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// setLockTimeout stores the idle time after which katzen locks
+func (a *App) setLockTimeout(d time.Duration) {
+	if d <= 0 {
+		a.c.DeleteBlob(lockTimeoutBlob)
+		return
+	}
+	a.c.AddBlob(lockTimeoutBlob, []byte(strconv.Itoa(int(d/time.Minute))))
+}
+
+// formatLockTimeout returns the label of a lock timeout setting
+func formatLockTimeout(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "Never"
+	case d < time.Hour:
+		return strconv.Itoa(int(d/time.Minute)) + " min"
+	default:
+		return strconv.Itoa(int(d/time.Hour)) + " h"
+	}
+}
+
+// layoutActivity records pointer input anywhere in the window as activity,
+// without taking it from the widgets underneath. Keyboard input is noticed
+// through the events it causes on the page.
+func (a *App) layoutActivity(gtx layout.Context) {
+	for {
+		_, ok := gtx.Event(pointer.Filter{Target: &a.lastActivity, Kinds: pointer.Press | pointer.Move | pointer.Drag})
+		if !ok {
+			break
+		}
+		a.lastActivity = time.Now()
+	}
+	defer clip.Rect(image.Rectangle{Max: gtx.Constraints.Max}).Push(gtx.Ops).Pop()
+	defer pointer.PassOp{}.Push(gtx.Ops).Pop()
+	event.Op(gtx.Ops, &a.lastActivity)
+}
+
+// checkIdle locks katzen if it is unlocked and was not used for longer than
+// the lock timeout
+func (a *App) checkIdle() {
+	if a.c == nil {
+		return
+	}
+	if d := a.lockTimeout(); d > 0 && time.Since(a.lastActivity) > d {
+		a.lock()
+		a.w.Invalidate()
+	}
+}
+
+// lock shuts down the client and returns to the sign in page, dropping the
+// decrypted state held by the app. The pages, and the conversations they
+// hold, are dropped with the page stack before the client is released.
+func (a *App) lock() {
+	a.stack.Clear(newSignInPage(a))
+	a.stayOffline()
+	a.c.Shutdown()
+	a.c = nil
+	a.state.SetConnected(false)
+	a.state.ForgetConnectionEvents()
+	a.state.CancelNotifications()
+	a.resetCaches()
+}
+
+// events returns the events of the client, or nil while katzen is locked
+func (a *App) events() <-chan interface{} {
+	if a.c == nil {
+		return nil
+	}
+	return a.c.Events()
+}
+
+func init() {
+	handle(func(a *App, _ LockClick) interface{} {
+		a.lock()
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	memspoolclient "github.com/katzenpost/katzenpost/memspool/client"
+)
+
+func TestLock(t *testing.T) {
+	useStateFile(t)
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newHomePage(a)
+		p.UpdateContacts()
+		return p
+	})
+	h.a.indexAll()
+	h.frames(2)
+	if _, ok := h.a.state.Avatar("alice"); !ok {
+		t.Fatal("avatar was not cached by the home page")
+	}
+
+	h.click(image.Pt(400-3*36-18, 18))
+	if _, ok := h.current().(*signInPage); !ok || h.a.stack.Len() != 1 {
+		t.Fatalf("current page is %T with %d pages, want only the sign in page", h.current(), h.a.stack.Len())
+	}
+	select {
+	case <-f.HaltCh():
+	default:
+		t.Error("client was not shut down")
+	}
+	if h.a.c != nil {
+		t.Error("client is still referenced")
+	}
+	if _, ok := h.a.state.Avatar("alice"); ok {
+		t.Error("avatars are still cached")
+	}
+	if len(h.a.index.Search("mixnet")) != 0 {
+		t.Error("conversations are still indexed")
+	}
+	// the idle check does not shut down a client while locked
+	h.a.lastActivity = time.Time{}
+	h.a.checkIdle()
+	if _, ok := h.current().(*signInPage); !ok {
+		t.Errorf("current page is %T after an idle check while locked", h.current())
+	}
+}
+
+func TestIdleLock(t *testing.T) {
+	useStateFile(t)
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newHomePage(a)
+	})
+	h.a.lastActivity = time.Now().Add(-time.Hour)
+	h.a.checkIdle()
+	if _, ok := h.current().(*HomePage); !ok {
+		t.Fatal("locked without a lock timeout")
+	}
+
+	h.a.setLockTimeout(5 * time.Minute)
+	if d := h.a.lockTimeout(); d != 5*time.Minute {
+		t.Fatalf("lock timeout is %v, want 5m", d)
+	}
+	// pointer input counts as activity
+	h.click(image.Pt(200, 300))
+	if time.Since(h.a.lastActivity) > time.Second {
+		t.Fatal("pointer input was not recorded as activity")
+	}
+	h.a.checkIdle()
+	if _, ok := h.current().(*HomePage); !ok {
+		t.Fatal("locked while in use")
+	}
+
+	h.a.lastActivity = time.Now().Add(-6 * time.Minute)
+	h.a.checkIdle()
+	if _, ok := h.current().(*signInPage); !ok {
+		t.Fatalf("current page is %T after the timeout, want *signInPage", h.current())
+	}
+}
+
+func TestUnlockAfterLock(t *testing.T) {
+	useStateFile(t)
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newHomePage(a)
+	})
+	h.a.navigate(LockClick{})
+
+	// the client started by the next sign in reconnects
+	g := newPopulatedMessenger()
+	g.AddBlob("AutoConnect", []byte{1})
+	g.spool = &memspoolclient.SpoolWriteDescriptor{Provider: "provider"}
+	h.a.navigate(unlockSuccess{client: g})
+	if _, ok := h.current().(*HomePage); !ok {
+		t.Fatalf("current page is %T after unlocking, want *HomePage", h.current())
+	}
+	if !h.a.state.Connecting() && !h.a.state.Connected() {
+		t.Error("client did not reconnect after unlocking")
+	}
+}
+
+func TestLockTimeoutSetting(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newSettingsPage(a)
+	})
+	p := h.current().(*SettingsPage)
+	for _, want := range append(lockTimeouts[1:], 0) {
+		p.lockTimeout.Click()
+		h.frames(2)
+		if d := h.a.lockTimeout(); d != want {
+			t.Fatalf("lock timeout is %v, want %v", d, want)
+		}
+	}
+}
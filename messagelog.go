@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// messageRecord is what katzen observed about a message it sent
+type messageRecord struct {
+	id        catshadow.MessageID
+	nickname  string
+	payload   []byte
+	created   time.Time
+	sent      time.Time
+	delivered time.Time
+	failures  []messageFailure
+}
+
+// messageFailure is a MessageNotSentEvent reported for a message
+type messageFailure struct {
+	when time.Time
+	err  error
+}
+
+// messageLog records the IDs and delivery events of the messages sent in
+// this session. catshadow does not expose the MessageID of the messages in
+// a conversation, so outbound messages are matched to their records by
+// payload, and received messages have no record.
+type messageLog struct {
+	sync.Mutex
+
+	records map[catshadow.MessageID]*messageRecord
+	bound   map[*catshadow.Message]*messageRecord
+}
+
+func newMessageLog() *messageLog {
+	return &messageLog{
+		records: make(map[catshadow.MessageID]*messageRecord),
+		bound:   make(map[*catshadow.Message]*messageRecord),
+	}
+}
+
+// Sent records that payload was passed to SendMessage and assigned id
+func (l *messageLog) Sent(nickname string, id catshadow.MessageID, payload []byte) {
+	l.Lock()
+	defer l.Unlock()
+	l.records[id] = &messageRecord{id: id, nickname: nickname, payload: payload, created: time.Now()}
+}
+
+// Event updates the records with a catshadow delivery event
+func (l *messageLog) Event(e interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	switch e := e.(type) {
+	case *catshadow.MessageSentEvent:
+		if r, ok := l.records[e.MessageID]; ok {
+			r.sent = time.Now()
+		}
+	case *catshadow.MessageDeliveredEvent:
+		if r, ok := l.records[e.MessageID]; ok {
+			r.delivered = time.Now()
+		}
+	case *catshadow.MessageNotSentEvent:
+		r, ok := l.records[e.MessageID]
+		if !ok {
+			r = &messageRecord{id: e.MessageID, nickname: e.Nickname, created: time.Now()}
+			l.records[e.MessageID] = r
+		}
+		r.failures = append(r.failures, messageFailure{when: time.Now(), err: e.Err})
+	}
+}
+
+// Lookup returns a copy of the record of an outbound message in the
+// conversation with nickname, or false if it was not sent in this session
+func (l *messageLog) Lookup(nickname string, msg *catshadow.Message) (messageRecord, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if r, ok := l.bound[msg]; ok {
+		return *r, true
+	}
+	if !msg.Outbound {
+		return messageRecord{}, false
+	}
+	// bind the oldest unbound record with the same payload, so that
+	// repeated messages are matched in the order they were sent
+	var match *messageRecord
+	for _, r := range l.records {
+		if r.nickname != nickname || !bytes.Equal(r.payload, msg.Plaintext) || l.isBound(r) {
+			continue
+		}
+		if match == nil || r.created.Before(match.created) {
+			match = r
+		}
+	}
+	if match == nil {
+		return messageRecord{}, false
+	}
+	l.bound[msg] = match
+	return *match, true
+}
+
+func (l *messageLog) isBound(r *messageRecord) bool {
+	for _, b := range l.bound {
+		if b == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+	"github.com/katzenpost/katzenpost/client/config"
+	"github.com/katzenpost/katzenpost/core/pki"
+	memspoolclient "github.com/katzenpost/katzenpost/memspool/client"
+)
+
+// EventSource delivers the events emitted by a messaging backend, such as
+// *catshadow.MessageReceivedEvent or *client.ConnectionStatusEvent.
+type EventSource interface {
+	Events() <-chan interface{}
+}
+
+// Messenger is the set of operations katzen's pages perform against a
+// messaging backend. catshadowMessenger wraps *catshadow.Client, and tests
+// may substitute an in-memory implementation.
+type Messenger interface {
+	EventSource
+
+	// lifecycle
+	Start()
+	Shutdown()
+	Wait()
+	HaltCh() <-chan interface{}
+
+	// connectivity
+	Online(ctx context.Context) error
+	Offline() error
+	SpoolWriteDescriptor() *memspoolclient.SpoolWriteDescriptor
+	GetSpoolProviders() ([]string, error)
+	CreateRemoteSpoolOn(provider string) error
+
+	// diagnostics
+	GetPKIDocument() (*pki.Document, error)
+	ClientConfig() *config.Config
+
+	// contacts
+	NewContact(nickname string, sharedSecret []byte)
+	GetContacts() map[string]*catshadow.Contact
+	RemoveContact(nickname string) error
+	RenameContact(oldname, newname string) error
+	GetExpiration(nickname string) (time.Duration, error)
+	ChangeExpiration(nickname string, expiration time.Duration) error
+
+	// conversations
+	DoubleRatchetPayloadLength() int
+	SendMessage(nickname string, message []byte) catshadow.MessageID
+	GetSortedConversation(nickname string) catshadow.Messages
+	WipeConversation(nickname string) error
+
+	// persistent key/value storage
+	AddBlob(id string, blob []byte) error
+	GetBlob(id string) ([]byte, error)
+	DeleteBlob(id string) error
+}
+
+// catshadowMessenger adapts *catshadow.Client to the Messenger interface
+type catshadowMessenger struct {
+	*catshadow.Client
+	cfg *config.Config
+}
+
+// Events returns the catshadow.Client EventSink
+func (m *catshadowMessenger) Events() <-chan interface{} {
+	return m.EventSink
+}
+
+// ClientConfig returns the client configuration the client was started with
+func (m *catshadowMessenger) ClientConfig() *config.Config {
+	return m.cfg
+}
+
+func newCatshadowMessenger(c *catshadow.Client, cfg *config.Config) Messenger {
+	return &catshadowMessenger{Client: c, cfg: cfg}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/notify"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/client/config"
+)
+
+// Imported client configurations are kept in the statefile, as the TOML they
+// were imported from, so each profile has its own networks to choose from.
+const (
+	// networkBlobPrefix is the prefix of the blob holding a network
+	networkBlobPrefix = "network://"
+	// networksBlob holds the sorted names of the imported networks
+	networksBlob = "Networks"
+	// selectedNetworkBlob holds the name of the network the client uses,
+	// and is missing if it uses the default one
+	selectedNetworkBlob = "Network"
+	maxNetworkName      = 64
+)
+
+var (
+	errNetworkName   = errors.New("a network needs a name")
+	errNetworkExists = errors.New("a network with this name already exists")
+)
+
+// loadNetwork parses and validates the client configuration of a network
+func loadNetwork(b []byte) (*config.Config, error) {
+	cfg, err := config.Load(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidConfig, err)
+	}
+	return cfg, nil
+}
+
+// stateNetwork returns the client configuration of the network chosen in
+// blobs, the Blob of a state, or nil if the default network is used
+func stateNetwork(blobs map[string][]byte) ([]byte, error) {
+	name, ok := blobs[selectedNetworkBlob]
+	if !ok {
+		return nil, nil
+	}
+	b, ok := blobs[networkBlobPrefix+string(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: the network %s is missing", errInvalidConfig, name)
+	}
+	return b, nil
+}
+
+// networkSummary describes a client configuration to the user
+type networkSummary struct {
+	authorities []string
+	geometry    string
+	schemes     string
+}
+
+// summarizeNetwork returns the summary of a validated client configuration
+func summarizeNetwork(cfg *config.Config) networkSummary {
+	var s networkSummary
+	if cfg.VotingAuthority != nil {
+		for _, a := range cfg.VotingAuthority.Peers {
+			s.authorities = append(s.authorities, fmt.Sprintf("%s (%s)", a.Identifier, strings.Join(a.Addresses, ", ")))
+		}
+	}
+	if g := cfg.SphinxGeometry; g != nil {
+		scheme := g.NIKEName
+		if scheme == "" {
+			scheme = g.KEMName
+		}
+		s.geometry = fmt.Sprintf("%d hops, %d byte packets with %d byte payloads, %s", g.NrHops, g.PacketLength, g.UserForwardPayloadLength, scheme)
+	}
+	s.schemes = fmt.Sprintf("%s link, %s signatures, %s ratchet", cfg.WireKEMScheme, cfg.PKISignatureScheme, cfg.RatchetNIKEScheme)
+	return s
+}
+
+// networks returns the names of the imported networks
+func (a *App) networks() []string {
+	b, err := a.c.GetBlob(networksBlob)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	if err := cbor.Unmarshal(b, &names); err != nil {
+		return nil
+	}
+	return names
+}
+
+// network returns the client configuration of the network called name
+func (a *App) network(name string) (*config.Config, error) {
+	b, err := a.c.GetBlob(networkBlobPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	return loadNetwork(b)
+}
+
+// importNetwork validates the client configuration at path and stores it as
+// the network called name, or after the file if name is empty
+func (a *App) importNetwork(name, path string) (string, *config.Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, err := loadNetwork(b)
+	if err != nil {
+		return "", nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return "", nil, errNetworkName
+	}
+	if len(name) > maxNetworkName {
+		return "", nil, fmt.Errorf("the network name is longer than %d bytes", maxNetworkName)
+	}
+	names := a.networks()
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return "", nil, errNetworkExists
+		}
+	}
+	names = append(names, name)
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	index, err := cbor.Marshal(names)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := a.c.AddBlob(networkBlobPrefix+name, b); err != nil {
+		return "", nil, err
+	}
+	return name, cfg, a.c.AddBlob(networksBlob, index)
+}
+
+// removeNetwork deletes the network called name, and returns to the default
+// network if it was chosen
+func (a *App) removeNetwork(name string) error {
+	var names []string
+	for _, n := range a.networks() {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	if a.selectedNetwork() == name {
+		a.c.DeleteBlob(selectedNetworkBlob)
+	}
+	if len(names) == 0 {
+		a.c.DeleteBlob(networksBlob)
+	} else {
+		index, err := cbor.Marshal(names)
+		if err != nil {
+			return err
+		}
+		if err := a.c.AddBlob(networksBlob, index); err != nil {
+			return err
+		}
+	}
+	return a.c.DeleteBlob(networkBlobPrefix + name)
+}
+
+// selectedNetwork returns the name of the network chosen for the client, or
+// an empty string for the default network
+func (a *App) selectedNetwork() string {
+	b, err := a.c.GetBlob(selectedNetworkBlob)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// selectNetwork chooses the network called name for the next time the
+// client starts, or the default network if name is empty
+func (a *App) selectNetwork(name string) error {
+	if name == "" {
+		a.c.DeleteBlob(selectedNetworkBlob)
+		return nil
+	}
+	return a.c.AddBlob(selectedNetworkBlob, []byte(name))
+}
+
+// networkRow holds the buttons of a network in the NetworksPage
+type networkRow struct {
+	name   string
+	show   *widget.Clickable
+	use    *widget.Clickable
+	remove *widget.Clickable
+}
+
+// NetworksPage imports client configurations, and chooses the one the client
+// uses
+type NetworksPage struct {
+	a       *App
+	back    *widget.Clickable
+	list    *layout.List
+	rows    []*networkRow
+	name    *widget.Editor
+	path    *widget.Editor
+	add     *widget.Clickable
+	summary *networkSummary
+	// shown is the name of the network summarized
+	shown  string
+	errMsg string
+}
+
+// ShowNetworksClick is the event that opens the NetworksPage
+type ShowNetworksClick struct{}
+
+// refresh rebuilds the rows after networks are imported or removed. The
+// first row is the default network.
+func (p *NetworksPage) refresh() {
+	p.rows = []*networkRow{{show: &widget.Clickable{}, use: &widget.Clickable{}, remove: &widget.Clickable{}}}
+	for _, name := range p.a.networks() {
+		p.rows = append(p.rows, &networkRow{name: name, show: &widget.Clickable{}, use: &widget.Clickable{}, remove: &widget.Clickable{}})
+	}
+}
+
+// show summarizes the network called name
+func (p *NetworksPage) show(name string) {
+	cfg, err := p.a.network(name)
+	if err != nil {
+		p.errMsg = err.Error()
+		return
+	}
+	s := summarizeNetwork(cfg)
+	p.summary, p.shown, p.errMsg = &s, name, ""
+}
+
+// Layout returns the networks and the import form
+func (p *NetworksPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.Widget {
+		return func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		}
+	}
+	selected := p.a.selectedNetwork()
+	row := func(r *networkRow) layout.Widget {
+		return func(gtx C) D {
+			name := r.name
+			if name == "" {
+				name = "Default"
+			}
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, func(gtx C) D {
+					if r.name == "" {
+						return inset.Layout(gtx, material.Body1(th, name).Layout)
+					}
+					return material.Clickable(gtx, r.show, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, name).Layout)
+					})
+				}),
+				layout.Rigid(func(gtx C) D {
+					label := "Use"
+					if r.name == selected {
+						label = "In use"
+						gtx = gtx.Disabled()
+					}
+					return inset.Layout(gtx, material.Button(th, r.use, label).Layout)
+				}),
+				layout.Rigid(func(gtx C) D {
+					if r.name == "" {
+						return D{}
+					}
+					return inset.Layout(gtx, material.Button(th, r.remove, "Remove").Layout)
+				}),
+			)
+		}
+	}
+	var widgets []layout.Widget
+	for _, r := range p.rows {
+		widgets = append(widgets, row(r))
+	}
+	if s := p.summary; s != nil {
+		widgets = append(widgets, func(gtx C) D {
+			return inset.Layout(gtx, material.H6(th, p.shown).Layout)
+		})
+		for _, a := range s.authorities {
+			widgets = append(widgets, func(gtx C) D {
+				return inset.Layout(gtx, material.Body2(th, "Authority "+a).Layout)
+			})
+		}
+		widgets = append(widgets,
+			func(gtx C) D {
+				return inset.Layout(gtx, material.Body2(th, "Sphinx: "+s.geometry).Layout)
+			},
+			func(gtx C) D {
+				return inset.Layout(gtx, material.Body2(th, "Schemes: "+s.schemes).Layout)
+			},
+		)
+	}
+	widgets = append(widgets,
+		func(gtx C) D {
+			msg := "A new network is used when the client restarts"
+			if p.a.configFile() != "" {
+				msg = "The client configuration file of this profile is used instead"
+			}
+			return inset.Layout(gtx, material.Caption(th, msg).Layout)
+		},
+		field(p.name, "Network name"),
+		field(p.path, "Client configuration file"),
+		func(gtx C) D {
+			return inset.Layout(gtx, material.Caption(th, p.errMsg).Layout)
+		},
+		func(gtx C) D {
+			return inset.Layout(gtx, material.Button(th, p.add, "Import").Layout)
+		},
+	)
+
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Networks").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(widgets), func(gtx C, i int) D {
+					return widgets[i](gtx)
+				})
+			}),
+		)
+	})
+}
+
+// Event handles the network buttons and the import form
+func (p *NetworksPage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	for _, ed := range []*widget.Editor{p.name, p.path} {
+		if ev, ok := ed.Update(gtx); ok {
+			if _, ok := ev.(widget.SubmitEvent); ok {
+				p.add.Click()
+			}
+		}
+	}
+	for _, r := range p.rows {
+		if r.show.Clicked(gtx) {
+			p.show(r.name)
+		}
+		if r.use.Clicked(gtx) {
+			if err := p.a.selectNetwork(r.name); err != nil {
+				p.errMsg = err.Error()
+			}
+		}
+		if r.remove.Clicked(gtx) {
+			if err := p.a.removeNetwork(r.name); err != nil {
+				p.errMsg = err.Error()
+			}
+			if p.shown == r.name {
+				p.summary, p.shown = nil, ""
+			}
+			p.refresh()
+			return nil
+		}
+	}
+	if p.add.Clicked(gtx) {
+		name, cfg, err := p.a.importNetwork(p.name.Text(), strings.TrimSpace(p.path.Text()))
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		s := summarizeNetwork(cfg)
+		p.summary, p.shown, p.errMsg = &s, name, ""
+		p.name.SetText("")
+		p.path.SetText("")
+		p.refresh()
+	}
+	return nil
+}
+
+func (p *NetworksPage) Start(stop <-chan struct{}) {
+}
+
+func warnNetwork(err error) {
+	go func() {
+		if n, err := notify.Push("Failure", fmt.Sprintf("The chosen network cannot be used, %s. Choose another in settings.", err)); err == nil {
+			<-time.After(notificationTimeout)
+			n.Cancel()
+		}
+	}()
+}
+
+func init() {
+	handle(func(a *App, _ ShowNetworksClick) interface{} {
+		return Push{newNetworksPage(a)}
+	})
+}
+
+func newNetworksPage(a *App) *NetworksPage {
+	p := &NetworksPage{
+		a:    a,
+		back: &widget.Clickable{},
+		list: &layout.List{Axis: layout.Vertical},
+		name: &widget.Editor{SingleLine: true, Submit: true},
+		path: &widget.Editor{SingleLine: true, Submit: true},
+		add:  &widget.Clickable{},
+	}
+	p.refresh()
+	return p
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeNetwork writes a client configuration to name in a temporary
+// directory
+func writeNetwork(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImportNetwork(t *testing.T) {
+	useStateFile(t)
+	a := newTestApp(newFakeMessenger())
+	path := writeNetwork(t, "testnet.toml", cfgWithoutTor)
+
+	name, cfg, err := a.importNetwork(" ", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "testnet" {
+		t.Errorf("network is called %q, want the file name", name)
+	}
+	s := summarizeNetwork(cfg)
+	if len(s.authorities) == 0 || !strings.Contains(s.geometry, "hops") || s.schemes == "" {
+		t.Errorf("incomplete summary %+v", s)
+	}
+	if _, _, err := a.importNetwork("TestNet", path); !errors.Is(err, errNetworkExists) {
+		t.Errorf("imported a network twice: %v", err)
+	}
+	invalid := writeNetwork(t, "invalid.toml", []byte("[Logging"))
+	if _, _, err := a.importNetwork("", invalid); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("imported an invalid configuration: %v", err)
+	}
+	if _, _, err := a.importNetwork("private", path); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.networks(); len(got) != 2 || got[0] != "private" || got[1] != "testnet" {
+		t.Errorf("networks %v, want [private testnet]", got)
+	}
+
+	if err := a.selectNetwork("testnet"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := a.effectiveConfig(); err != nil || !bytes.Equal(b, cfgWithoutTor) {
+		t.Errorf("backup does not use the chosen network: %v", err)
+	}
+	if err := a.removeNetwork("testnet"); err != nil {
+		t.Fatal(err)
+	}
+	if a.selectedNetwork() != "" {
+		t.Error("removed network is still chosen")
+	}
+	if got := a.networks(); len(got) != 1 || got[0] != "private" {
+		t.Errorf("networks %v after removing testnet", got)
+	}
+}
+
+func TestStateNetwork(t *testing.T) {
+	blobs := map[string][]byte{networkBlobPrefix + "testnet": cfgWithoutTor}
+	if b, err := stateNetwork(blobs); b != nil || err != nil {
+		t.Errorf("a network was chosen by default: %v", err)
+	}
+	blobs[selectedNetworkBlob] = []byte("testnet")
+	if b, err := stateNetwork(blobs); err != nil || !bytes.Equal(b, cfgWithoutTor) {
+		t.Errorf("chosen network not found: %v", err)
+	}
+	blobs[selectedNetworkBlob] = []byte("gone")
+	if _, err := stateNetwork(blobs); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("missing network: %v", err)
+	}
+}
+
+func TestNetworksPage(t *testing.T) {
+	useStateFile(t)
+	h := newPageHarness(t, newPopulatedMessenger(), func(a *App) Page {
+		return newSettingsPage(a)
+	})
+	h.a.navigate(ShowNetworksClick{})
+	p, ok := h.current().(*NetworksPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *NetworksPage", h.current())
+	}
+	p.name.SetText("Private Mixnet")
+	p.path.SetText(writeNetwork(t, "client.toml", cfgWithoutTor))
+	p.add.Click()
+	h.frames(2)
+	if p.errMsg != "" || len(p.rows) != 2 || p.summary == nil {
+		t.Fatalf("network was not imported: %s", p.errMsg)
+	}
+	h.screenshot("networks")
+
+	p.rows[1].use.Click()
+	h.frames(2)
+	if h.a.selectedNetwork() != "Private Mixnet" {
+		t.Errorf("chosen network is %q", h.a.selectedNetwork())
+	}
+	p.rows[0].use.Click()
+	h.frames(2)
+	if h.a.selectedNetwork() != "" {
+		t.Error("the default network was not chosen")
+	}
+	p.rows[1].remove.Click()
+	h.frames(2)
+	if len(p.rows) != 1 || p.summary != nil {
+		t.Error("network was not removed")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"gioui.org/io/key"
+)
+
+// newPopulatedMessenger returns a fakeMessenger with a few contacts and a
+// short conversation with alice. Timestamps are hours in the past so the
+// rendered message ages are stable for the duration of a test run.
+func newPopulatedMessenger() *fakeMessenger {
+	f := newFakeMessenger()
+	f.NewContact("alice", []byte("alice secret"))
+	f.NewContact("bob", []byte("bob secret"))
+	f.NewContact("carol", []byte("carol secret"))
+	for _, c := range f.contacts {
+		c.IsPending = false
+	}
+	f.contacts["carol"].IsPending = true
+	now := time.Now()
+	f.receive("alice", []byte("hello from the mixnet"), now.Add(-3*time.Hour))
+	f.SendMessage("alice", []byte("hi alice, loud and clear"))
+	f.contacts["alice"].LastMessage.Timestamp = now.Add(-2 * time.Hour)
+	f.receive("bob", []byte("meow"), now.Add(-5*time.Hour))
+	return f
+}
+
+func TestHomePageGolden(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newHomePage(a)
+		p.UpdateContacts()
+		return p
+	})
+	h.frames(2)
+	h.screenshot("home")
+}
+
+func TestHomePageChooseContact(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newHomePage(a)
+		p.UpdateContacts()
+		return p
+	})
+	h.frames(2)
+	// alice has the most recent message and is listed first
+	h.click(image.Pt(200, 60))
+	p, ok := h.current().(*conversationPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *conversationPage", h.current())
+	}
+	if p.nickname != "alice" {
+		t.Errorf("opened conversation with %s, want alice", p.nickname)
+	}
+}
+
+func TestHomePageShortcuts(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newHomePage(a)
+		p.UpdateContacts()
+		return p
+	})
+	h.press(key.NameDownArrow)
+	h.press(key.NameReturn)
+	p, ok := h.current().(*conversationPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *conversationPage", h.current())
+	}
+	if p.nickname != "bob" {
+		t.Errorf("opened conversation with %s, want bob", p.nickname)
+	}
+
+	h.press(key.NameEscape)
+	if _, ok := h.current().(*HomePage); !ok {
+		t.Fatalf("current page is %T after escape, want *HomePage", h.current())
+	}
+	h.press(key.NameF2)
+	if _, ok := h.current().(*AddContactPage); !ok {
+		t.Fatalf("current page is %T after F2, want *AddContactPage", h.current())
+	}
+}
+
+func TestConversationPageGolden(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "alice")
+	})
+	h.frames(2)
+	h.screenshot("conversation")
+}
+
+func TestConversationPageSend(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newConversationPage(a, "bob")
+	})
+	h.frames(2)
+	h.typeText("purr")
+	h.press(key.NameReturn)
+
+	messages := f.GetSortedConversation("bob")
+	if len(messages) != 2 {
+		t.Fatalf("conversation has %d messages, want 2", len(messages))
+	}
+	if got := messageText(messages[1].Plaintext); got != "purr" {
+		t.Errorf("sent %q, want %q", got, "purr")
+	}
+	if !messages[1].Outbound {
+		t.Error("sent message is not outbound")
+	}
+}
+
+func TestAddContactPageGolden(t *testing.T) {
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newAddContactPage(a)
+		// use a fixed secret so that the avatar and QR code are stable
+		p.contactal = &Contactal{SharedSecret: "golden secret"}
+		p.secret.SetText(p.contactal.SharedSecret)
+		return p
+	})
+	h.frames(2)
+	h.screenshot("addcontact")
+}
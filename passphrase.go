@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/notify"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"github.com/katzenpost/katzenpost/core/log"
+)
+
+var (
+	errWrongPassphrase     = errors.New("the current passphrase is not correct")
+	errPassphraseMismatch  = errors.New("the new passphrases do not match")
+	errPassphraseUnchanged = errors.New("the new passphrase is the same as the current one")
+)
+
+// rekeyBlob is added and removed again to have catshadow save the state
+const rekeyBlob = "Rekey"
+
+// rekeyStateFile re-encrypts the statefile at path from the passphrase
+// oldpw to newpw. The statefile must not be in use.
+func rekeyStateFile(path string, oldpw, newpw []byte) error {
+	// the StateWriter is only used to load the state and is not started
+	_, state, err := catshadow.LoadStateWriter(nil, path, oldpw)
+	if errors.Is(err, catshadow.DecryptStateFailed) {
+		return errWrongPassphrase
+	}
+	if err != nil {
+		return err
+	}
+	return saveState(path, state, newpw)
+}
+
+// saveState replaces the statefile at path with state, encrypted with
+// passphrase. The state is written by catshadow, to a new statefile next to
+// it that is checked before replacing it, so that the statefile is left
+// untouched if anything fails. The statefile must not be in use.
+func saveState(path string, state *catshadow.State, passphrase []byte) (err error) {
+	tmp := path + ".rekey"
+	// remove the files left by an interrupted attempt
+	for _, name := range []string{tmp, tmp + "~", tmp + ".tmp"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if err := writeState(tmp, state, passphrase); err != nil {
+		return err
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, tmp, passphrase); err != nil {
+		return fmt.Errorf("saved statefile does not load: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	// the backup kept by the StateWriter has the previous state, encrypted
+	// with the previous passphrase
+	if err := os.Remove(path + "~"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeState writes state to a new statefile at path with a catshadow
+// StateWriter for passphrase. The client that saves it is never started.
+func writeState(path string, state *catshadow.State, passphrase []byte) error {
+	// the StateWriter panics if it cannot write
+	if err := checkWritable(filepath.Dir(path)); err != nil {
+		return err
+	}
+	backendLog, err := log.New("", "ERROR", true)
+	if err != nil {
+		return err
+	}
+	w, err := catshadow.NewStateWriter(backendLog.GetLogger("catshadow_state"), path, passphrase)
+	if err != nil {
+		return err
+	}
+	w.Start()
+	c, err := catshadow.New(backendLog, nil, w, state)
+	if err != nil {
+		w.Halt()
+		return err
+	}
+	c.AddBlob(rekeyBlob, nil)
+	c.DeleteBlob(rekeyBlob)
+	// returns once the last state is written
+	w.Halt()
+	// the first state is kept as a backup
+	return os.Remove(path + "~")
+}
+
+// passphraseChanged is the result of changing the passphrase. If the client
+// was shut down, it must be restarted whether or not the change succeeded.
+type passphraseChanged struct {
+	err     error
+	stopped bool
+}
+
+// changePassphrase checks the current passphrase, then stops the client so
+// that the statefile is no longer written, and re-encrypts it
+func (a *App) changePassphrase(oldpw, newpw []byte) passphraseChanged {
+	path, err := a.statefile()
+	if err != nil {
+		return passphraseChanged{err: err}
+	}
+	// the StateWriter is only used to load the state and is not started
+	if _, _, err := catshadow.LoadStateWriter(nil, path, oldpw); err != nil {
+		if errors.Is(err, catshadow.DecryptStateFailed) {
+			err = errWrongPassphrase
+		}
+		return passphraseChanged{err: err}
+	}
+	// signing in with the duress passphrase would wipe the statefile
+	if isDuressPassphrase(path, newpw) {
+		return passphraseChanged{err: errDuressIsPassphrase}
+	}
+	a.c.Shutdown()
+	return passphraseChanged{err: rekeyStateFile(path, oldpw, newpw), stopped: true}
+}
+
+// ChangePassphrasePage asks for the current passphrase and a new one
+type ChangePassphrasePage struct {
+	a       *App
+	back    *widget.Clickable
+	submit  *widget.Clickable
+	current *widget.Editor
+	newpw   *widget.Editor
+	confirm *widget.Editor
+	result  chan passphraseChanged
+	// strength is the estimated strength of the new passphrase
+	strength passphraseStrength
+	busy     bool
+	errMsg   string
+}
+
+// ChangePassphraseClick is the event that opens the ChangePassphrasePage
+type ChangePassphraseClick struct{}
+
+// Layout returns the passphrase form
+func (p *ChangePassphrasePage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Change Passphrase").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			field(p.current, "Current passphrase"),
+			field(p.newpw, "New passphrase"),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, func(gtx C) D {
+					return layoutStrength(gtx, p.strength)
+				})
+			}),
+			field(p.confirm, "Repeat the new passphrase"),
+			layout.Rigid(func(gtx C) D {
+				msg := p.errMsg
+				if p.busy {
+					msg = "Re-encrypting statefile..."
+				}
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				if p.busy {
+					gtx = gtx.Disabled()
+				}
+				return material.Button(th, p.submit, "Change Passphrase").Layout(gtx)
+			}),
+		)
+	})
+}
+
+func (p *ChangePassphrasePage) Event(gtx layout.Context) interface{} {
+	for _, ed := range []*widget.Editor{p.current, p.newpw, p.confirm} {
+		if e, ok := ed.Update(gtx); ok {
+			switch e.(type) {
+			case widget.ChangeEvent:
+				if ed == p.newpw {
+					p.strength = editorStrength(ed)
+				}
+			case widget.SubmitEvent:
+				p.submit.Click()
+			}
+		}
+	}
+	if p.back.Clicked(gtx) && !p.busy {
+		return BackEvent{}
+	}
+	if p.submit.Clicked(gtx) && !p.busy {
+		oldpw, err := editorSecret(p.current)
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		newpw, err := readNewPassphrase(p.newpw, p.confirm)
+		switch {
+		case err != nil:
+			p.errMsg = err.Error()
+		case bytes.Equal(oldpw.Bytes(), newpw.Bytes()):
+			p.errMsg = errPassphraseUnchanged.Error()
+			newpw.destroy()
+		default:
+			p.errMsg = ""
+			p.busy = true
+			go func() {
+				defer oldpw.destroy()
+				defer newpw.destroy()
+				p.result <- p.a.changePassphrase(oldpw.Bytes(), newpw.Bytes())
+				p.a.w.Invalidate()
+			}()
+			return nil
+		}
+		oldpw.destroy()
+	}
+
+	select {
+	case r := <-p.result:
+		p.busy = false
+		if !r.stopped {
+			p.errMsg = r.err.Error()
+			p.current.SetText("")
+			gtx.Execute(key.FocusCmd{Tag: p.current})
+			return nil
+		}
+		msg := "Passphrase changed, sign in with the new passphrase"
+		if r.err != nil {
+			msg = fmt.Sprintf("Passphrase not changed: %s", r.err)
+		}
+		go func() {
+			if n, err := notify.Push("Change Passphrase", msg); err == nil {
+				<-time.After(notificationTimeout)
+				n.Cancel()
+			}
+		}()
+		return restartClient{}
+	default:
+	}
+	return nil
+}
+
+func (p *ChangePassphrasePage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, _ ChangePassphraseClick) interface{} {
+		return Push{newChangePassphrasePage(a)}
+	})
+}
+
+func newChangePassphrasePage(a *App) *ChangePassphrasePage {
+	editor := func() *widget.Editor {
+		return &widget.Editor{SingleLine: true, Mask: '*', Submit: true}
+	}
+	return &ChangePassphrasePage{
+		a:        a,
+		back:     &widget.Clickable{},
+		submit:   &widget.Clickable{},
+		current:  editor(),
+		newpw:    editor(),
+		confirm:  editor(),
+		result:   make(chan passphraseChanged, 1),
+		strength: estimateStrength(nil),
+	}
+}
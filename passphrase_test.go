@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/hpqc/rand"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// writeStateFile encrypts state with passphrase to a new file at path, as
+// catshadow does
+func writeStateFile(path string, state, passphrase []byte) error {
+	var key [32]byte
+	copy(key[:], argon2.Key(passphrase, nil, 3, 32*1024, 4, 32))
+	var nonce [24]byte
+	if _, err := rand.Reader.Read(nonce[:]); err != nil {
+		return err
+	}
+	return os.WriteFile(path, secretbox.Seal(nonce[:], state, &nonce, &key), 0600)
+}
+
+// writeTestState writes a statefile at path as catshadow would
+func writeTestState(t *testing.T, path string, passphrase []byte) {
+	t.Helper()
+	state, err := cbor.Marshal(&catshadow.State{
+		Contacts:      make([]*catshadow.Contact, 0),
+		Conversations: make(map[string]map[catshadow.MessageID]*catshadow.Message),
+		Blob:          map[string][]byte{"AutoConnect": {1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(path, state, passphrase); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useStateFile points the -s flag at a statefile in a temporary directory
+func useStateFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "catshadow_statefile")
+	prev := *stateFile
+	*stateFile = path
+	t.Cleanup(func() { *stateFile = prev })
+	return path
+}
+
+func TestRekeyStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statefile")
+	writeTestState(t, path, []byte("hunter2"))
+	if err := os.WriteFile(path+"~", []byte("previous state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rekeyStateFile(path, []byte("hunter2"), []byte("correct horse battery staple")); err != nil {
+		t.Fatal(err)
+	}
+	_, state, err := catshadow.LoadStateWriter(nil, path, []byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("statefile does not load with the new passphrase: %v", err)
+	}
+	if _, ok := state.Blob["AutoConnect"]; !ok {
+		t.Error("state was not preserved")
+	}
+	if _, ok := state.Blob[rekeyBlob]; ok {
+		t.Error("blob added to save the state was left behind")
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, path, []byte("hunter2")); err == nil {
+		t.Error("statefile still loads with the old passphrase")
+	}
+	for _, name := range []string{path + "~", path + ".rekey", path + ".rekey~"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", filepath.Base(name))
+		}
+	}
+}
+
+func TestRekeyStateFileFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statefile")
+	writeTestState(t, path, []byte("hunter2"))
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rekeyStateFile(path, []byte("hunter3"), []byte("correct horse battery staple")); err != errWrongPassphrase {
+		t.Errorf("got %v for the wrong passphrase, want errWrongPassphrase", err)
+	}
+
+	// a directory in the way of the new statefile fails the write
+	if err := os.MkdirAll(filepath.Join(path+".rekey", "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := rekeyStateFile(path, []byte("hunter2"), []byte("correct horse battery staple")); err == nil {
+		t.Error("rekey succeeded without writing the new statefile")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, orig) {
+		t.Error("statefile was modified by a failed rekey")
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("hunter2"))
+	f := newPopulatedMessenger()
+	a := newTestApp(f)
+
+	r := a.changePassphrase([]byte("hunter3"), []byte("correct horse battery staple"))
+	if r.err != errWrongPassphrase || r.stopped {
+		t.Errorf("got %+v for the wrong passphrase, want errWrongPassphrase", r)
+	}
+	select {
+	case <-f.HaltCh():
+		t.Fatal("client was stopped for the wrong passphrase")
+	default:
+	}
+
+	r = a.changePassphrase([]byte("hunter2"), []byte("correct horse battery staple"))
+	if r.err != nil || !r.stopped {
+		t.Fatalf("got %+v, want the passphrase changed", r)
+	}
+	select {
+	case <-f.HaltCh():
+	default:
+		t.Error("client was not stopped before rewriting the statefile")
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, path, []byte("correct horse battery staple")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChangePassphrasePage(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("hunter2"))
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newChangePassphrasePage(a)
+	})
+	p := h.current().(*ChangePassphrasePage)
+	p.current.SetText("hunter2")
+	p.newpw.SetText("correct horse battery staple")
+	p.confirm.SetText("correct hose")
+	p.submit.Click()
+	h.frames(2)
+	if p.errMsg != errPassphraseMismatch.Error() || p.busy {
+		t.Fatalf("got %q for mismatched passphrases", p.errMsg)
+	}
+
+	p.newpw.SetText("password1")
+	p.confirm.SetText("password1")
+	p.submit.Click()
+	h.frames(2)
+	if !strings.HasPrefix(p.errMsg, errWeakPassphrase.Error()) || p.busy {
+		t.Fatalf("got %q for a weak passphrase", p.errMsg)
+	}
+
+	p.newpw.SetText("correct horse battery staple")
+	p.confirm.SetText("correct horse battery staple")
+	p.submit.Click()
+	h.frames(2)
+	deadline := time.Now().Add(10 * time.Second)
+	for h.current() == Page(p) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		h.frame()
+	}
+	if _, ok := h.current().(*signInPage); !ok {
+		t.Fatalf("current page is %T, want *signInPage after the change", h.current())
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, path, []byte("correct horse battery staple")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gioui.org/app"
+	"github.com/katzenpost/katzenpost/client/config"
+)
+
+// Each profile is a directory under profiles in the application data
+// directory, holding its statefile, its name and optionally its own client
+// configuration. Contacts, messages and blobs live in the statefile, so
+// profiles share nothing but the data directory.
+const (
+	profilesDirName    = "profiles"
+	profileNameFile    = "name"
+	profileConfigFile  = "client.toml"
+	profileStateFile   = "catshadow_statefile"
+	lastProfileFile    = "last_profile"
+	defaultProfileName = "Default"
+	maxProfileName     = 64
+)
+
+var (
+	errProfileName   = errors.New("a profile needs a name")
+	errProfileExists = errors.New("a profile with this name already exists")
+)
+
+// profile is a separate identity with its own statefile
+type profile struct {
+	// id is the name of the profile directory, which does not change when
+	// the profile is renamed
+	id   string
+	name string
+	dir  string
+}
+
+// statefile returns the path of the statefile of the profile
+func (p *profile) statefile() string {
+	return filepath.Join(p.dir, profileStateFile)
+}
+
+// configFile returns the path of the client configuration of the profile,
+// or an empty string if it uses the default configuration
+func (p *profile) configFile() string {
+	path := filepath.Join(p.dir, profileConfigFile)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// useProfiles returns false if a statefile was given with -s, which is used
+// instead of the profiles
+func useProfiles() bool {
+	f := flag.Lookup("s")
+	return f.Value.String() == f.DefValue
+}
+
+// profileStore manages the profiles in a directory
+type profileStore struct {
+	dir string
+}
+
+// openProfiles returns the profiles in the application data directory. A
+// statefile from before profiles were added is moved into a default profile.
+func openProfiles() (*profileStore, error) {
+	dir, err := app.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	datadir := filepath.Join(dir, dataDirName)
+	s := newProfileStore(filepath.Join(datadir, profilesDirName))
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.migrate(filepath.Join(datadir, profileStateFile)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newProfileStore(dir string) *profileStore {
+	return &profileStore{dir: dir}
+}
+
+// migrate moves the statefile at legacy into a default profile if there are
+// no profiles yet
+func (s *profileStore) migrate(legacy string) error {
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	if profiles, err := s.List(); err != nil || len(profiles) > 0 {
+		return err
+	}
+	p, err := s.Create(defaultProfileName)
+	if err != nil {
+		return err
+	}
+	// the backup kept by the StateWriter is moved along, if there is one
+	if err := os.Rename(legacy+"~", p.statefile()+"~"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(legacy, p.statefile())
+}
+
+// List returns the profiles sorted by name
+func (s *profileStore) List() ([]*profile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []*profile
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p := &profile{id: e.Name(), name: e.Name(), dir: filepath.Join(s.dir, e.Name())}
+		if b, err := os.ReadFile(filepath.Join(p.dir, profileNameFile)); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			p.name = strings.TrimSpace(string(b))
+		}
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return strings.ToLower(profiles[i].name) < strings.ToLower(profiles[j].name)
+	})
+	return profiles, nil
+}
+
+// Get returns the profile with id, or nil if there is none
+func (s *profileStore) Get(id string) *profile {
+	profiles, _ := s.List()
+	for _, p := range profiles {
+		if p.id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkName returns the trimmed name, or an error if it is empty or used by
+// another profile than p
+func (s *profileStore) checkName(p *profile, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errProfileName
+	}
+	if len(name) > maxProfileName {
+		return "", fmt.Errorf("a profile name must be at most %d characters long", maxProfileName)
+	}
+	profiles, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	for _, o := range profiles {
+		if (p == nil || o.id != p.id) && strings.EqualFold(o.name, name) {
+			return "", errProfileExists
+		}
+	}
+	return name, nil
+}
+
+// Create adds a profile named name, without a statefile
+func (s *profileStore) Create(name string) (*profile, error) {
+	name, err := s.checkName(nil, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	// the directory is named after the profile, and numbered if a profile
+	// that was renamed has the same directory name
+	slug := profileSlug(name)
+	id := slug
+	for i := 2; ; i++ {
+		err = os.Mkdir(filepath.Join(s.dir, id), 0700)
+		if !os.IsExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", slug, i)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &profile{id: id, name: name, dir: filepath.Join(s.dir, id)}
+	if err := os.WriteFile(filepath.Join(p.dir, profileNameFile), []byte(name+"\n"), 0600); err != nil {
+		os.RemoveAll(p.dir)
+		return nil, err
+	}
+	return p, nil
+}
+
+// profileSlug returns a directory name for a profile named name
+func profileSlug(name string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			return unicode.ToLower(r)
+		default:
+			return '-'
+		}
+	}, name)
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		return "profile"
+	}
+	return slug
+}
+
+// Rename changes the name of p
+func (s *profileStore) Rename(p *profile, name string) error {
+	name, err := s.checkName(p, name)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(p.dir, profileNameFile), []byte(name+"\n"), 0600); err != nil {
+		return err
+	}
+	p.name = name
+	return nil
+}
+
+// SetConfig copies the client configuration at path to p, after checking
+// that it loads. An empty path makes p use the default configuration.
+func (s *profileStore) SetConfig(p *profile, path string) error {
+	dst := filepath.Join(p.dir, profileConfigFile)
+	if path == "" {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := config.Load(b); err != nil {
+		return fmt.Errorf("invalid client configuration: %w", err)
+	}
+	return os.WriteFile(dst, b, 0600)
+}
+
+// Delete removes p along with its statefile
+func (s *profileStore) Delete(p *profile) error {
+	if s.Last() != nil && s.Last().id == p.id {
+		os.Remove(filepath.Join(s.dir, lastProfileFile))
+	}
+	return os.RemoveAll(p.dir)
+}
+
+// Last returns the profile used last, or the first one if it was deleted,
+// or nil if there are no profiles
+func (s *profileStore) Last() *profile {
+	if b, err := os.ReadFile(filepath.Join(s.dir, lastProfileFile)); err == nil {
+		if p := s.Get(strings.TrimSpace(string(b))); p != nil {
+			return p
+		}
+	}
+	profiles, _ := s.List()
+	if len(profiles) == 0 {
+		return nil
+	}
+	return profiles[0]
+}
+
+// SetLast records p as the profile used last
+func (s *profileStore) SetLast(p *profile) error {
+	return os.WriteFile(filepath.Join(s.dir, lastProfileFile), []byte(p.id+"\n"), 0600)
+}
+
+// loadProfile selects the profile to sign in to: the current one if it
+// still exists, or else the last one used. A default profile is created if
+// there is none.
+func (a *App) loadProfile() error {
+	if !useProfiles() {
+		a.profile = nil
+		return nil
+	}
+	store, err := openProfiles()
+	if err != nil {
+		return err
+	}
+	a.profiles = store
+	if a.profile != nil {
+		a.profile = store.Get(a.profile.id)
+	}
+	if a.profile == nil {
+		a.profile = store.Last()
+	}
+	if a.profile == nil {
+		if a.profile, err = store.Create(defaultProfileName); err != nil {
+			return err
+		}
+	}
+	return store.SetLast(a.profile)
+}
+
+// statefile returns the path of the statefile of the current profile, or
+// the statefile given with -s
+func (a *App) statefile() (string, error) {
+	if a.profile != nil {
+		return a.profile.statefile(), nil
+	}
+	return statefilePath()
+}
+
+// configFile returns the client configuration given with -f, or else the
+// one of the current profile, or an empty string for the default one
+func (a *App) configFile() string {
+	if len(*clientConfigFile) != 0 || a.profile == nil {
+		return *clientConfigFile
+	}
+	return a.profile.configFile()
+}
+
+// statefileExists returns true if there is a statefile to unlock
+func (a *App) statefileExists() bool {
+	path, err := a.statefile()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// resetCaches drops the state kept from a previous sign in, which may have
+// been to another profile
+func (a *App) resetCaches() {
+	a.state.ForgetAvatars()
+	a.messages = newMessageLog()
+	a.index = newSearchIndex()
+	a.deletions = newDeletionCache()
+}
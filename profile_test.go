@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gioui.org/layout"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+func TestProfileStore(t *testing.T) {
+	s := newProfileStore(filepath.Join(t.TempDir(), profilesDirName))
+	if p := s.Last(); p != nil {
+		t.Fatalf("got %s as the last profile of an empty store", p.name)
+	}
+	work, err := s.Create("Work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	personal, err := s.Create(" Personal ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("work"); err != errProfileExists {
+		t.Errorf("got %v for a duplicate name, want errProfileExists", err)
+	}
+	if _, err := s.Create("  "); err != errProfileName {
+		t.Errorf("got %v for an empty name, want errProfileName", err)
+	}
+	if work.statefile() == personal.statefile() {
+		t.Error("profiles share a statefile")
+	}
+
+	// renaming keeps the directory, and a new profile with the old name
+	// gets another one
+	if err := s.Rename(work, "Office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rename(work, "personal"); err != errProfileExists {
+		t.Errorf("got %v renaming to a used name, want errProfileExists", err)
+	}
+	again, err := s.Create("Work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.id == work.id {
+		t.Error("new profile uses the directory of a renamed one")
+	}
+	profiles, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.name)
+	}
+	if len(names) != 3 || names[0] != "Office" || names[1] != "Personal" || names[2] != "Work" {
+		t.Errorf("got profiles %v, want Office, Personal, Work", names)
+	}
+	if s.Get(work.id).name != "Office" {
+		t.Error("rename was not saved")
+	}
+
+	if err := s.SetLast(personal); err != nil {
+		t.Fatal(err)
+	}
+	if s.Last().id != personal.id {
+		t.Errorf("last profile is %s, want Personal", s.Last().name)
+	}
+	if err := s.Delete(personal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(personal.dir); !os.IsNotExist(err) {
+		t.Error("deleted profile directory is left")
+	}
+	if s.Last().id != work.id {
+		t.Errorf("last profile is %s after deleting it, want the first one", s.Last().name)
+	}
+}
+
+func TestProfileConfig(t *testing.T) {
+	dir := t.TempDir()
+	s := newProfileStore(filepath.Join(dir, profilesDirName))
+	p, err := s.Create("Testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.configFile() != "" {
+		t.Error("new profile has its own configuration")
+	}
+	bad := filepath.Join(dir, "bad.toml")
+	if err := os.WriteFile(bad, []byte("[Logging\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig(p, bad); err == nil {
+		t.Error("invalid configuration was accepted")
+	}
+	good := filepath.Join(dir, "client.toml")
+	if err := os.WriteFile(good, cfgWithoutTor, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig(p, good); err != nil {
+		t.Fatal(err)
+	}
+	if p.configFile() == "" || p.configFile() == good {
+		t.Errorf("configuration is at %q, want a copy in the profile", p.configFile())
+	}
+	if err := s.SetConfig(p, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p.configFile() != "" {
+		t.Error("configuration was not cleared")
+	}
+}
+
+func TestProfileMigrate(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, profileStateFile)
+	writeTestState(t, legacy, []byte("hunter2"))
+	if err := os.WriteFile(legacy+"~", []byte("backup"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(unrelated, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newProfileStore(filepath.Join(dir, profilesDirName))
+	if err := s.migrate(legacy); err != nil {
+		t.Fatal(err)
+	}
+	p := s.Last()
+	if p == nil || p.name != defaultProfileName {
+		t.Fatalf("got %+v, want the default profile", p)
+	}
+	if _, _, err := catshadow.LoadStateWriter(nil, p.statefile(), []byte("hunter2")); err != nil {
+		t.Errorf("statefile was not moved: %v", err)
+	}
+	for _, name := range []string{legacy, legacy + "~"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", filepath.Base(name))
+		}
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProfilesPage(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	f := newPopulatedMessenger()
+	h := newPageHarness(t, f, func(a *App) Page {
+		return newSignInPage(a)
+	})
+	p := h.current().(*signInPage)
+	if h.a.profile == nil || h.a.profile.name != defaultProfileName || !p.firstRun {
+		t.Fatalf("got profile %+v, want a new default profile", h.a.profile)
+	}
+	def := h.a.profile
+
+	p.profiles.Click()
+	h.frames(2)
+	pp, ok := h.current().(*ProfilesPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *ProfilesPage", h.current())
+	}
+	pp.name.SetText("Work")
+	h.screenshot("profiles")
+	pp.create.Click()
+	h.frames(2)
+	p, ok = h.current().(*signInPage)
+	if !ok || h.a.profile.name != "Work" {
+		t.Fatalf("current page is %T for profile %s, want the sign in page for Work", h.current(), h.a.profile.name)
+	}
+	work := h.a.profile
+	path, err := h.a.statefile()
+	if err != nil || path != work.statefile() || path == def.statefile() {
+		t.Fatalf("statefile of Work is %q", path)
+	}
+	writeTestState(t, path, []byte("hunter2"))
+
+	// the last profile used is selected on the next start
+	h.a.profile = nil
+	if newSignInPage(h.a).firstRun || h.a.profile.id != work.id {
+		t.Errorf("sign in page opened %s, want Work with its statefile", h.a.profile.name)
+	}
+
+	// signing in to another profile starts without the caches of the
+	// previous one
+	h.a.state.SetAvatar("alice", func(gtx C) D { return layout.Dimensions{} })
+	h.a.indexAll()
+	h.a.navigate(unlockSuccess{client: newFakeMessenger()})
+	if _, ok := h.a.state.Avatar("alice"); ok {
+		t.Error("avatar of the previous profile is cached")
+	}
+	if h.a.index.Has("alice") {
+		t.Error("conversations of the previous profile are indexed")
+	}
+}
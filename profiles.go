@@ -0,0 +1,179 @@
+package main
+
+import (
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"golang.org/x/exp/shiny/materialdesign/icons"
+)
+
+var editIcon, _ = widget.NewIcon(icons.EditorModeEdit)
+
+// ProfilesPage lists the profiles to sign in to, and creates new ones or
+// restores them from a backup
+type ProfilesPage struct {
+	a        *App
+	back     *widget.Clickable
+	list     *layout.List
+	profiles []*profile
+	selects  map[string]*widget.Clickable
+	edits    map[string]*widget.Clickable
+	name     *widget.Editor
+	create   *widget.Clickable
+	restore  *widget.Clickable
+	errMsg   string
+}
+
+// ShowProfilesClick is the event that opens the ProfilesPage
+type ShowProfilesClick struct{}
+
+// EditProfileClick is the event that opens the EditProfilePage
+type EditProfileClick struct {
+	profile *profile
+}
+
+// ProfileSelected is the event sent when a profile is chosen to sign in to
+type ProfileSelected struct {
+	profile *profile
+}
+
+// Layout returns the list of profiles and the new profile form
+func (p *ProfilesPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Profiles").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(p.profiles), func(gtx C, i int) D {
+					pr := p.profiles[i]
+					bg := Background{Color: th.Bg, Inset: layout.Inset{Left: unit.Dp(12), Right: unit.Dp(4)}}
+					if p.a.profile != nil && p.a.profile.id == pr.id {
+						bg.Color = th.ContrastBg
+					}
+					return bg.Layout(gtx, func(gtx C) D {
+						return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+							layout.Flexed(1, func(gtx C) D {
+								return material.Clickable(gtx, p.selects[pr.id], func(gtx C) D {
+									gtx.Constraints.Min.X = gtx.Constraints.Max.X
+									return inset.Layout(gtx, material.Body1(th, pr.name).Layout)
+								})
+							}),
+							layout.Rigid(button(th, p.edits[pr.id], editIcon).Layout),
+						)
+					})
+				})
+			}),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, material.Caption(th, p.errMsg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx C) D {
+						bgField := Background{
+							Color:  th.ContrastBg,
+							Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+							Radius: unit.Dp(10),
+						}
+						return inset.Layout(gtx, func(gtx C) D {
+							return bgField.Layout(gtx, material.Editor(th, p.name, "New profile name").Layout)
+						})
+					}),
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.create, "Create").Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx C) D {
+				return inset.Layout(gtx, material.Button(th, p.restore, "Restore from backup").Layout)
+			}),
+		)
+	})
+}
+
+// Event handles profile selection and creation
+func (p *ProfilesPage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		// the sign in page is reloaded, as the current profile may have
+		// been renamed or deleted
+		return ProfileSelected{profile: p.a.profile}
+	}
+	if ev, ok := p.name.Update(gtx); ok {
+		if _, ok := ev.(widget.SubmitEvent); ok {
+			p.create.Click()
+		}
+	}
+	if p.create.Clicked(gtx) {
+		pr, err := p.a.profiles.Create(p.name.Text())
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		p.name.SetText("")
+		return ProfileSelected{profile: pr}
+	}
+	if p.restore.Clicked(gtx) {
+		return RestoreBackupClick{}
+	}
+	for _, pr := range p.profiles {
+		if p.selects[pr.id].Clicked(gtx) {
+			return ProfileSelected{profile: pr}
+		}
+		if p.edits[pr.id].Clicked(gtx) {
+			return EditProfileClick{profile: pr}
+		}
+	}
+	return nil
+}
+
+// Start reloads the profiles, which may have been changed by the
+// EditProfilePage
+func (p *ProfilesPage) Start(stop <-chan struct{}) {
+	profiles, err := p.a.profiles.List()
+	if err != nil {
+		p.errMsg = err.Error()
+	}
+	p.profiles = profiles
+	for _, pr := range profiles {
+		if _, ok := p.selects[pr.id]; !ok {
+			p.selects[pr.id] = &widget.Clickable{}
+			p.edits[pr.id] = &widget.Clickable{}
+		}
+	}
+}
+
+func init() {
+	handle(func(a *App, _ ShowProfilesClick) interface{} {
+		if a.profiles == nil {
+			return nil
+		}
+		return Push{newProfilesPage(a)}
+	})
+	handle(func(a *App, e ProfileSelected) interface{} {
+		a.profile = e.profile
+		return Reset{newSignInPage(a)}
+	})
+}
+
+func newProfilesPage(a *App) *ProfilesPage {
+	return &ProfilesPage{
+		a:       a,
+		back:    &widget.Clickable{},
+		list:    &layout.List{Axis: layout.Vertical},
+		selects: make(map[string]*widget.Clickable),
+		edits:   make(map[string]*widget.Clickable),
+		name:    &widget.Editor{SingleLine: true, Submit: true},
+		create:  &widget.Clickable{},
+		restore: &widget.Clickable{},
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/client/config"
+)
+
+// proxyBlob holds the proxySettings, and is missing if the proxy is found
+// by probing proxyProbeAddresses
+const proxyBlob = "Proxy"
+
+// proxyProbeTimeout is how long to wait for a SOCKS5 proxy to accept a
+// connection
+const proxyProbeTimeout = 3 * time.Second
+
+// maxProxyAuthLen is the longest SOCKS5 username or password
+const maxProxyAuthLen = 255
+
+// proxyProbeAddresses are the addresses probed for a SOCKS5 proxy, in order
+// of preference: the Tor daemon, then Tor Browser
+var proxyProbeAddresses = []string{"127.0.0.1:9050", "127.0.0.1:9150"}
+
+var errProxyAuth = errors.New("the proxy username and password must be set together")
+
+// proxySettings is the SOCKS5 proxy used with Use Tor
+type proxySettings struct {
+	// Address is the IP address and port of the proxy, or empty to probe
+	// proxyProbeAddresses
+	Address string
+	// User and Password are optional. Tor isolates the streams of each
+	// username and password.
+	User     string
+	Password string
+	// ControlAddress is the IP address and port of the Tor control port,
+	// which is asked for the bootstrap progress before connecting if set.
+	// ControlPassword is used if Tor does not accept its cookie, which is
+	// only used with a control port on this computer.
+	ControlAddress  string
+	ControlPassword string
+}
+
+// validate checks the settings as the client configuration does
+func (s proxySettings) validate() error {
+	for _, addr := range []string{s.Address, s.ControlAddress} {
+		if addr == "" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("the address %s is not an IP address", host)
+		}
+	}
+	if (s.User == "") != (s.Password == "") {
+		return errProxyAuth
+	}
+	if len(s.User) > maxProxyAuthLen || len(s.Password) > maxProxyAuthLen {
+		return fmt.Errorf("the proxy username and password are limited to %d bytes", maxProxyAuthLen)
+	}
+	return nil
+}
+
+// upstreamProxy returns the client configuration of the proxy at address
+func (s proxySettings) upstreamProxy(address string) *config.UpstreamProxy {
+	return &config.UpstreamProxy{
+		Type:     "socks5",
+		Network:  "tcp",
+		Address:  address,
+		User:     s.User,
+		Password: s.Password,
+	}
+}
+
+// stateProxy returns the proxy settings in blobs, the Blob of a state
+func stateProxy(blobs map[string][]byte) proxySettings {
+	var s proxySettings
+	if b, ok := blobs[proxyBlob]; ok {
+		cbor.Unmarshal(b, &s)
+	}
+	return s
+}
+
+// proxySettings returns the proxy settings of the client
+func (a *App) proxySettings() proxySettings {
+	var s proxySettings
+	if b, err := a.c.GetBlob(proxyBlob); err == nil {
+		cbor.Unmarshal(b, &s)
+	}
+	return s
+}
+
+// setProxySettings stores the proxy settings, which apply when the client
+// restarts
+func (a *App) setProxySettings(s proxySettings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	if s == (proxySettings{}) {
+		a.c.DeleteBlob(proxyBlob)
+		return nil
+	}
+	b, err := cbor.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return a.c.AddBlob(proxyBlob, b)
+}
+
+// findProxy returns address if a proxy listens there, or if address is empty
+// the first of proxyProbeAddresses with a listener. It returns an empty
+// string if none is found before ctx is done or the probes time out.
+func findProxy(ctx context.Context, address string) string {
+	candidates := proxyProbeAddresses
+	if address != "" {
+		candidates = []string{address}
+	}
+	ctx, cancel := context.WithTimeout(ctx, proxyProbeTimeout)
+	defer cancel()
+	found := make([]chan bool, len(candidates))
+	for i, addr := range candidates {
+		found[i] = make(chan bool, 1)
+		go func() {
+			var d net.Dialer
+			c, err := d.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				c.Close()
+			}
+			found[i] <- err == nil
+		}()
+	}
+	for i, addr := range candidates {
+		if <-found[i] {
+			return addr
+		}
+	}
+	return ""
+}
+
+// useProxy returns cfg with the proxy found at address. The default network
+// has a configuration for Tor, which prefers onion addresses and has the
+// ratchet scheme of the contacts made with it, so it is used instead of cfg
+// unless customConfig is set. errTorMismatch is returned if the configuration
+// is not valid with the proxy.
+func useProxy(cfg *config.Config, customConfig bool, s proxySettings, address string) (*config.Config, error) {
+	if !customConfig {
+		var err error
+		if cfg, err = config.Load(cfgWithTor); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidConfig, err)
+		}
+	}
+	cfg.UpstreamProxy = s.upstreamProxy(address)
+	if err := cfg.FixupAndValidate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", errTorMismatch, err)
+	}
+	return cfg, nil
+}
+
+// hasProxy returns true if the proxy of the settings is found
+func (a *App) hasProxy() bool {
+	return findProxy(context.Background(), a.proxySettings().Address) != ""
+}
+
+// ProxyPage sets the SOCKS5 proxy and Tor control port used with Use Tor
+type ProxyPage struct {
+	a        *App
+	back     *widget.Clickable
+	save     *widget.Clickable
+	address  *widget.Editor
+	user     *widget.Editor
+	password *widget.Editor
+	control  *widget.Editor
+	// controlPassword is the password of the control port
+	controlPassword *widget.Editor
+	list            *layout.List
+	errMsg          string
+}
+
+// ShowProxyClick is the event that opens the ProxyPage
+type ShowProxyClick struct{}
+
+// Layout returns the proxy form
+func (p *ProxyPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.Widget {
+		return func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		}
+	}
+	caption := func(msg string) layout.Widget {
+		return func(gtx C) D {
+			return inset.Layout(gtx, material.Caption(th, msg).Layout)
+		}
+	}
+	widgets := []layout.Widget{
+		caption("Leave the address empty to look for Tor on ports " + proxyPorts()),
+		field(p.address, "SOCKS5 proxy address"),
+		caption("A username and password keep the connections of this profile apart from others on the same Tor"),
+		field(p.user, "Username"),
+		field(p.password, "Password"),
+		caption("With the Tor control port, connecting waits until Tor has bootstrapped. The password is needed if Tor runs on another computer or does not accept its cookie."),
+		field(p.control, "Tor control port address"),
+		field(p.controlPassword, "Control port password"),
+		func(gtx C) D {
+			return inset.Layout(gtx, material.Caption(th, p.errMsg).Layout)
+		},
+		func(gtx C) D {
+			return inset.Layout(gtx, material.Button(th, p.save, "Save").Layout)
+		},
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Proxy").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(widgets), func(gtx C, i int) D {
+					return widgets[i](gtx)
+				})
+			}),
+		)
+	})
+}
+
+// proxyPorts returns the ports of proxyProbeAddresses for display
+func proxyPorts() string {
+	var ports []string
+	for _, addr := range proxyProbeAddresses {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	return strings.Join(ports, " and ")
+}
+
+// Event handles the proxy form
+func (p *ProxyPage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	for _, ed := range []*widget.Editor{p.address, p.user, p.password, p.control, p.controlPassword} {
+		if ev, ok := ed.Update(gtx); ok {
+			if _, ok := ev.(widget.SubmitEvent); ok {
+				p.save.Click()
+			}
+		}
+	}
+	if p.save.Clicked(gtx) {
+		s := proxySettings{
+			Address:  strings.TrimSpace(p.address.Text()),
+			User:     p.user.Text(),
+			Password: p.password.Text(),
+
+			ControlAddress:  strings.TrimSpace(p.control.Text()),
+			ControlPassword: p.controlPassword.Text(),
+		}
+		if err := p.a.setProxySettings(s); err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		return BackEvent{}
+	}
+	return nil
+}
+
+func (p *ProxyPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, _ ShowProxyClick) interface{} {
+		return Push{newProxyPage(a)}
+	})
+}
+
+func newProxyPage(a *App) *ProxyPage {
+	p := &ProxyPage{
+		a:               a,
+		back:            &widget.Clickable{},
+		save:            &widget.Clickable{},
+		address:         &widget.Editor{SingleLine: true, Submit: true},
+		user:            &widget.Editor{SingleLine: true, Submit: true},
+		password:        &widget.Editor{SingleLine: true, Submit: true, Mask: '*'},
+		control:         &widget.Editor{SingleLine: true, Submit: true},
+		controlPassword: &widget.Editor{SingleLine: true, Submit: true, Mask: '*'},
+		list:            &layout.List{Axis: layout.Vertical},
+	}
+	s := a.proxySettings()
+	p.address.SetText(s.Address)
+	p.user.SetText(s.User)
+	p.password.SetText(s.Password)
+	p.control.SetText(s.ControlAddress)
+	p.controlPassword.SetText(s.ControlPassword)
+	return p
+}
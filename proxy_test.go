@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/katzenpost/katzenpost/client/config"
+)
+
+// listen returns the address of a listener closed at the end of the test
+func listen(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().String()
+}
+
+// closedAddress returns an address without a listener
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestFindProxy(t *testing.T) {
+	tor, browser, closed := listen(t), listen(t), closedAddress(t)
+	saved := proxyProbeAddresses
+	t.Cleanup(func() { proxyProbeAddresses = saved })
+
+	ctx := context.Background()
+	if got := findProxy(ctx, browser); got != browser {
+		t.Errorf("found %q at the configured address, want %q", got, browser)
+	}
+	if got := findProxy(ctx, closed); got != "" {
+		t.Errorf("found %q without a listener", got)
+	}
+
+	proxyProbeAddresses = []string{closed, browser}
+	if got := findProxy(ctx, ""); got != browser {
+		t.Errorf("probing found %q, want %q", got, browser)
+	}
+	proxyProbeAddresses = []string{tor, browser}
+	if got := findProxy(ctx, ""); got != tor {
+		t.Errorf("probing found %q, want the preferred %q", got, tor)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if got := findProxy(canceled, tor); got != "" {
+		t.Errorf("found %q after cancel", got)
+	}
+}
+
+func TestProxySettings(t *testing.T) {
+	for _, tc := range []struct {
+		s  proxySettings
+		ok bool
+	}{
+		{proxySettings{}, true},
+		{proxySettings{Address: "192.168.1.2:9050"}, true},
+		{proxySettings{Address: "[::1]:9150", User: "katzen", Password: "x"}, true},
+		{proxySettings{Address: "tor.example:9050"}, false},
+		{proxySettings{Address: "127.0.0.1"}, false},
+		{proxySettings{User: "katzen"}, false},
+		{proxySettings{ControlAddress: "127.0.0.1:9051", ControlPassword: "x"}, true},
+		{proxySettings{ControlAddress: "localhost:9051"}, false},
+	} {
+		if err := tc.s.validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: %v", tc.s, err)
+		}
+	}
+
+	a := newTestApp(newFakeMessenger())
+	s := proxySettings{Address: "127.0.0.1:9150", User: "katzen", Password: "isolated", ControlAddress: "127.0.0.1:9151"}
+	if err := a.setProxySettings(s); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.proxySettings(); got != s {
+		t.Errorf("stored %+v, want %+v", got, s)
+	}
+	if err := a.setProxySettings(proxySettings{Password: "x"}); !errors.Is(err, errProxyAuth) {
+		t.Errorf("stored a password without a username: %v", err)
+	}
+	if err := a.setProxySettings(proxySettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.c.GetBlob(proxyBlob); err == nil {
+		t.Error("automatic proxy settings are stored")
+	}
+}
+
+func TestUseProxy(t *testing.T) {
+	s := proxySettings{User: "katzen", Password: "isolated"}
+	cfg, err := useProxy(nil, false, s, "127.0.0.1:9150")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTor, err := config.Load(cfgWithTor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RatchetNIKEScheme != withTor.RatchetNIKEScheme {
+		t.Error("default network does not use its configuration for Tor")
+	}
+	p := cfg.UpstreamProxyConfig()
+	if p == nil || p.Address != "127.0.0.1:9150" || p.User != "katzen" {
+		t.Errorf("proxy %+v was not applied", p)
+	}
+
+	network, err := config.Load(cfgWithoutTor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = useProxy(network, true, proxySettings{}, "127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != network || cfg.UpstreamProxyConfig().Address != "127.0.0.1:9050" {
+		t.Error("proxy was not applied to the chosen network")
+	}
+}
+
+func TestProxyPage(t *testing.T) {
+	useStateFile(t)
+	h := newPageHarness(t, newPopulatedMessenger(), func(a *App) Page {
+		return newSettingsPage(a)
+	})
+	h.a.navigate(ShowProxyClick{})
+	p, ok := h.current().(*ProxyPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *ProxyPage", h.current())
+	}
+	p.address.SetText("127.0.0.1:9150")
+	p.user.SetText("katzen")
+	p.save.Click()
+	h.frames(2)
+	if h.current() != Page(p) || p.errMsg != errProxyAuth.Error() {
+		t.Fatalf("saved a username without a password: %q", p.errMsg)
+	}
+	// the error is shown below the control port fields
+	p.list.Position.First = 2
+	h.frame()
+	h.screenshot("proxy")
+
+	p.password.SetText("isolated")
+	p.save.Click()
+	h.frames(2)
+	if _, ok := h.current().(*SettingsPage); !ok {
+		t.Fatalf("current page is %T after saving, want *SettingsPage", h.current())
+	}
+	if s := h.a.proxySettings(); s.Address != "127.0.0.1:9150" || s.Password != "isolated" {
+		t.Errorf("stored %+v", s)
+	}
+}
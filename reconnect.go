@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/op"
+)
+
+// reconnectBlob is present if the client reconnects after losing its
+// connection
+const reconnectBlob = "AutoReconnect"
+
+const (
+	// reconnectMinDelay is the delay before the first reconnection, which
+	// doubles with each failed attempt up to reconnectMaxDelay
+	reconnectMinDelay = 5 * time.Second
+	reconnectMaxDelay = 5 * time.Minute
+)
+
+// connectFailed is sent when a connection attempt fails without the client
+// reporting a ConnectionStatusEvent
+type connectFailed struct {
+	err error
+}
+
+// reconnectDelay returns the delay before reconnection attempt n, counted
+// from 0. The delay is between half and all of the backoff, chosen by jitter
+// in [0, 1), so that clients that lost their connection together do not
+// reconnect together.
+func reconnectDelay(n int, jitter float64) time.Duration {
+	backoff := reconnectMaxDelay
+	if n < 32 {
+		backoff = min(reconnectMinDelay<<n, reconnectMaxDelay)
+	}
+	return backoff/2 + time.Duration(jitter*float64(backoff/2))
+}
+
+// scheduleReconnect schedules a reconnection after the client lost its
+// connection, if the user wants to be connected and reconnection is enabled
+func (a *App) scheduleReconnect() {
+	if !a.wantOnline || a.reconnect != nil || a.c == nil {
+		return
+	}
+	if _, err := a.c.GetBlob(reconnectBlob); err != nil {
+		return
+	}
+	d := reconnectDelay(a.reconnects, rand.Float64())
+	a.reconnects++
+	a.reconnect = time.NewTimer(d)
+	a.state.SetRetryAt(time.Now().Add(d))
+}
+
+// cancelReconnect cancels the scheduled reconnection
+func (a *App) cancelReconnect() {
+	if a.reconnect != nil {
+		a.reconnect.Stop()
+		a.reconnect = nil
+	}
+	a.state.SetRetryAt(time.Time{})
+}
+
+// reconnectC returns the channel of the scheduled reconnection, or nil
+func (a *App) reconnectC() <-chan time.Time {
+	if a.reconnect == nil {
+		return nil
+	}
+	return a.reconnect.C
+}
+
+// retry reconnects the client when the scheduled reconnection is due
+func (a *App) retry() {
+	a.reconnect = nil
+	a.state.SetRetryAt(time.Time{})
+	if a.c != nil && a.wantOnline {
+		a.connect(true)
+	}
+}
+
+// retryText returns the time left before the reconnection at, and redraws
+// when it changes
+func retryText(gtx layout.Context, at time.Time) string {
+	left := at.Sub(gtx.Now).Round(time.Second)
+	if left < 0 {
+		left = 0
+	}
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(time.Second)})
+	return fmt.Sprintf("retrying in %ds", int(left.Seconds()))
+}
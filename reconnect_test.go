@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gioui.org/layout"
+	"github.com/katzenpost/katzenpost/client"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	if d := reconnectDelay(0, 0); d != reconnectMinDelay/2 {
+		t.Errorf("first delay without jitter is %s, want %s", d, reconnectMinDelay/2)
+	}
+	if d := reconnectDelay(0, 0.999); d >= reconnectMinDelay {
+		t.Errorf("first delay with jitter is %s, past %s", d, reconnectMinDelay)
+	}
+	if d := reconnectDelay(3, 0); d != 4*reconnectMinDelay {
+		t.Errorf("fourth delay is %s, want %s", d, 4*reconnectMinDelay)
+	}
+	for _, n := range []int{10, 63, 1000} {
+		if d := reconnectDelay(n, 0.5); d < reconnectMaxDelay/2 || d > reconnectMaxDelay {
+			t.Errorf("delay %d is %s, past %s", n, d, reconnectMaxDelay)
+		}
+	}
+}
+
+func TestRetryText(t *testing.T) {
+	now := time.Now()
+	gtx := layout.Context{Now: now}
+	if s := retryText(gtx, now.Add(12400*time.Millisecond)); s != "retrying in 12s" {
+		t.Errorf("text is %q", s)
+	}
+	if s := retryText(gtx, now.Add(-time.Second)); s != "retrying in 0s" {
+		t.Errorf("text is %q when due", s)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	m := newFakeMessenger()
+	a := newTestApp(m)
+	m.AddBlob(reconnectBlob, []byte{1})
+	online := func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return m.online
+	}
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	disconnected := &client.ConnectionStatusEvent{Err: errors.New("connection reset")}
+
+	a.online()
+	waitFor(online)
+	a.handleCatshadowEvent(&client.ConnectionStatusEvent{IsConnected: true})
+	m.Offline()
+	a.handleCatshadowEvent(disconnected)
+	at, ok := a.state.RetryAt()
+	if !ok || a.reconnect == nil || a.reconnects != 1 {
+		t.Fatal("no reconnection was scheduled after disconnecting")
+	}
+	if d := time.Until(at); d > reconnectMinDelay {
+		t.Errorf("reconnecting in %s", d)
+	}
+
+	a.retry()
+	if _, ok := a.state.RetryAt(); ok || !a.state.Connecting() {
+		t.Error("not connecting when the reconnection is due")
+	}
+	// the session closed by reconnecting is reported after connecting
+	a.handleCatshadowEvent(disconnected)
+	if !a.state.Connecting() || a.reconnect != nil {
+		t.Error("closing the lost session ended reconnecting")
+	}
+	waitFor(online)
+	a.handleCatshadowEvent(&client.ConnectionStatusEvent{IsConnected: true})
+	if a.reconnects != 0 {
+		t.Error("attempts are counted after reconnecting")
+	}
+
+	// a failed connection attempt is retried with a longer delay
+	m.Lock()
+	m.online, m.onlineErr = false, errors.New("no route to host")
+	m.Unlock()
+	a.handleCatshadowEvent(disconnected)
+	a.retry()
+	a.handleCatshadowEvent(<-a.connectFailures)
+	if a.reconnect == nil || a.reconnects != 2 || a.state.Connecting() {
+		t.Fatal("failed attempt was not retried")
+	}
+	if events := a.state.ConnectionEvents(); events[0].err != m.onlineErr {
+		t.Errorf("failure recorded as %v", events[0].err)
+	}
+
+	// going offline stops reconnecting
+	a.navigate(OfflineClick{})
+	if _, ok := a.state.RetryAt(); ok || a.reconnect != nil || a.reconnects != 0 {
+		t.Error("reconnecting after going offline")
+	}
+	a.handleCatshadowEvent(disconnected)
+	if a.reconnect != nil {
+		t.Error("reconnection scheduled after going offline")
+	}
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	a := newTestApp(newFakeMessenger())
+	a.online()
+	a.handleCatshadowEvent(&client.ConnectionStatusEvent{})
+	if _, ok := a.state.RetryAt(); ok || a.reconnect != nil {
+		t.Error("reconnection scheduled without the setting")
+	}
+}
+
+func TestOfflineCancelsOnline(t *testing.T) {
+	m := newFakeMessenger()
+	m.connecting = make(chan struct{})
+	a := newTestApp(m)
+	a.online()
+	done := a.connectDone
+	a.navigate(OfflineClick{})
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("going offline did not cancel the attempt")
+	}
+	select {
+	case e := <-a.connectFailures:
+		t.Errorf("canceled attempt reported %v", e)
+	default:
+	}
+	close(m.connecting)
+	m.Lock()
+	defer m.Unlock()
+	if m.online {
+		t.Error("client went online after going offline")
+	}
+}
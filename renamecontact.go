@@ -65,6 +65,12 @@ func (p *RenameContactPage) Event(gtx layout.Context) interface{} {
 func (p *RenameContactPage) Start(stop <-chan struct{}) {
 }
 
+func init() {
+	handle(func(a *App, e RenameContact) interface{} {
+		return Push{newRenameContactPage(a, e.nickname)}
+	})
+}
+
 func newRenameContactPage(a *App, nickname string) *RenameContactPage {
 	p := &RenameContactPage{a: a, nickname: nickname}
 	p.newnickname = &widget.Editor{SingleLine: true, Submit: true}
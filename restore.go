@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"github.com/katzenpost/katzenpost/client/config"
+)
+
+var (
+	// errRestoreOverwrite is returned when restoring a backup would replace
+	// the statefile of an existing profile without confirmation
+	errRestoreOverwrite  = errors.New("a profile with this name already has a statefile")
+	errRestorePassphrase = errors.New("the sign in passphrase does not open the statefile of the backup")
+)
+
+// find returns the profile named name, or nil if there is none
+func (s *profileStore) find(name string) *profile {
+	profiles, _ := s.List()
+	for _, p := range profiles {
+		if strings.EqualFold(p.name, strings.TrimSpace(name)) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Restore writes the statefile and configuration of b to the profile named
+// name, creating it if needed. The statefile of an existing profile is only
+// replaced if overwrite is set, and only after the restored statefile has been
+// loaded with passphrase.
+func (s *profileStore) Restore(b *backupArchive, name string, passphrase []byte, overwrite bool) (*profile, error) {
+	if len(b.Statefile) < stateNonceSize {
+		return nil, fmt.Errorf("%w: the statefile is missing", errNotBackup)
+	}
+	// the default configurations are chosen by setupCatShadow, any other
+	// is kept with the profile
+	var cfg []byte
+	if len(b.Config) > 0 && !bytes.Equal(b.Config, cfgWithTor) && !bytes.Equal(b.Config, cfgWithoutTor) {
+		if _, err := config.Load(b.Config); err != nil {
+			return nil, fmt.Errorf("invalid client configuration: %w", err)
+		}
+		cfg = b.Config
+	}
+
+	p := s.find(name)
+	created := p == nil
+	if created {
+		var err error
+		if p, err = s.Create(name); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(p.statefile()); err == nil && !overwrite {
+		return nil, errRestoreOverwrite
+	}
+	fail := func(err error) (*profile, error) {
+		if created {
+			s.Delete(p)
+		}
+		return nil, err
+	}
+
+	tmp := p.statefile() + ".restore"
+	os.Remove(tmp)
+	if err := writeFileSync(tmp, b.Statefile); err != nil {
+		os.Remove(tmp)
+		return fail(err)
+	}
+	// the statefile in use is kept until the restored one loads
+	if _, _, err := catshadow.LoadStateWriter(nil, tmp, passphrase); err != nil {
+		os.Remove(tmp)
+		if errors.Is(err, catshadow.DecryptStateFailed) {
+			err = errRestorePassphrase
+		}
+		return fail(err)
+	}
+	if err := os.Rename(tmp, p.statefile()); err != nil {
+		os.Remove(tmp)
+		return fail(err)
+	}
+	// the backup kept by the StateWriter belongs to the replaced statefile
+	if err := os.Remove(p.statefile() + "~"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// and so does the duress passphrase, which would otherwise wipe the
+	// restored statefile
+	if _, err := os.Stat(duressFile(p.statefile())); err == nil {
+		if err := setDuressPassphrase(p.statefile(), nil); err != nil {
+			return nil, err
+		}
+	}
+	dst := filepath.Join(p.dir, profileConfigFile)
+	if cfg == nil {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if err := os.WriteFile(dst, cfg, 0600); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// writeFileSync writes data to a new file at path and flushes it to disk
+func writeFileSync(path string, data []byte) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// RestoreBackupPage opens a backup and restores it to a profile
+type RestoreBackupPage struct {
+	a          *App
+	back       *widget.Clickable
+	open       *widget.Clickable
+	restore    *widget.Clickable
+	path       *widget.Editor
+	passphrase *widget.Editor
+	name       *widget.Editor
+	// signin is the sign in passphrase of the backup, which is needed to
+	// check the statefile before it replaces one
+	signin *widget.Editor
+	// archive is the opened backup
+	archive *backupArchive
+	// confirmOverwrite is set after restoring was refused because the
+	// profile has a statefile, so that the next click replaces it
+	confirmOverwrite bool
+	// result receives the backup opened, or the profile restored, in the
+	// background as the passphrases are stretched
+	result chan restoreResult
+	busy   bool
+	errMsg string
+}
+
+// restoreResult is the result of opening or restoring a backup
+type restoreResult struct {
+	archive *backupArchive
+	profile *profile
+	err     error
+}
+
+// RestoreBackupClick is the event that opens the RestoreBackupPage
+type RestoreBackupClick struct{}
+
+// Layout returns the form opening the backup, and then the one choosing the
+// profile to restore it to
+func (p *RestoreBackupPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	caption := func(msg string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			return inset.Layout(gtx, material.Caption(th, msg).Layout)
+		})
+	}
+	submit := func(b *widget.Clickable, label string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			if p.busy {
+				gtx = gtx.Disabled()
+			}
+			return material.Button(th, b, label).Layout(gtx)
+		})
+	}
+	errMsg := p.errMsg
+	if p.busy && p.archive == nil {
+		errMsg = "Opening backup..."
+	} else if p.busy {
+		errMsg = "Restoring backup..."
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		children := []layout.FlexChild{
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Restore Backup").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+		}
+		if p.archive == nil {
+			children = append(children,
+				field(p.path, "Backup file"),
+				field(p.passphrase, "Backup passphrase"),
+				caption(errMsg),
+				submit(p.open, "Open"),
+			)
+		} else {
+			msg := fmt.Sprintf("Backup of %s made on %s", p.archive.Profile, p.archive.Created.Format("2006-01-02 15:04"))
+			label := "Restore"
+			if p.confirmOverwrite {
+				label = "Replace the statefile"
+			}
+			children = append(children,
+				caption(msg),
+				field(p.name, "Restore to profile"),
+				field(p.signin, "Sign in passphrase of the backup"),
+				caption(errMsg),
+				submit(p.restore, label),
+			)
+		}
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx, children...)
+	})
+}
+
+func (p *RestoreBackupPage) Event(gtx layout.Context) interface{} {
+	for _, ed := range []*widget.Editor{p.path, p.passphrase, p.name, p.signin} {
+		if e, ok := ed.Update(gtx); ok {
+			switch e.(type) {
+			case widget.ChangeEvent:
+				// a confirmation is for the profile it was asked for
+				if ed == p.name {
+					p.confirmOverwrite = false
+				}
+			case widget.SubmitEvent:
+				if p.archive == nil {
+					p.open.Click()
+				} else {
+					p.restore.Click()
+				}
+			}
+		}
+	}
+	if p.back.Clicked(gtx) && !p.busy {
+		return BackEvent{}
+	}
+	if p.open.Clicked(gtx) && !p.busy {
+		passphrase, err := editorSecret(p.passphrase)
+		p.passphrase.SetText("")
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		path := strings.TrimSpace(p.path.Text())
+		p.busy = true
+		go func() {
+			defer passphrase.destroy()
+			b, err := readBackup(path, passphrase.Bytes())
+			p.result <- restoreResult{archive: b, err: err}
+			p.a.w.Invalidate()
+		}()
+	}
+	if p.restore.Clicked(gtx) && !p.busy {
+		passphrase, err := editorSecret(p.signin)
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
+		b, name, overwrite := p.archive, p.name.Text(), p.confirmOverwrite
+		p.busy = true
+		go func() {
+			defer passphrase.destroy()
+			pr, err := p.a.profiles.Restore(b, name, passphrase.Bytes(), overwrite)
+			p.result <- restoreResult{profile: pr, err: err}
+			p.a.w.Invalidate()
+		}()
+	}
+
+	select {
+	case r := <-p.result:
+		p.busy = false
+		if p.archive == nil {
+			if r.err != nil {
+				p.errMsg = r.err.Error()
+				return nil
+			}
+			p.archive = r.archive
+			p.errMsg = ""
+			name := r.archive.Profile
+			if name == "" {
+				name = defaultProfileName
+			}
+			p.name.SetText(name)
+			return nil
+		}
+		if r.err == errRestoreOverwrite {
+			p.confirmOverwrite = true
+			p.errMsg = fmt.Sprintf("The contacts and messages of %s will be replaced", strings.TrimSpace(p.name.Text()))
+			return nil
+		}
+		p.signin.SetText("")
+		if r.err != nil {
+			p.errMsg = r.err.Error()
+			return nil
+		}
+		// signing in loads the restored statefile
+		return ProfileSelected{profile: r.profile}
+	default:
+	}
+	return nil
+}
+
+func (p *RestoreBackupPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, _ RestoreBackupClick) interface{} {
+		if a.profiles == nil {
+			return nil
+		}
+		return Push{newRestoreBackupPage(a)}
+	})
+}
+
+func newRestoreBackupPage(a *App) *RestoreBackupPage {
+	return &RestoreBackupPage{
+		a:          a,
+		back:       &widget.Clickable{},
+		open:       &widget.Clickable{},
+		restore:    &widget.Clickable{},
+		path:       &widget.Editor{SingleLine: true, Submit: true},
+		passphrase: &widget.Editor{SingleLine: true, Mask: '*', Submit: true},
+		signin:     &widget.Editor{SingleLine: true, Mask: '*', Submit: true},
+		name:       &widget.Editor{SingleLine: true, Submit: true},
+		result:     make(chan restoreResult, 1),
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Navigation intents are returned from Page.Event, or from a route, and are
+// applied to the page stack by App.navigate.
+type (
+	// Push opens Page on top of the current page
+	Push struct {
+		Page Page
+	}
+
+	// Replace swaps the current page for Page
+	Replace struct {
+		Page Page
+	}
+
+	// Reset discards every page on the stack and shows Page
+	Reset struct {
+		Page Page
+	}
+
+	// PopTo closes pages until Match reports true for the page on top of
+	// the stack. The stack is unchanged if no page matches.
+	PopTo struct {
+		Match func(Page) bool
+	}
+
+	// Return closes the current page and, if Result is not nil, delivers it
+	// to the page that opened it.
+	Return struct {
+		Result interface{}
+	}
+)
+
+// ResultReceiver is implemented by pages that open another page to obtain a
+// value, such as EditContactPage choosing an avatar with the AvatarPicker.
+type ResultReceiver interface {
+	Result(v interface{})
+}
+
+// popTo returns a PopTo intent for the nearest page of type T
+func popTo[T Page]() PopTo {
+	return PopTo{Match: func(p Page) bool {
+		_, ok := p.(T)
+		return ok
+	}}
+}
+
+// route translates an event emitted by a page into a navigation intent, or
+// nil if the event was fully handled
+type route func(a *App, e interface{}) interface{}
+
+var routes = make(map[reflect.Type]route)
+
+// handle registers h as the route for events of type E. Pages register the
+// events that open them from init, so that adding a screen does not require
+// changes to App.
+func handle[E any](h func(a *App, e E) interface{}) {
+	t := reflect.TypeFor[E]()
+	if _, ok := routes[t]; ok {
+		panic(fmt.Sprintf("duplicate route for %v", t))
+	}
+	routes[t] = func(a *App, e interface{}) interface{} {
+		return h(a, e.(E))
+	}
+}
+
+// navigate applies e to the page stack, following routes until an intent
+// is reached. Events without a route are ignored.
+func (a *App) navigate(e interface{}) {
+	for e != nil {
+		switch i := e.(type) {
+		case Push:
+			a.stack.Push(i.Page)
+		case Replace:
+			a.stack.Replace(i.Page)
+		case Reset:
+			a.stack.Clear(i.Page)
+		case PopTo:
+			a.stack.PopTo(i.Match)
+		case Return:
+			if a.stack.Len() < 2 {
+				return
+			}
+			a.stack.Pop()
+			if r, ok := a.stack.Current().(ResultReceiver); ok && i.Result != nil {
+				r.Result(i.Result)
+			}
+		default:
+			r, ok := routes[reflect.TypeOf(e)]
+			if !ok {
+				return
+			}
+			e = r(a, e)
+			continue
+		}
+		return
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// stubPage records whether it is running and the results it receives
+type stubPage struct {
+	name    string
+	running bool
+	results []interface{}
+}
+
+type stubEvent struct {
+	name string
+}
+
+func (p *stubPage) Start(stop <-chan struct{}) {
+	p.running = true
+	go func() {
+		<-stop
+	}()
+}
+
+func (p *stubPage) Event(gtx C) interface{} {
+	return nil
+}
+
+func (p *stubPage) Layout(gtx C) D {
+	return D{}
+}
+
+func (p *stubPage) Result(v interface{}) {
+	p.results = append(p.results, v)
+}
+
+func stackNames(a *App) []string {
+	names := make([]string, 0, a.stack.Len())
+	for _, p := range a.stack.pages {
+		names = append(names, p.(*stubPage).name)
+	}
+	return names
+}
+
+func checkStack(t *testing.T, a *App, want ...string) {
+	t.Helper()
+	got := stackNames(a)
+	if len(got) != len(want) {
+		t.Fatalf("stack is %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("stack is %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNavigateIntents(t *testing.T) {
+	a := newTestApp(newFakeMessenger())
+	a.navigate(Push{&stubPage{name: "home"}})
+	a.navigate(Push{&stubPage{name: "conversation"}})
+	a.navigate(Push{&stubPage{name: "edit"}})
+	checkStack(t, a, "home", "conversation", "edit")
+
+	a.navigate(Replace{&stubPage{name: "rename"}})
+	checkStack(t, a, "home", "conversation", "rename")
+
+	a.navigate(PopTo{Match: func(p Page) bool { return p.(*stubPage).name == "nowhere" }})
+	checkStack(t, a, "home", "conversation", "rename")
+
+	a.navigate(PopTo{Match: func(p Page) bool { return p.(*stubPage).name == "home" }})
+	checkStack(t, a, "home")
+
+	// the last page is never closed
+	a.navigate(Return{})
+	checkStack(t, a, "home")
+
+	a.navigate(Reset{&stubPage{name: "signin"}})
+	checkStack(t, a, "signin")
+}
+
+func TestNavigateReturnResult(t *testing.T) {
+	a := newTestApp(newFakeMessenger())
+	caller := &stubPage{name: "caller"}
+	a.navigate(Push{caller})
+	a.navigate(Push{&stubPage{name: "picker"}})
+	a.navigate(Return{Result: "chosen"})
+	checkStack(t, a, "caller")
+	if len(caller.results) != 1 || caller.results[0] != "chosen" {
+		t.Errorf("caller received %v, want [chosen]", caller.results)
+	}
+
+	a.navigate(Push{&stubPage{name: "picker"}})
+	a.navigate(Return{})
+	if len(caller.results) != 1 {
+		t.Errorf("caller received %v after returning without a result", caller.results)
+	}
+}
+
+func TestNavigateRoutes(t *testing.T) {
+	handle(func(a *App, e stubEvent) interface{} {
+		return Push{&stubPage{name: e.name}}
+	})
+	defer delete(routes, reflect.TypeFor[stubEvent]())
+
+	a := newTestApp(newFakeMessenger())
+	a.navigate(Push{&stubPage{name: "home"}})
+	a.navigate(stubEvent{name: "routed"})
+	checkStack(t, a, "home", "routed")
+
+	// events without a route are ignored
+	a.navigate(struct{}{})
+	checkStack(t, a, "home", "routed")
+}
+
+func TestAvatarPickerReturnsToEditContact(t *testing.T) {
+	f := newFakeMessenger()
+	f.NewContact("alice", []byte("secret"))
+	a := newTestApp(f)
+	a.navigate(Push{newHomePage(a)})
+	a.navigate(EditContact{nickname: "alice"})
+	a.navigate(ChooseAvatar{nickname: "alice"})
+	if _, ok := a.stack.Current().(*AvatarPicker); !ok {
+		t.Fatalf("current page is %T, want *AvatarPicker", a.stack.Current())
+	}
+	a.navigate(ChooseAvatarPath{nickname: "alice", path: "testdata"})
+	if a.stack.Len() != 3 {
+		t.Fatalf("changing directory left %d pages on the stack, want 3", a.stack.Len())
+	}
+
+	a.navigate(Return{Result: AvatarSelected{path: "testdata/home.png"}})
+	if _, ok := a.stack.Current().(*EditContactPage); !ok {
+		t.Fatalf("current page is %T, want *EditContactPage", a.stack.Current())
+	}
+	if _, err := f.GetBlob("avatar://alice"); err != nil {
+		t.Errorf("avatar was not saved: %v", err)
+	}
+
+	a.navigate(EditContactComplete{nickname: "alice"})
+	if _, ok := a.stack.Current().(*HomePage); !ok {
+		t.Fatalf("current page is %T, want *HomePage", a.stack.Current())
+	}
+}
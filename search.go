@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gioui.org/font"
+	"gioui.org/gesture"
+	"gioui.org/io/key"
+	"gioui.org/layout"
+	"gioui.org/op/clip"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"gioui.org/x/styledtext"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"golang.org/x/exp/shiny/materialdesign/icons"
+)
+
+var (
+	searchIcon, _ = widget.NewIcon(icons.ActionSearch)
+
+	// highlightColor is the color of the matched text in search results
+	highlightColor = rgb(0xffcc00)
+)
+
+const (
+	// maxSearchHits limits the number of results shown
+	maxSearchHits = 200
+
+	// snippetContext is the number of bytes shown around a match
+	snippetContext = 40
+)
+
+// searchEntry is the indexed text of a message
+type searchEntry struct {
+	nickname string
+	// msg is the stored message, or the first fragment of a reassembled
+	// message, which stays the same between reassemblies
+	msg  *catshadow.Message
+	when time.Time
+	text string
+}
+
+// searchHit is a message matching a query
+type searchHit struct {
+	nickname string
+	msg      *catshadow.Message
+	when     time.Time
+	// snippet is the text around the first match
+	snippet string
+}
+
+// searchIndex holds the text of the messages in each conversation in
+// memory, so that searching does not decode every message
+type searchIndex struct {
+	sync.Mutex
+
+	entries map[string][]*searchEntry
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{entries: make(map[string][]*searchEntry)}
+}
+
+// Has returns true if the conversation with nickname was indexed
+func (x *searchIndex) Has(nickname string) bool {
+	x.Lock()
+	defer x.Unlock()
+	_, ok := x.entries[nickname]
+	return ok
+}
+
+// Update replaces the entries of the conversation with nickname with the
+// reassembled messages, reusing the entries of messages already indexed
+func (x *searchIndex) Update(nickname string, messages catshadow.Messages, parts func(*catshadow.Message) catshadow.Messages) {
+	x.Lock()
+	old := make(map[*catshadow.Message]*searchEntry, len(x.entries[nickname]))
+	for _, e := range x.entries[nickname] {
+		old[e.msg] = e
+	}
+	x.Unlock()
+
+	entries := make([]*searchEntry, 0, len(messages))
+	for _, msg := range messages {
+		if len(msg.Plaintext) == 0 {
+			continue
+		}
+		first := parts(msg)[0]
+		if e, ok := old[first]; ok {
+			entries = append(entries, e)
+			continue
+		}
+		e := &searchEntry{nickname: nickname, msg: first, when: msg.Timestamp}
+		if env, err := decodeEnvelope(msg.Plaintext); err == nil && !env.IsDeleteRequest() {
+			e.text = messageText(msg.Plaintext)
+		}
+		entries = append(entries, e)
+	}
+
+	x.Lock()
+	defer x.Unlock()
+	x.entries[nickname] = entries
+}
+
+// Retain removes the conversations of contacts not in nicknames
+func (x *searchIndex) Retain(nicknames map[string]bool) {
+	x.Lock()
+	defer x.Unlock()
+	for nickname := range x.entries {
+		if !nicknames[nickname] {
+			delete(x.entries, nickname)
+		}
+	}
+}
+
+// Search returns the messages containing every word of query, ignoring
+// case, newest first
+func (x *searchIndex) Search(query string) []searchHit {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	x.Lock()
+	defer x.Unlock()
+	var hits []searchHit
+	for _, entries := range x.entries {
+	entry:
+		for _, e := range entries {
+			start := -1
+			for _, term := range terms {
+				i := indexFold(e.text, term)
+				if i < 0 {
+					continue entry
+				}
+				if start < 0 {
+					start = i
+				}
+			}
+			hit := searchHit{nickname: e.nickname, msg: e.msg, when: e.when}
+			hit.snippet = snippet(e.text, start, start+len(terms[0]))
+			hits = append(hits, hit)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].when.After(hits[j].when)
+	})
+	if len(hits) > maxSearchHits {
+		hits = hits[:maxSearchHits]
+	}
+	return hits
+}
+
+// indexFold returns the index of the first instance of substr in s ignoring
+// case, or -1
+func indexFold(s, substr string) int {
+	for i := range s {
+		if j := i + len(substr); j <= len(s) && strings.EqualFold(s[i:j], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// snippet returns the text around text[start:end] on a single line
+func snippet(text string, start, end int) string {
+	from, to := start-snippetContext, end+snippetContext
+	prefix, suffix := "…", "…"
+	if from <= 0 {
+		from, prefix = 0, ""
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	if to >= len(text) {
+		to, suffix = len(text), ""
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+	s := prefix + strings.Join(strings.Fields(text[from:start]), " ")
+	if from < start && isSpace(text[start-1]) {
+		s += " "
+	}
+	s += text[start:end]
+	if end < to && isSpace(text[end]) {
+		s += " "
+	}
+	return s + strings.Join(strings.Fields(text[end:to]), " ") + suffix
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\t' || b == '\r'
+}
+
+// indexConversation updates the search index with the messages in the
+// conversation with nickname
+func (a *App) indexConversation(nickname string) {
+	r := newReassembler(a.payloadLen())
+	messages := r.Messages(a.visibleMessages(nickname, a.c.GetSortedConversation(nickname)))
+	a.index.Update(nickname, messages, r.Parts)
+}
+
+// indexAll indexes the conversations that are not in the search index yet,
+// and drops those of removed contacts
+func (a *App) indexAll() {
+	nicknames := make(map[string]bool)
+	for nickname := range a.c.GetContacts() {
+		nicknames[nickname] = true
+		if !a.index.Has(nickname) {
+			a.indexConversation(nickname)
+		}
+	}
+	a.index.Retain(nicknames)
+}
+
+// matchRanges returns the ranges of text matching any of the words of
+// query, in order and without overlaps
+func matchRanges(text, query string) [][2]int {
+	var ranges [][2]int
+	for _, term := range strings.Fields(query) {
+		for off := 0; off < len(text); {
+			i := indexFold(text[off:], term)
+			if i < 0 {
+				break
+			}
+			ranges = append(ranges, [2]int{off + i, off + i + len(term)})
+			off += i + len(term)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	out := ranges[:0]
+	for _, r := range ranges {
+		if n := len(out); n > 0 && r[0] < out[n-1][1] {
+			if r[1] > out[n-1][1] {
+				out[n-1][1] = r[1]
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// layoutHighlight lays out text with the ranges highlighted
+func layoutHighlight(gtx C, text string, ranges [][2]int) D {
+	span := func(s string) styledtext.SpanStyle {
+		return styledtext.SpanStyle{Content: s, Size: th.TextSize, Color: th.Fg, Font: font.Font{Typeface: th.Face}}
+	}
+	spans := make([]styledtext.SpanStyle, 0, 2*len(ranges)+1)
+	off := 0
+	for _, r := range ranges {
+		match := span(text[r[0]:r[1]])
+		match.Color, match.Font.Weight = highlightColor, font.Bold
+		spans = append(spans, span(text[off:r[0]]), match)
+		off = r[1]
+	}
+	spans = append(spans, span(text[off:]))
+	return styledtext.Text(th.Shaper, spans...).Layout(gtx, nil)
+}
+
+// SearchPage searches the messages in every conversation
+type SearchPage struct {
+	a      *App
+	back   *widget.Clickable
+	query  *widget.Editor
+	list   *layout.List
+	hits   []searchHit
+	clicks []gesture.Click
+}
+
+// ShowSearchClick is the event that opens the SearchPage
+type ShowSearchClick struct{}
+
+// SearchResultClick is the event that opens the conversation of a search
+// result with the match highlighted
+type SearchResultClick struct {
+	nickname string
+	msg      *catshadow.Message
+	query    string
+}
+
+func (p *SearchPage) search() {
+	p.hits = p.a.index.Search(p.query.Text())
+	p.clicks = make([]gesture.Click, len(p.hits))
+	p.list.Position = layout.Position{}
+}
+
+// Layout shows the query editor and the matching messages
+func (p *SearchPage) Layout(gtx layout.Context) layout.Dimensions {
+	gtx.Execute(key.FocusCmd{Tag: p.query})
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Search").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Rigid(func(gtx C) D {
+				bgQuery := Background{
+					Color:  th.ContrastBg,
+					Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+					Radius: unit.Dp(10),
+				}
+				return inset.Layout(gtx, func(gtx C) D {
+					return bgQuery.Layout(gtx, material.Editor(th, p.query, "Search messages").Layout)
+				})
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				if len(p.hits) == 0 {
+					if strings.TrimSpace(p.query.Text()) == "" {
+						return fill{th.Bg}.Layout(gtx)
+					}
+					return inset.Layout(gtx, material.Body2(th, "No messages found").Layout)
+				}
+				gtx.Constraints.Min.X = gtx.Constraints.Max.X
+				return p.list.Layout(gtx, len(p.hits), func(gtx C, i int) D {
+					dims := p.layoutHit(gtx, p.hits[i])
+					a := clip.Rect(image.Rectangle{Max: dims.Size})
+					t := a.Push(gtx.Ops)
+					p.clicks[i].Add(gtx.Ops)
+					t.Pop()
+					return dims
+				})
+			}),
+		)
+	})
+}
+
+func (p *SearchPage) layoutHit(gtx C, hit searchHit) D {
+	in := layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)}
+	return in.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(func(gtx C) D {
+				return p.a.layoutAvatar(gtx, hit.nickname)
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				in := layout.Inset{Left: unit.Dp(12)}
+				return in.Layout(gtx, func(gtx C) D {
+					return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
+						layout.Rigid(func(gtx C) D {
+							when := hit.when.Format(time.RFC822)
+							direction := "from"
+							if hit.msg.Outbound {
+								direction = "to"
+							}
+							return material.Caption(th, fmt.Sprintf("%s %s, %s", direction, hit.nickname, when)).Layout(gtx)
+						}),
+						layout.Rigid(func(gtx C) D {
+							return layoutHighlight(gtx, hit.snippet, matchRanges(hit.snippet, p.query.Text()))
+						}),
+					)
+				})
+			}),
+		)
+	})
+}
+
+func (p *SearchPage) Event(gtx layout.Context) interface{} {
+	for {
+		e, ok := p.query.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := e.(widget.ChangeEvent); ok {
+			p.search()
+		}
+	}
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	for i := range p.clicks {
+		if e, ok := p.clicks[i].Update(gtx.Source); ok && e.Kind == gesture.KindClick {
+			hit := p.hits[i]
+			return SearchResultClick{nickname: hit.nickname, msg: hit.msg, query: p.query.Text()}
+		}
+	}
+	return nil
+}
+
+func (p *SearchPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, e ShowSearchClick) interface{} {
+		return Push{newSearchPage(a)}
+	})
+	handle(func(a *App, e SearchResultClick) interface{} {
+		c := newConversationPage(a, e.nickname)
+		c.highlight, c.query = e.msg, e.query
+		return Push{c}
+	})
+}
+
+func newSearchPage(a *App) *SearchPage {
+	a.indexAll()
+	return &SearchPage{
+		a:     a,
+		back:  &widget.Clickable{},
+		query: &widget.Editor{SingleLine: true},
+		list:  &layout.List{Axis: layout.Vertical},
+	}
+}
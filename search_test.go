@@ -0,0 +1,143 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"gioui.org/io/key"
+)
+
+func TestSearchIndex(t *testing.T) {
+	f := newPopulatedMessenger()
+	discardEvents(f)
+	a := newTestApp(f)
+	a.indexAll()
+
+	hits := a.index.Search("LOUD clear")
+	if len(hits) != 1 || hits[0].nickname != "alice" || !hits[0].msg.Outbound {
+		t.Fatalf("got %+v, want the message sent to alice", hits)
+	}
+	if hits := a.index.Search("loud meow"); len(hits) != 0 {
+		t.Errorf("got %d hits for words in different messages, want none", len(hits))
+	}
+	if hits := a.index.Search("  "); hits != nil {
+		t.Error("empty query returned hits")
+	}
+
+	// hits are sorted newest first
+	hits = a.index.Search("m")
+	for i := 1; i < len(hits); i++ {
+		if hits[i].when.After(hits[i-1].when) {
+			t.Errorf("hit %d is newer than hit %d", i, i-1)
+		}
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.payloadLen = 128
+	discardEvents(f)
+	a := newTestApp(f)
+	a.stack.Push(&stubPage{name: "home"})
+	a.indexAll()
+
+	long := strings.Repeat("purring ", 30) + "marmalade"
+	receiveEnvelope(t, f, "bob", newTextEnvelope(long), time.Now())
+	drainEvents(a, f)
+	hits := a.index.Search("marmalade")
+	if len(hits) != 1 {
+		t.Fatalf("got %d hits for a received message, want 1", len(hits))
+	}
+	if !strings.HasPrefix(hits[0].snippet, "…") || !strings.HasSuffix(hits[0].snippet, "marmalade") {
+		t.Errorf("snippet %q is not the end of the message", hits[0].snippet)
+	}
+
+	if _, err := a.sendEnvelope("carol", newTextEnvelope("marmalade sandwich")); err != nil {
+		t.Fatal(err)
+	}
+	drainEvents(a, f)
+	if hits := a.index.Search("marmalade"); len(hits) != 2 || hits[0].nickname != "carol" {
+		t.Errorf("sent message is not the newest hit in %+v", hits)
+	}
+
+	if err := a.deleteMessages("bob", newReassembler(a.payloadLen()).Parts(hits[0].msg)[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	for _, hit := range a.index.Search("marmalade") {
+		if hit.nickname == "bob" {
+			t.Error("deleted message is still found")
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := "the quick brown fox\njumps over the lazy dog"
+	i := strings.Index(text, "jumps")
+	if got := snippet(text, i, i+len("jumps")); got != text[:19]+" "+text[20:] {
+		t.Errorf("snippet = %q", got)
+	}
+	long := strings.Repeat("a", 100) + "needle" + strings.Repeat("b", 100)
+	got := snippet(long, 100, 106)
+	if got != "…"+strings.Repeat("a", snippetContext)+"needle"+strings.Repeat("b", snippetContext)+"…" {
+		t.Errorf("snippet = %q", got)
+	}
+}
+
+func TestMatchRanges(t *testing.T) {
+	got := matchRanges("Meow meow, said the cat", "MEOW cat eow")
+	want := [][2]int{{0, 4}, {5, 9}, {20, 23}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	f := newPopulatedMessenger()
+	for i := 0; i < 20; i++ {
+		f.receive("alice", []byte("filler"), time.Now().Add(-time.Duration(100-i)*time.Hour))
+	}
+	h := newPageHarness(t, f, func(a *App) Page {
+		p := newHomePage(a)
+		p.UpdateContacts()
+		return p
+	})
+	h.frames(2)
+	h.queue(
+		key.Event{Name: "F", Modifiers: key.ModShortcut, State: key.Press},
+		key.Event{Name: "F", Modifiers: key.ModShortcut, State: key.Release},
+	)
+	p, ok := h.current().(*SearchPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *SearchPage", h.current())
+	}
+	h.typeText("mixnet")
+	if len(p.hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(p.hits))
+	}
+	// use a fixed timestamp so that the screenshot is stable
+	p.hits[0].when = time.Date(2024, time.September, 1, 12, 0, 0, 0, time.UTC)
+	h.screenshot("search")
+
+	// clicking the hit opens the conversation scrolled to the message
+	h.click(image.Pt(200, 110))
+	c, ok := h.current().(*conversationPage)
+	if !ok {
+		t.Fatalf("current page is %T, want *conversationPage", h.current())
+	}
+	h.frames(2)
+	if c.nickname != "alice" || c.highlight != p.hits[0].msg || c.query != "mixnet" {
+		t.Errorf("conversation opened for %s with %q highlighted", c.nickname, c.query)
+	}
+	// the list stops scrolling when its end is in view
+	pos := c.messageList.Position
+	if c.messageList.ScrollToEnd || pos.First > 20 || pos.First+pos.Count <= 20 {
+		t.Errorf("conversation shows messages %d to %d, want the message at 20", pos.First, pos.First+pos.Count-1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+
+	"gioui.org/widget"
+)
+
+// secureBuffer holds a secret, such as the passphrase, in memory that is
+// wiped once it is no longer needed. On Linux the memory is also locked, so
+// that it is never swapped, and surrounded by guard pages.
+type secureBuffer struct {
+	data []byte
+	// mem is the whole allocation, including any guard pages
+	mem []byte
+	// locked is set if mem could be locked into memory
+	locked bool
+}
+
+// newSecureBuffer copies secret into a new secureBuffer, and wipes secret
+func newSecureBuffer(secret []byte) (*secureBuffer, error) {
+	b, err := allocSecureBuffer(len(secret))
+	if err != nil {
+		return nil, err
+	}
+	copy(b.data, secret)
+	clear(secret)
+	return b, nil
+}
+
+// Bytes returns the secret, which is only valid until the buffer is wiped
+func (b *secureBuffer) Bytes() []byte {
+	return b.data
+}
+
+// clone copies the secret into a new secureBuffer
+func (b *secureBuffer) clone() (*secureBuffer, error) {
+	c, err := allocSecureBuffer(len(b.data))
+	if err != nil {
+		return nil, err
+	}
+	copy(c.data, b.data)
+	return c, nil
+}
+
+// wipe zeroes the secret
+func (b *secureBuffer) wipe() {
+	clear(b.data)
+}
+
+// destroy wipes the secret and releases the buffer, if there is one
+func (b *secureBuffer) destroy() {
+	if b == nil || b.mem == nil {
+		return
+	}
+	b.wipe()
+	b.free()
+	b.data, b.mem = nil, nil
+}
+
+// editorSecret copies the text of ed into a new secureBuffer. The text is
+// read from the editor, as the string returned by ed.Text() could not be
+// wiped.
+func editorSecret(ed *widget.Editor) (*secureBuffer, error) {
+	n, err := ed.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	b, err := allocSecureBuffer(int(n))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ed.Seek(0, io.SeekStart); err != nil {
+		b.destroy()
+		return nil, err
+	}
+	if _, err := io.ReadFull(ed, b.data); err != nil {
+		b.destroy()
+		return nil, err
+	}
+	return b, nil
+}
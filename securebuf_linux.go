@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocSecureBuffer maps a buffer for n bytes between two inaccessible guard
+// pages, and locks it out of swap and core dumps. A buffer that cannot be
+// locked, as when RLIMIT_MEMLOCK is reached, is still guarded and wiped.
+func allocSecureBuffer(n int) (*secureBuffer, error) {
+	page := os.Getpagesize()
+	size := max((n+page-1)/page*page, page)
+	mem, err := unix.Mmap(-1, 0, size+2*page, unix.PROT_NONE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	inner := mem[page : page+size]
+	if err := unix.Mprotect(inner, unix.PROT_READ|unix.PROT_WRITE); err != nil {
+		unix.Munmap(mem)
+		return nil, err
+	}
+	unix.Madvise(inner, unix.MADV_DONTDUMP)
+	b := &secureBuffer{
+		// the secret ends at the last guard page, so that reading or
+		// writing past it faults
+		data:   inner[size-n:],
+		mem:    mem,
+		locked: unix.Mlock(inner) == nil,
+	}
+	return b, nil
+}
+
+// free unmaps the buffer, which also unlocks it
+func (b *secureBuffer) free() {
+	unix.Munmap(b.mem)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// allocSecureBuffer allocates a buffer for n bytes on the heap, where it is
+// only wiped
+func allocSecureBuffer(n int) (*secureBuffer, error) {
+	mem := make([]byte, n)
+	return &secureBuffer{data: mem, mem: mem}, nil
+}
+
+func (b *secureBuffer) free() {
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"gioui.org/widget"
+)
+
+// testSecret returns s in a secureBuffer destroyed at the end of the test
+func testSecret(t *testing.T, s string) *secureBuffer {
+	t.Helper()
+	b, err := newSecureBuffer([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.destroy)
+	return b
+}
+
+// wiped returns true if b is all zeroes
+func wiped(b []byte) bool {
+	return bytes.Count(b, []byte{0}) == len(b)
+}
+
+func TestSecureBuffer(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	b, err := newSecureBuffer(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b.Bytes()) != "correct horse battery staple" {
+		t.Errorf("buffer holds %q", b.Bytes())
+	}
+	if !wiped(secret) {
+		t.Error("the copied secret was not wiped")
+	}
+	if runtime.GOOS == "linux" {
+		page := os.Getpagesize()
+		if len(b.mem) != 3*page {
+			t.Errorf("%d bytes mapped, want %d", len(b.mem), 3*page)
+		}
+		// the secret ends where the trailing guard page starts
+		if &b.data[len(b.data)-1] != &b.mem[len(b.mem)-page-1] {
+			t.Error("the secret is not next to the guard page")
+		}
+		if !b.locked {
+			t.Log("buffer could not be locked, RLIMIT_MEMLOCK is too low")
+		}
+	}
+
+	data := b.Bytes()
+	b.wipe()
+	if !wiped(data) {
+		t.Errorf("wiped buffer holds %q", data)
+	}
+	b.destroy()
+	if b.Bytes() != nil {
+		t.Error("destroyed buffer still holds a secret")
+	}
+	b.destroy()
+
+	empty, err := newSecureBuffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty.Bytes()) != 0 {
+		t.Error("empty secret is not empty")
+	}
+	empty.destroy()
+}
+
+func TestSetupCatShadowWipesPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	statefile := filepath.Join(dir, "statefile")
+	writeTestState(t, statefile, []byte("hunter2"))
+
+	wrong := testSecret(t, "hunter3")
+	runUnlock(func(ctx context.Context, result chan interface{}) {
+		setupCatShadow(ctx, statefile, "", wrong, true, result)
+	})
+	if !wiped(wrong.Bytes()) {
+		t.Errorf("passphrase %q left after a failed unlock", wrong.Bytes())
+	}
+
+	// a statefile with issues is reported without starting the client
+	broken := filepath.Join(dir, "broken")
+	if err := saveState(broken, newBrokenState(t), []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	pass := testSecret(t, "hunter2")
+	_, r := runUnlock(func(ctx context.Context, result chan interface{}) {
+		setupCatShadow(ctx, broken, "", pass, true, result)
+	})
+	report, ok := r.(*stateReport)
+	if !ok {
+		t.Fatalf("result is %v, want a *stateReport", r)
+	}
+	if !wiped(pass.Bytes()) {
+		t.Errorf("passphrase %q left after unlocking", pass.Bytes())
+	}
+	// the copy kept to save the repairs is destroyed with the report
+	if string(report.passphrase.Bytes()) != "hunter2" {
+		t.Errorf("report kept %q, want the passphrase", report.passphrase.Bytes())
+	}
+	report.discard()
+	if report.passphrase.Bytes() != nil {
+		t.Error("discarded report still holds the passphrase")
+	}
+}
+
+func TestEditorSecret(t *testing.T) {
+	ed := &widget.Editor{SingleLine: true, Mask: '*'}
+	ed.SetText("pässwörd")
+	// reading the text leaves the editor at its end
+	ed.Seek(0, io.SeekEnd)
+	b, err := editorSecret(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.destroy()
+	if string(b.Bytes()) != "pässwörd" {
+		t.Errorf("read %q from the editor", b.Bytes())
+	}
+
+	ed.SetText("")
+	empty, err := editorSecret(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.destroy()
+	if len(empty.Bytes()) != 0 {
+		t.Errorf("read %q from an empty editor", empty.Bytes())
+	}
+}
@@ -17,6 +17,13 @@ type SettingsPage struct {
 	submit            *widget.Clickable
 	switchUseTor      *widget.Bool
 	switchAutoConnect *widget.Bool
+	switchReconnect   *widget.Bool
+	changePassphrase  *widget.Clickable
+	exportBackup      *widget.Clickable
+	duress            *widget.Clickable
+	lockTimeout       *widget.Clickable
+	networks          *widget.Clickable
+	proxy             *widget.Clickable
 }
 
 var (
@@ -55,6 +62,20 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 					}),
 				)
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Proxy").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						label := p.a.proxySettings().Address
+						if label == "" {
+							label = "Automatic"
+						}
+						return inset.Layout(gtx, material.Button(th, p.proxy, label).Layout)
+					}),
+				)
+			}),
 			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
 					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
@@ -65,6 +86,70 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 					}),
 				)
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Reconnect Automatically").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Switch(th, p.switchReconnect, "Reconnect Automatically").Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Network").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						label := p.a.selectedNetwork()
+						if label == "" {
+							label = "Default"
+						}
+						return inset.Layout(gtx, material.Button(th, p.networks, label).Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Lock When Idle").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.lockTimeout, formatLockTimeout(p.a.lockTimeout())).Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Passphrase").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.changePassphrase, "Change").Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Duress Passphrase").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.duress, "Set").Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Body1(th, "Backup").Layout)
+					}),
+					layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.exportBackup, "Export").Layout)
+					}),
+				)
+			}),
 			layout.Rigid(func(gtx C) D {
 				return material.Button(th, p.submit, "Apply Settings").Layout(gtx)
 			}),
@@ -80,7 +165,7 @@ func (p *SettingsPage) Event(gtx layout.Context) interface{} {
 		return BackEvent{}
 	}
 	if p.switchUseTor.Update(gtx) {
-		if p.switchUseTor.Value && !hasTor() {
+		if p.switchUseTor.Value && !p.a.hasProxy() {
 			p.switchUseTor.Value = false
 			p.a.c.DeleteBlob("UseTor")
 			warnNoTor()
@@ -99,6 +184,39 @@ func (p *SettingsPage) Event(gtx layout.Context) interface{} {
 			p.a.c.DeleteBlob("AutoConnect")
 		}
 	}
+	if p.switchReconnect.Update(gtx) {
+		if p.switchReconnect.Value {
+			p.a.c.AddBlob(reconnectBlob, []byte{1})
+		} else {
+			p.a.c.DeleteBlob(reconnectBlob)
+			p.a.cancelReconnect()
+		}
+	}
+	if p.changePassphrase.Clicked(gtx) {
+		return ChangePassphraseClick{}
+	}
+	if p.exportBackup.Clicked(gtx) {
+		return ExportBackupClick{}
+	}
+	if p.duress.Clicked(gtx) {
+		return DuressClick{}
+	}
+	if p.networks.Clicked(gtx) {
+		return ShowNetworksClick{}
+	}
+	if p.proxy.Clicked(gtx) {
+		return ShowProxyClick{}
+	}
+	if p.lockTimeout.Clicked(gtx) {
+		// cycle through the timeouts
+		next := lockTimeouts[0]
+		for i, d := range lockTimeouts {
+			if d == p.a.lockTimeout() && i+1 < len(lockTimeouts) {
+				next = lockTimeouts[i+1]
+			}
+		}
+		p.a.setLockTimeout(next)
+	}
 	if p.submit.Clicked(gtx) {
 		go func() {
 			if n, err := notify.Push("Restarting", "Katzen is restarting"); err == nil {
@@ -115,10 +233,22 @@ func (p *SettingsPage) Event(gtx layout.Context) interface{} {
 func (p *SettingsPage) Start(stop <-chan struct{}) {
 }
 
+func init() {
+	handle(func(a *App, _ ShowSettingsClick) interface{} {
+		return Push{newSettingsPage(a)}
+	})
+}
+
 func newSettingsPage(a *App) *SettingsPage {
 	p := &SettingsPage{a: a}
 	p.back = &widget.Clickable{}
 	p.submit = &widget.Clickable{}
+	p.changePassphrase = &widget.Clickable{}
+	p.exportBackup = &widget.Clickable{}
+	p.duress = &widget.Clickable{}
+	p.lockTimeout = &widget.Clickable{}
+	p.networks = &widget.Clickable{}
+	p.proxy = &widget.Clickable{}
 	if _, err := a.c.GetBlob("UseTor"); err == nil {
 		p.switchUseTor = &widget.Bool{Value: true}
 	} else {
@@ -129,12 +259,14 @@ func newSettingsPage(a *App) *SettingsPage {
 	} else {
 		p.switchAutoConnect = &widget.Bool{Value: false}
 	}
+	_, err := a.c.GetBlob(reconnectBlob)
+	p.switchReconnect = &widget.Bool{Value: err == nil}
 	return p
 }
 
 func warnNoTor() {
 	go func() {
-		if n, err := notify.Push("Failure", "Tor requested, but not available on port 9050. Disable in settings to connect."); err == nil {
+		if n, err := notify.Push("Failure", "Tor requested, but no SOCKS5 proxy was found. Set the proxy or disable Tor in settings to connect."); err == nil {
 			<-time.After(notificationTimeout)
 			n.Cancel()
 		}
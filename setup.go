@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"net"
+	"fmt"
+	"io/fs"
 	"os"
 
 	"gioui.org/app"
@@ -13,88 +15,148 @@ import (
 	"path/filepath"
 )
 
-// checks to see if the local system has a listener on port 9050
-func hasTor() bool {
-	c, err := net.Dial("tcp", "127.0.0.1:9050")
-	if err != nil {
-		return false
-	}
-	c.Close()
-	return true
-}
-
-func setupCatShadow(passphrase []byte, result chan interface{}) {
+// setupCatShadow unlocks or creates the statefile and starts a client with
+// the configuration at configFile, or the default one if it is empty. The
+// stages reached are sent as unlockProgress before the result. If the state
+// has issues, a *stateReport is sent instead, which starts the client when
+// resumed. Nothing is sent once ctx is done, and whatever was started is
+// stopped. The passphrase is wiped once the statefile is opened, and a copy
+// is kept with a report. A new statefile is only created with a weak
+// passphrase if checkStrength is unset.
+func setupCatShadow(ctx context.Context, statefile, configFile string, passphrase *secureBuffer, checkStrength bool, result chan interface{}) {
 	// XXX: if the catshadowClient already exists, shut it down
 	// FIXME: figure out a better way to toggle connected/disconnected
 	// states and allow to retry attempts on a timeout or other failure.
-	var catshadowClient *catshadow.Client
 	var stateWorker *catshadow.StateWriter
 	var state *catshadow.State
 	var err error
 
-	// obtain the default data location
-	dir, err := app.DataDir()
-	if err != nil {
-		result <- err
+	if !sendProgress(ctx, result, stageConfig) {
 		return
 	}
-
-	// dir does not appear to point to ~/.config/katzen but rather ~/.config on linux?
-	// create directory for application data
-	datadir := filepath.Join(dir, dataDirName)
-	_, err = os.Stat(datadir)
-	if os.IsNotExist(err) {
-		// create the application data directory
-		err := os.Mkdir(datadir, os.ModeDir|os.FileMode(0700))
-		if err != nil {
-			result <- err
-			return
-		}
-	}
-
-	// if the statefile doesn't exist, try the default datadir
-	var statefile string
-	if _, err := os.Stat(*stateFile); os.IsNotExist(err) {
-		statefile = filepath.Join(datadir, *stateFile)
-	} else {
-		statefile = *stateFile
-	}
-
 	var cfg *config.Config
-	if len(*clientConfigFile) != 0 {
-		cfg, err = config.LoadFile(*clientConfigFile)
-		if err != nil {
-			result <- err
-			return
-		}
+	if len(configFile) != 0 {
+		cfg, err = config.LoadFile(configFile)
 	} else {
 		cfg, err = config.Load(cfgWithoutTor)
-		if err != nil {
-			result <- err
-			return
-		}
+	}
+	if err != nil {
+		sendResult(ctx, result, fmt.Errorf("%w: %w", errInvalidConfig, err))
+		return
 	}
 
 	// initialize logging
 	backendLog, err := log.New(cfg.Logging.File, cfg.Logging.Level, cfg.Logging.Disable)
 	if err != nil {
-		result <- err
+		sendResult(ctx, result, err)
 		return
 	}
 
+	// the StateWriter only reports that it cannot write the statefile to
+	// the log, once the client is running
+	if err := checkWritable(filepath.Dir(statefile)); err != nil {
+		sendResult(ctx, result, err)
+		return
+	}
+
+	if !sendProgress(ctx, result, stageDecrypt) {
+		return
+	}
 	// automatically create a statefile if one does not already exist
 	stateLogger := backendLog.GetLogger("catshadow_state")
 	if _, err = os.Stat(statefile); os.IsNotExist(err) {
-		stateWorker, err = catshadow.NewStateWriter(stateLogger, statefile, passphrase)
-	} else {
-		stateWorker, state, err = catshadow.LoadStateWriter(stateLogger, statefile, passphrase)
+		// the sign in page checks the passphrase, but a new statefile is
+		// never created with a weak one
+		if checkStrength {
+			if err := checkPassphraseStrength(passphrase.Bytes()); err != nil {
+				sendResult(ctx, result, err)
+				return
+			}
+		}
+		stateWorker, err = catshadow.NewStateWriter(stateLogger, statefile, passphrase.Bytes())
+	} else if err = checkStatefile(statefile); err == nil {
+		stateWorker, state, err = catshadow.LoadStateWriter(stateLogger, statefile, passphrase.Bytes())
+		// the statefile decrypted, but its contents do not decode
+		var pathErr *fs.PathError
+		if err != nil && !errors.Is(err, catshadow.DecryptStateFailed) && !errors.As(err, &pathErr) {
+			err = fmt.Errorf("%w: %w", errCorruptStatefile, err)
+		}
+	}
+
+	// a loaded state is checked before catshadow uses it, and the issues
+	// found are reported to be repaired first. Messages deleted while the
+	// client was running are removed from the statefile right away. Both are
+	// saved with the passphrase, so a copy of it is kept with the report
+	// until it is resumed or dismissed.
+	var issues []*stateIssue
+	var kept *secureBuffer
+	if err == nil && state != nil {
+		if purgeDeleted(state) {
+			err = saveState(statefile, state, passphrase.Bytes())
+		}
+		if issues = checkState(state); err == nil && len(issues) > 0 {
+			kept, err = passphrase.clone()
+		}
 	}
+	passphrase.wipe()
 
 	// catches any err above
 	if err != nil {
-		result <- err
+		sendResult(ctx, result, err)
+		return
+	}
+
+	// a network chosen in the settings is used unless a client
+	// configuration file was given. If it no longer loads, the default
+	// network is used, so that another one can be chosen.
+	customConfig := len(configFile) != 0
+	if !customConfig && state != nil {
+		b, err := stateNetwork(state.Blob)
+		var networkCfg *config.Config
+		if err == nil && b != nil {
+			networkCfg, err = loadNetwork(b)
+		}
+		if err != nil {
+			warnNetwork(err)
+			// disable autoconnect
+			delete(state.Blob, "AutoConnect")
+		} else if networkCfg != nil {
+			cfg, customConfig = networkCfg, true
+		}
+	}
+
+	if len(issues) > 0 {
+		report := &stateReport{
+			state:      state,
+			issues:     issues,
+			passphrase: kept,
+			resume: func(ctx context.Context, result chan interface{}, repaired bool) {
+				var err error
+				if repaired {
+					err = saveState(statefile, state, kept.Bytes())
+				}
+				kept.destroy()
+				if err != nil {
+					sendResult(ctx, result, err)
+					return
+				}
+				startCatShadow(ctx, backendLog, cfg, customConfig, stateWorker, state, result)
+			},
+		}
+		if !sendResult(ctx, result, report) {
+			report.discard()
+		}
 		return
 	}
+	startCatShadow(ctx, backendLog, cfg, customConfig, stateWorker, state, result)
+}
+
+// startCatShadow starts a client with the state loaded by setupCatShadow, or
+// a new state if it is nil. customConfig is set if cfg is not the default
+// configuration.
+func startCatShadow(ctx context.Context, backendLog *log.Backend, cfg *config.Config, customConfig bool, stateWorker *catshadow.StateWriter, state *catshadow.State, result chan interface{}) {
+	var catshadowClient *catshadow.Client
+	var err error
 
 	// create a default statefile with default options on first run
 	if state == nil {
@@ -105,42 +167,54 @@ func setupCatShadow(passphrase []byte, result chan interface{}) {
 		}
 	}
 
+	if !sendProgress(ctx, result, stageTor) {
+		return
+	}
+	// a user-supplied configuration with a proxy of its own always uses
+	// it, otherwise the proxy of the settings is looked for
+	ownProxy := customConfig && cfg.UpstreamProxy != nil && cfg.UpstreamProxy.Type != "" && cfg.UpstreamProxy.Type != "none"
+	settings := stateProxy(state.Blob)
+	var proxy string
+	if !ownProxy {
+		proxy = findProxy(ctx, settings.Address)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
 	// initialize default options
 	if state.Blob == nil {
 		state.Blob = make(map[string][]byte)
-		if hasTor() && len(*clientConfigFile) == 0 {
+		if proxy != "" && !customConfig {
 			state.Blob["UseTor"] = []byte{1}
 			state.Blob["AutoConnect"] = []byte{1}
 		}
 	}
 
-	// apply any persistent settings that are needed before bootstrapping client
-	if _, ok := state.Blob["UseTor"]; ok {
-		if len(*clientConfigFile) != 0 {
-			// a user-supplied configuration file was specified
-			if cfg.UpstreamProxy.Type != "socks5" {
-				state.Blob["UseTor"] = []byte{0}
-				result <- errors.New("User supplied configuration and client settings mismatch! UseTor option selected without valid UpstreamProxy!")
-				return
-			}
+	// apply any persistent settings that are needed before bootstrapping
+	// client. A client asked to use Tor is not started without it.
+	if _, ok := state.Blob["UseTor"]; ok && !ownProxy {
+		if proxy == "" {
+			sendResult(ctx, result, fmt.Errorf("%w: no SOCKS5 proxy was found", errTorMismatch))
+			return
 		}
-		if !hasTor() {
-			warnNoTor()
-			// disable autoconnect
-			delete(state.Blob, "AutoConnect")
-		} else {
-			cfg, err = config.Load(cfgWithTor)
-			if err != nil {
-				result <- err
-				return
-			}
+		if cfg, err = useProxy(cfg, customConfig, settings, proxy); err != nil {
+			sendResult(ctx, result, err)
+			return
 		}
 	}
 
+	if !sendProgress(ctx, result, stageClient) {
+		return
+	}
 	// create a client
 	c, err := client.New(cfg)
 	if err != nil {
-		result <- err
+		sendResult(ctx, result, err)
+		return
+	}
+	if ctx.Err() != nil {
+		c.Shutdown()
 		return
 	}
 
@@ -149,10 +223,39 @@ func setupCatShadow(passphrase []byte, result chan interface{}) {
 
 	catshadowClient, err = catshadow.New(backendLog, c, stateWorker, state)
 	if err != nil {
-		result <- err
+		sendResult(ctx, result, err)
 		c.Shutdown()
 		stateWorker.Halt()
 		return
 	}
-	result <- catshadowClient
+	if !sendResult(ctx, result, newCatshadowMessenger(catshadowClient, cfg)) {
+		catshadowClient.Shutdown()
+	}
+}
+
+// statefilePath returns the path of the statefile given with -s, or if it
+// does not exist, the same name in the application data directory
+func statefilePath() (string, error) {
+	if _, err := os.Stat(*stateFile); !os.IsNotExist(err) {
+		return *stateFile, nil
+	}
+
+	// obtain the default data location
+	dir, err := app.DataDir()
+	if err != nil {
+		return "", err
+	}
+
+	// dir does not appear to point to ~/.config/katzen but rather ~/.config on linux?
+	// create directory for application data
+	datadir := filepath.Join(dir, dataDirName)
+	_, err = os.Stat(datadir)
+	if os.IsNotExist(err) {
+		// create the application data directory
+		err := os.Mkdir(datadir, os.ModeDir|os.FileMode(0700))
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(datadir, *stateFile), nil
 }
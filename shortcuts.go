@@ -39,6 +39,8 @@ func shortcutEvents(gtx layout.Context) (key.Event, bool) {
 		key.Filter{Name: key.NamePageUp},
 		key.Filter{Name: key.NamePageDown},
 		key.Filter{Name: key.NameReturn},
+		key.Filter{Name: "F", Required: key.ModShortcut},
+		key.Filter{Name: "L", Required: key.ModShortcut},
 	}
 	if ke, ok := gtx.Event(filters...); ok {
 		switch ke := ke.(type) {
@@ -1,27 +1,45 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"gioui.org/io/key"
 	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"runtime"
+	"time"
 )
 
 type signInPage struct {
 	a          *App
 	password   *widget.Editor
 	submit     *widget.Clickable
-	result     chan interface{}
 	errMsg     string
 	connecting bool
+
+	// firstRun is set when there is no statefile yet, and a new passphrase
+	// is chosen and confirmed instead of entered
+	firstRun bool
+	confirm  *widget.Editor
+	strength passphraseStrength
+
+	// profiles opens the ProfilesPage
+	profiles *widget.Clickable
+
+	// problem is why the last attempt to unlock failed
+	problem *unlockProblem
 }
 
 func (p *signInPage) Start(stop <-chan struct{}) {
 }
 
 func (p *signInPage) Layout(gtx layout.Context) layout.Dimensions {
+	if p.firstRun {
+		return p.layoutFirstRun(gtx)
+	}
 	gtx.Execute(key.FocusCmd{Tag: p.password})
 	bg := Background{
 		Color: th.Bg,
@@ -29,43 +47,197 @@ func (p *signInPage) Layout(gtx layout.Context) layout.Dimensions {
 	}
 	return bg.Layout(gtx, func(gtx C) D {
 		return layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween, Alignment: layout.End}.Layout(gtx,
+			layout.Rigid(p.layoutProfile),
 			layout.Flexed(1, func(gtx C) D {
 				if p.errMsg != "" {
 					return layout.Center.Layout(gtx, material.Editor(th, p.password, p.errMsg).Layout)
 				}
 				return layout.Center.Layout(gtx, material.Editor(th, p.password, "Enter your password").Layout)
 			}),
+			layout.Rigid(p.layoutProblem),
 			layout.Rigid(func(gtx C) D {
+				if p.waiting(gtx) {
+					gtx = gtx.Disabled()
+				}
 				return material.Button(th, p.submit, "MEOW").Layout(gtx)
 			}),
 		)
 	})
 }
 
+// layoutFirstRun lays out the form choosing the passphrase of a new statefile
+func (p *signInPage) layoutFirstRun(gtx layout.Context) layout.Dimensions {
+	if !gtx.Focused(p.confirm) {
+		gtx.Execute(key.FocusCmd{Tag: p.password})
+	}
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	field := func(ed *widget.Editor, hint string) layout.FlexChild {
+		return layout.Rigid(func(gtx C) D {
+			bgField := Background{
+				Color:  th.ContrastBg,
+				Inset:  layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)},
+				Radius: unit.Dp(10),
+			}
+			return inset.Layout(gtx, func(gtx C) D {
+				return bgField.Layout(gtx, material.Editor(th, ed, hint).Layout)
+			})
+		})
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Spacing: layout.SpaceBetween, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(p.layoutProfile),
+			layout.Flexed(1, func(gtx C) D {
+				return layout.Center.Layout(gtx, func(gtx C) D {
+					return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+						layout.Rigid(material.H6(th, "Choose a passphrase").Layout),
+						layout.Rigid(func(gtx C) D {
+							msg := "It encrypts your statefile and cannot be recovered"
+							return inset.Layout(gtx, material.Caption(th, msg).Layout)
+						}),
+						field(p.password, "Passphrase"),
+						layout.Rigid(func(gtx C) D {
+							return inset.Layout(gtx, func(gtx C) D {
+								return layoutStrength(gtx, p.strength)
+							})
+						}),
+						field(p.confirm, "Repeat the passphrase"),
+						layout.Rigid(func(gtx C) D {
+							return inset.Layout(gtx, material.Caption(th, p.errMsg).Layout)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(p.layoutProblem),
+			layout.Rigid(func(gtx C) D {
+				return material.Button(th, p.submit, "Create statefile").Layout(gtx)
+			}),
+		)
+	})
+}
+
+// waiting returns true while signing in is delayed after wrong passphrases,
+// and redraws the page when the remaining time changes
+func (p *signInPage) waiting(gtx layout.Context) bool {
+	left := p.a.retryUnlockAt.Sub(gtx.Now)
+	if left <= 0 {
+		return false
+	}
+	next := left % time.Second
+	if next == 0 {
+		next = time.Second
+	}
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(next)})
+	return true
+}
+
+// layoutProblem lays out why the last attempt to unlock failed, what to do
+// about it, and how long to wait before trying again
+func (p *signInPage) layoutProblem(gtx layout.Context) layout.Dimensions {
+	var children []layout.FlexChild
+	if p.problem != nil {
+		children = append(children,
+			layout.Rigid(material.Body1(th, p.problem.message).Layout),
+			layout.Rigid(material.Caption(th, p.problem.action).Layout),
+		)
+	}
+	if left := p.a.retryUnlockAt.Sub(gtx.Now); left > 0 {
+		msg := fmt.Sprintf("Try again in %d s", int((left+time.Second-1)/time.Second))
+		children = append(children, layout.Rigid(material.Caption(th, msg).Layout))
+	}
+	if len(children) == 0 {
+		return D{}
+	}
+	return inset.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx, children...)
+	})
+}
+
+// layoutProfile lays out the name of the profile to sign in to, with a
+// button to choose another one
+func (p *signInPage) layoutProfile(gtx layout.Context) layout.Dimensions {
+	if p.a.profile == nil {
+		return D{}
+	}
+	return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+		layout.Flexed(1, func(gtx C) D {
+			return inset.Layout(gtx, material.Body1(th, "Profile: "+p.a.profile.name).Layout)
+		}),
+		layout.Rigid(func(gtx C) D {
+			return inset.Layout(gtx, material.Button(th, p.profiles, "Profiles").Layout)
+		}),
+	)
+}
+
+// signInStarted is sent when unlocking starts
 type signInStarted struct {
-	result chan interface{}
+	task *unlockTask
 }
 
 func (p *signInPage) Event(gtx layout.Context) interface{} {
-	if ev, ok := p.password.Update(gtx); ok {
+	for {
+		ev, ok := p.password.Update(gtx)
+		if !ok {
+			break
+		}
 		switch ev.(type) {
+		case widget.ChangeEvent:
+			p.strength = editorStrength(p.password)
 		case widget.SubmitEvent:
-			p.submit.Click()
+			if p.firstRun {
+				gtx.Execute(key.FocusCmd{Tag: p.confirm})
+			} else {
+				p.submit.Click()
+			}
 		}
 	}
+	if p.firstRun {
+		if ev, ok := p.confirm.Update(gtx); ok {
+			if _, ok := ev.(widget.SubmitEvent); ok {
+				p.submit.Click()
+			}
+		}
+	}
+
+	if p.profiles.Clicked(gtx) {
+		return ShowProfilesClick{}
+	}
 
-	if p.submit.Clicked(gtx) {
+	if p.submit.Clicked(gtx) && !p.waiting(gtx) {
 		p.connecting = true
-		pw := p.password.Text()
+		// the passphrase is read from the editor into a secureBuffer, and
+		// kept there from here on
+		var pass *secureBuffer
+		var err error
+		if p.firstRun {
+			pass, err = readNewPassphrase(p.password, p.confirm)
+			p.confirm.SetText("")
+		} else {
+			pass, err = editorSecret(p.password)
+		}
+		if err != nil {
+			p.errMsg = err.Error()
+			return nil
+		}
 		p.password.SetText("")
-		if len(pw) != 0 && len(pw) < minPasswordLen {
+		if n := len(pass.Bytes()); !p.firstRun && n != 0 && n < minPasswordLen {
+			pass.destroy()
 			p.errMsg = fmt.Sprintf("Password must be minimum %d characters long", minPasswordLen)
 		} else {
-			go func() {
-				setupCatShadow([]byte(pw), p.result)
-				p.a.w.Invalidate()
-			}()
-			return signInStarted{result: p.result}
+			statefile, err := p.a.statefile()
+			if err != nil {
+				pass.destroy()
+				problem := classifyUnlockError(err)
+				p.problem = &problem
+				return nil
+			}
+			configFile := p.a.configFile()
+			task := p.a.startUnlock(func(ctx context.Context, result chan interface{}) {
+				unlock(ctx, statefile, configFile, pass, result)
+			})
+			return signInStarted{task: task}
 		}
 	}
 	return nil
@@ -73,15 +245,25 @@ func (p *signInPage) Event(gtx layout.Context) interface{} {
 
 func newSignInPage(a *App) *signInPage {
 	pw := &widget.Editor{SingleLine: true, Mask: '*', Submit: true}
+	confirm := &widget.Editor{SingleLine: true, Mask: '*', Submit: true}
 
 	if runtime.GOOS == "android" {
 		pw.Submit = false
+		confirm.Submit = false
 	}
 
-	return &signInPage{
+	p := &signInPage{
 		a:        a,
 		password: pw,
 		submit:   &widget.Clickable{},
-		result:   make(chan interface{}, 1),
+		confirm:  confirm,
+		strength: estimateStrength(nil),
+		profiles: &widget.Clickable{},
+	}
+	if err := a.loadProfile(); err != nil {
+		p.errMsg = err.Error()
 	}
+	a.loadUnlockDelay()
+	p.firstRun = !a.statefileExists()
+	return p
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"gioui.org/gesture"
 	"gioui.org/layout"
 	"gioui.org/op/clip"
@@ -19,6 +20,7 @@ type SpoolPage struct {
 	a              *App
 	provider       *layout.List
 	providerClicks map[string]*gesture.Click
+	selectedIdx    int
 	connect        *widget.Clickable
 	settings       *widget.Clickable
 	back           *widget.Clickable
@@ -57,8 +59,14 @@ func (p *SpoolPage) Layout(gtx layout.Context) layout.Dimensions {
 					gtx,
 					layout.Rigid(layoutLogo),
 					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(func(gtx C) D {
+						if t, ok := p.a.state.TorStatus(); ok {
+							return material.Caption(th, fmt.Sprintf("Tor %d%%", t.progress)).Layout(gtx)
+						}
+						return D{}
+					}),
 					func() layout.FlexChild {
-						if isConnected {
+						if p.a.state.Connected() {
 							return layout.Rigid(button(th, p.connect, connectIcon).Layout)
 						}
 						return layout.Rigid(button(th, p.connect, disconnectIcon).Layout)
@@ -72,7 +80,11 @@ func (p *SpoolPage) Layout(gtx layout.Context) layout.Dimensions {
 				if err == catshadow.ErrNotOnline {
 					return material.Body2(th, "Welcome to Katzen. Please connect to choose a message storage provider").Layout(gtx)
 				}
-				if isConnecting {
+				if t, ok := p.a.state.TorStatus(); ok {
+					msg := fmt.Sprintf("Waiting for Tor, %d%%: %s", t.progress, t.summary)
+					return material.Body2(th, msg).Layout(gtx)
+				}
+				if p.a.state.Connecting() {
 					return material.Body2(th, "Connecting...").Layout(gtx)
 				}
 				return material.Body2(th, "Please choose a message storage provider").Layout(gtx)
@@ -90,7 +102,7 @@ func (p *SpoolPage) Layout(gtx layout.Context) layout.Dimensions {
 
 					// if the layout is selected, change background color
 					bg := Background{Inset: in}
-					if i == selectedIdx {
+					if i == p.selectedIdx {
 						bg.Color = th.ContrastBg
 					} else {
 						bg.Color = th.Bg
@@ -122,7 +134,7 @@ func (p *SpoolPage) Event(gtx layout.Context) interface{} {
 		return BackEvent{}
 	}
 	if p.connect.Clicked(gtx) {
-		if !isConnected && !isConnecting {
+		if !p.a.state.Connected() && !p.a.state.Connecting() {
 			return OnlineClick{}
 		}
 		return OfflineClick{}
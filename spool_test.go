@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestSpoolPage(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.providers = []string{"provider1", "provider2"}
+	h := newPageHarness(t, f, func(a *App) Page {
+		a.stack.Push(newHomePage(a))
+		return newSpoolPage(a)
+	})
+	h.frames(2)
+	p := h.current().(*SpoolPage)
+	if len(p.providerClicks) != 0 {
+		t.Error("providers are listed while offline")
+	}
+
+	f.Lock()
+	f.online = true
+	f.Unlock()
+	h.frames(2)
+	if len(p.providerClicks) != len(f.providers) {
+		t.Fatalf("%d providers are listed, want %d", len(p.providerClicks), len(f.providers))
+	}
+
+	// choosing the second provider creates the spool and returns home
+	h.click(image.Pt(200, 110))
+	for deadline := time.Now().Add(5 * time.Second); h.current() == Page(p); h.frame() {
+		if time.Now().After(deadline) {
+			t.Fatal("spool page was not closed after choosing a provider")
+		}
+	}
+	if _, ok := h.current().(*HomePage); !ok {
+		t.Fatalf("current page is %T, want *HomePage", h.current())
+	}
+	if s := f.SpoolWriteDescriptor(); s == nil || s.Provider != "provider2" {
+		t.Errorf("spool was created on %v, want provider2", s)
+	}
+}
@@ -156,3 +156,38 @@ func (s *pageStack) Clear(p Page) {
 	}
 	s.Push(p)
 }
+
+func (s *pageStack) Replace(p Page) {
+	if len(s.pages) == 0 {
+		s.Push(p)
+		return
+	}
+	if s.stopChan != nil {
+		s.stop()
+	}
+	s.pages[len(s.pages)-1] = p
+	s.start()
+}
+
+// PopTo pops pages until match returns true for the top of the stack, and
+// reports whether a matching page was found. The stack is left untouched if
+// no page matches.
+func (s *pageStack) PopTo(match func(Page) bool) bool {
+	for i := len(s.pages) - 1; i >= 0; i-- {
+		if match(s.pages[i]) {
+			if i == len(s.pages)-1 {
+				return true
+			}
+			if s.stopChan != nil {
+				s.stop()
+			}
+			for j := i + 1; j < len(s.pages); j++ {
+				s.pages[j] = nil
+			}
+			s.pages = s.pages[:i+1]
+			s.start()
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/x/notify"
+)
+
+// maxConnectionEvents is the number of connection events kept for the
+// StatusPage
+const maxConnectionEvents = 10
+
+// connectionEvent is a change in connectivity reported by the client
+type connectionEvent struct {
+	when      time.Time
+	connected bool
+	err       error
+}
+
+// appState is the mutable UI state owned by an App and shared by its pages.
+// It is read from the gio event loop and written from catshadow event
+// handlers and page worker goroutines, so every access is synchronized.
+// Registered change handlers are called after the connection state changes.
+//
+// State that belongs to a single view, such as list scroll positions and
+// keyboard selection, is owned by the page itself.
+type appState struct {
+	sync.Mutex
+
+	connected  bool
+	connecting bool
+
+	// tor is the bootstrap progress of Tor while connecting waits for it
+	tor *torStatus
+
+	// retryAt is the time of the scheduled reconnection, or zero
+	retryAt time.Time
+
+	// connectionEvents are the last connection events, oldest first
+	connectionEvents []connectionEvent
+
+	// avatars caches the decoded avatar widget for each contact
+	avatars map[string]layout.Widget
+
+	// notifications holds the last message notification for each contact
+	notifications map[string]notify.Notification
+
+	onChange []func()
+}
+
+func newAppState() *appState {
+	return &appState{
+		avatars:       make(map[string]layout.Widget),
+		notifications: make(map[string]notify.Notification),
+	}
+}
+
+// OnChange registers f to be called after the connection state changes
+func (s *appState) OnChange(f func()) {
+	s.Lock()
+	defer s.Unlock()
+	s.onChange = append(s.onChange, f)
+}
+
+func (s *appState) changed() {
+	s.Lock()
+	handlers := s.onChange
+	s.Unlock()
+	for _, f := range handlers {
+		f()
+	}
+}
+
+// Connected returns true if the client reported a connection to the mixnet
+func (s *appState) Connected() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.connected
+}
+
+// Connecting returns true while a connection attempt is in progress
+func (s *appState) Connecting() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.connecting
+}
+
+// SetConnecting marks a connection attempt as started
+func (s *appState) SetConnecting() {
+	s.Lock()
+	s.connecting = true
+	s.Unlock()
+	s.changed()
+}
+
+// SetConnected records the outcome of a connection attempt or a change in
+// connectivity, and ends any connection attempt in progress
+func (s *appState) SetConnected(connected bool) {
+	s.Lock()
+	s.connected = connected
+	s.connecting = false
+	s.Unlock()
+	s.changed()
+}
+
+// AddConnectionEvent records a connection event, dropping the oldest past
+// maxConnectionEvents
+func (s *appState) AddConnectionEvent(e connectionEvent) {
+	s.Lock()
+	defer s.Unlock()
+	s.connectionEvents = append(s.connectionEvents, e)
+	if n := len(s.connectionEvents); n > maxConnectionEvents {
+		s.connectionEvents = append([]connectionEvent(nil), s.connectionEvents[n-maxConnectionEvents:]...)
+	}
+}
+
+// ConnectionEvents returns the recorded connection events, newest first
+func (s *appState) ConnectionEvents() []connectionEvent {
+	s.Lock()
+	defer s.Unlock()
+	events := make([]connectionEvent, len(s.connectionEvents))
+	for i, e := range s.connectionEvents {
+		events[len(events)-1-i] = e
+	}
+	return events
+}
+
+// ForgetConnectionEvents drops the recorded connection events
+func (s *appState) ForgetConnectionEvents() {
+	s.Lock()
+	defer s.Unlock()
+	s.connectionEvents = nil
+}
+
+// RetryAt returns the time of the scheduled reconnection, if any
+func (s *appState) RetryAt() (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	return s.retryAt, !s.retryAt.IsZero()
+}
+
+// SetRetryAt records the time of the scheduled reconnection, or zero if
+// none is scheduled
+func (s *appState) SetRetryAt(t time.Time) {
+	s.Lock()
+	s.retryAt = t
+	s.Unlock()
+	s.changed()
+}
+
+// TorStatus returns the bootstrap progress of Tor, if connecting waits for it
+func (s *appState) TorStatus() (torStatus, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.tor == nil {
+		return torStatus{}, false
+	}
+	return *s.tor, true
+}
+
+// SetTorStatus records the bootstrap progress of Tor
+func (s *appState) SetTorStatus(t torStatus) {
+	s.Lock()
+	s.tor = &t
+	s.Unlock()
+	s.changed()
+}
+
+// ClearTorStatus records that connecting no longer waits for Tor
+func (s *appState) ClearTorStatus() {
+	s.Lock()
+	s.tor = nil
+	s.Unlock()
+	s.changed()
+}
+
+// Avatar returns the cached avatar widget for nickname
+func (s *appState) Avatar(nickname string) (layout.Widget, bool) {
+	s.Lock()
+	defer s.Unlock()
+	w, ok := s.avatars[nickname]
+	return w, ok
+}
+
+// SetAvatar caches the avatar widget for nickname
+func (s *appState) SetAvatar(nickname string, w layout.Widget) {
+	s.Lock()
+	defer s.Unlock()
+	s.avatars[nickname] = w
+}
+
+// ForgetAvatar drops the cached avatar for nickname, so that it is reloaded
+// from the client on the next layout
+func (s *appState) ForgetAvatar(nickname string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.avatars, nickname)
+}
+
+// ForgetAvatars drops every cached avatar
+func (s *appState) ForgetAvatars() {
+	s.Lock()
+	defer s.Unlock()
+	s.avatars = make(map[string]layout.Widget)
+}
+
+// SetNotification replaces the message notification shown for nickname,
+// cancelling the previous one
+func (s *appState) SetNotification(nickname string, n notify.Notification) {
+	s.Lock()
+	defer s.Unlock()
+	if o, ok := s.notifications[nickname]; ok {
+		o.Cancel()
+	}
+	s.notifications[nickname] = n
+}
+
+// CancelNotifications cancels the message notifications of every contact
+func (s *appState) CancelNotifications() {
+	s.Lock()
+	defer s.Unlock()
+	for nickname, n := range s.notifications {
+		n.Cancel()
+		delete(s.notifications, nickname)
+	}
+}
+
+// CancelNotification cancels the message notification shown for nickname
+func (s *appState) CancelNotification(nickname string) {
+	s.Lock()
+	defer s.Unlock()
+	if n, ok := s.notifications[nickname]; ok {
+		n.Cancel()
+		delete(s.notifications, nickname)
+	}
+}
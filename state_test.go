@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppStateConnection(t *testing.T) {
+	s := newAppState()
+	changes := 0
+	s.OnChange(func() { changes++ })
+
+	s.SetConnecting()
+	if !s.Connecting() || s.Connected() {
+		t.Fatal("expected connecting and not connected")
+	}
+	s.SetConnected(true)
+	if s.Connecting() || !s.Connected() {
+		t.Fatal("expected connected and no longer connecting")
+	}
+	s.SetConnected(false)
+	if s.Connected() {
+		t.Fatal("expected disconnected")
+	}
+	if changes != 3 {
+		t.Errorf("change handlers called %d times, want 3", changes)
+	}
+}
+
+func TestAppStateConnectionEvents(t *testing.T) {
+	s := newAppState()
+	for i := 0; i < maxConnectionEvents+2; i++ {
+		s.AddConnectionEvent(connectionEvent{connected: i%2 == 0, when: time.Unix(int64(i), 0)})
+	}
+	events := s.ConnectionEvents()
+	if len(events) != maxConnectionEvents {
+		t.Fatalf("kept %d events, want %d", len(events), maxConnectionEvents)
+	}
+	if events[0].when.Unix() != maxConnectionEvents+1 || events[len(events)-1].when.Unix() != 2 {
+		t.Errorf("events are not the newest first: %v", events)
+	}
+	s.ForgetConnectionEvents()
+	if len(s.ConnectionEvents()) != 0 {
+		t.Error("events kept after ForgetConnectionEvents")
+	}
+}
+
+func TestAppStateAvatars(t *testing.T) {
+	s := newAppState()
+	s.SetAvatar("alice", func(gtx C) D { return D{} })
+	if _, ok := s.Avatar("alice"); !ok {
+		t.Fatal("avatar for alice not cached")
+	}
+	s.ForgetAvatar("alice")
+	if _, ok := s.Avatar("alice"); ok {
+		t.Error("avatar for alice still cached after ForgetAvatar")
+	}
+}
+
+// each App owns its state, so pages in one window do not observe another
+func TestAppStateIsPerApp(t *testing.T) {
+	a := newTestApp(newFakeMessenger())
+	b := newTestApp(newFakeMessenger())
+	a.state.SetConnected(true)
+	if b.state.Connected() {
+		t.Error("connection state leaked between apps")
+	}
+}
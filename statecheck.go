@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/katzenpost/katzenpost/catshadow"
+	"golang.org/x/term"
+)
+
+// maxMessageExpiration is the longest message expiration that can be chosen
+// when editing a contact
+const maxMessageExpiration = time.Duration(maxExpiration) * 24 * time.Hour
+
+// contactBlobPrefixes are the prefixes of the blob ids kept per contact. The
+// attachments listed in the attachmentIndexPrefix blob have ids of their own.
+var contactBlobPrefixes = []string{"avatar://", deletedBlobPrefix, attachmentIndexPrefix}
+
+// deleteContactBlobs deletes the blobs kept for the contact nickname, and
+// what is cached from them
+func (a *App) deleteContactBlobs(nickname string) {
+	a.deleteAttachments(nickname)
+	for _, prefix := range contactBlobPrefixes {
+		a.c.DeleteBlob(prefix + nickname)
+	}
+	a.state.ForgetAvatar(nickname)
+	a.deletions.forget(nickname)
+}
+
+// issueKind is the kind of inconsistency found in a state
+type issueKind int
+
+const (
+	// orphanConversation is a conversation without a contact, which
+	// catshadow cannot load
+	orphanConversation issueKind = iota
+	// orphanBlob is a blob kept for a contact that does not exist
+	orphanBlob
+	// duplicateNickname is a contact with the nickname of an earlier one,
+	// which catshadow hides
+	duplicateNickname
+	// badExpiration is a message expiration that cannot be chosen
+	badExpiration
+)
+
+// stateIssue is an inconsistency found in a state, and how it is repaired
+type stateIssue struct {
+	kind issueKind
+	// nickname is the contact or conversation concerned
+	nickname string
+	// blob is the id of an orphaned blob
+	blob string
+	// contact is the duplicate contact, or the one with a bad expiration
+	contact    *catshadow.Contact
+	expiration time.Duration
+	repaired   bool
+}
+
+// fatal returns true if catshadow cannot be started with the issue
+func (i *stateIssue) fatal() bool {
+	return i.kind == orphanConversation
+}
+
+// String describes the issue
+func (i *stateIssue) String() string {
+	switch i.kind {
+	case orphanConversation:
+		return fmt.Sprintf("Conversation with %s has no contact", i.nickname)
+	case orphanBlob:
+		return fmt.Sprintf("Stored data %s has no contact", i.blob)
+	case duplicateNickname:
+		return fmt.Sprintf("Two contacts are named %s", i.nickname)
+	case badExpiration:
+		return fmt.Sprintf("Message expiration of %s is invalid (%v)", i.nickname, i.expiration)
+	}
+	return "Unknown issue"
+}
+
+// action describes the repair of the issue
+func (i *stateIssue) action() string {
+	switch i.kind {
+	case orphanConversation:
+		return "Delete conversation"
+	case orphanBlob:
+		return "Delete data"
+	case duplicateNickname:
+		return "Rename contact"
+	case badExpiration:
+		return "Reset expiration"
+	}
+	return "Repair"
+}
+
+// checkState returns the inconsistencies found in s, in a stable order
+func checkState(s *catshadow.State) []*stateIssue {
+	var issues []*stateIssue
+	contacts := make(map[string]bool)
+	for _, c := range s.Contacts {
+		if contacts[c.Nickname] {
+			issues = append(issues, &stateIssue{kind: duplicateNickname, nickname: c.Nickname, contact: c})
+			continue
+		}
+		contacts[c.Nickname] = true
+		exp, err := contactExpiration(c)
+		if err == nil && (exp < 0 || exp > maxMessageExpiration) {
+			issues = append(issues, &stateIssue{kind: badExpiration, nickname: c.Nickname, contact: c, expiration: exp})
+		}
+	}
+
+	var names []string
+	for nickname := range s.Conversations {
+		if !contacts[nickname] {
+			names = append(names, nickname)
+		}
+	}
+	sort.Strings(names)
+	for _, nickname := range names {
+		issues = append(issues, &stateIssue{kind: orphanConversation, nickname: nickname})
+	}
+
+	var blobs []string
+	for id := range s.Blob {
+		for _, prefix := range contactBlobPrefixes {
+			if strings.HasPrefix(id, prefix) && !contacts[strings.TrimPrefix(id, prefix)] {
+				blobs = append(blobs, id)
+			}
+		}
+		if nickname, ok := attachmentNickname(id); ok && !contacts[nickname] {
+			blobs = append(blobs, id)
+		}
+	}
+	sort.Strings(blobs)
+	for _, id := range blobs {
+		issues = append(issues, &stateIssue{kind: orphanBlob, blob: id})
+	}
+	return issues
+}
+
+// repair fixes the issue in s
+func (i *stateIssue) repair(s *catshadow.State) error {
+	if i.repaired {
+		return nil
+	}
+	switch i.kind {
+	case orphanConversation:
+		// as catshadow wipes a conversation
+		for id, m := range s.Conversations[i.nickname] {
+			for j := range m.Plaintext {
+				m.Plaintext[j] = 0
+			}
+			delete(s.Conversations[i.nickname], id)
+		}
+		delete(s.Conversations, i.nickname)
+	case orphanBlob:
+		delete(s.Blob, i.blob)
+	case duplicateNickname:
+		taken := make(map[string]bool)
+		for _, c := range s.Contacts {
+			taken[c.Nickname] = true
+		}
+		name := i.nickname
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s (%d)", i.nickname, n)
+		}
+		i.contact.Nickname = name
+	case badExpiration:
+		if err := setContactExpiration(i.contact, catshadow.MessageExpirationDuration); err != nil {
+			return err
+		}
+	}
+	i.repaired = true
+	return nil
+}
+
+// contactExpiration returns the message expiration of c, which catshadow
+// only exposes through the client
+func contactExpiration(c *catshadow.Contact) (time.Duration, error) {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	var s struct{ MessageExpiration time.Duration }
+	if err := cbor.Unmarshal(b, &s); err != nil {
+		return 0, err
+	}
+	return s.MessageExpiration, nil
+}
+
+// setContactExpiration sets the message expiration of c, by changing it in
+// the serialized contact, which keeps every other field as it is
+func setContactExpiration(c *catshadow.Contact, d time.Duration) error {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	var s map[string]cbor.RawMessage
+	if err := cbor.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s["MessageExpiration"], err = cbor.Marshal(d); err != nil {
+		return err
+	}
+	if b, err = cbor.Marshal(s); err != nil {
+		return err
+	}
+	return c.UnmarshalBinary(b)
+}
+
+// checkedStatefile returns the statefile checked with -check-state: the one
+// given with -s, or that of the profile signed in to last
+func checkedStatefile() (string, error) {
+	if !useProfiles() {
+		return statefilePath()
+	}
+	store, err := openProfiles()
+	if err != nil {
+		return "", err
+	}
+	p := store.Last()
+	if p == nil {
+		return "", errors.New("no profile was signed in to")
+	}
+	return p.statefile(), nil
+}
+
+// readPassphrase reads a passphrase from the first line of in, without
+// echoing it if in is a terminal
+func readPassphrase(in io.Reader) ([]byte, error) {
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return term.ReadPassword(int(f.Fd()))
+	}
+	line, err := bufio.NewReader(in).ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// checkStateMain prints the issues found in the statefile, and with -repair
+// repairs them and saves it. The passphrase is read from the first line of
+// in. It returns the exit status: 0 if no issue is left, 1 if some are, and
+// 2 if the statefile could not be checked.
+func checkStateMain(in io.Reader, out io.Writer, repair bool) int {
+	path, err := checkedStatefile()
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	fmt.Fprintf(out, "Passphrase for %s: ", path)
+	passphrase, err := readPassphrase(in)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	defer clear(passphrase)
+	fmt.Fprintln(out)
+
+	// the StateWriter is only used to load the state and is not started
+	_, state, err := catshadow.LoadStateWriter(nil, path, passphrase)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	issues := checkState(state)
+	left := 0
+	for _, i := range issues {
+		if repair {
+			if err := i.repair(state); err != nil {
+				fmt.Fprintf(out, "%s: %s\n", i, err)
+				left++
+				continue
+			}
+			fmt.Fprintf(out, "%s: %s\n", i, i.action())
+		} else {
+			fmt.Fprintln(out, i)
+			left++
+		}
+	}
+	if repair && left < len(issues) {
+		if err := saveState(path, state, passphrase); err != nil {
+			fmt.Fprintln(out, err)
+			return 2
+		}
+	}
+	fmt.Fprintf(out, "%d issues found, %d left\n", len(issues), left)
+	if left > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// newTestContact returns a contact as catshadow creates it
+func newTestContact(t *testing.T, nickname string, id uint64) *catshadow.Contact {
+	t.Helper()
+	c, err := catshadow.NewContact(nickname, id, []byte("secret"), "x25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newBrokenState returns a state with one issue of each kind
+func newBrokenState(t *testing.T) *catshadow.State {
+	t.Helper()
+	carol := newTestContact(t, "carol", 4)
+	if err := setContactExpiration(carol, -time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return &catshadow.State{
+		Contacts: []*catshadow.Contact{
+			newTestContact(t, "alice", 1),
+			newTestContact(t, "bob", 2),
+			newTestContact(t, "bob", 3),
+			carol,
+		},
+		Conversations: map[string]map[catshadow.MessageID]*catshadow.Message{
+			"alice": {{1}: {Plaintext: []byte("hi alice"), Timestamp: time.Now()}},
+			"ghost": {{2}: {Plaintext: []byte("boo"), Timestamp: time.Now()}},
+		},
+		Blob: map[string][]byte{
+			"UseTor":                    {1},
+			"avatar://alice":            []byte("png"),
+			"avatar://ghost":            []byte("png"),
+			deletedBlobPrefix + "ghost": []byte("keys"),
+		},
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	s := newBrokenState(t)
+	s.Blob[attachmentBlobID("ghost", "00")] = []byte("notes")
+	ghost := s.Conversations["ghost"][catshadow.MessageID{2}]
+	issues := checkState(s)
+	want := []string{
+		"Two contacts are named bob",
+		"Message expiration of carol is invalid (-1h0m0s)",
+		"Conversation with ghost has no contact",
+		"Stored data attachment://ghost/00 has no contact",
+		"Stored data avatar://ghost has no contact",
+		"Stored data deleted://ghost has no contact",
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues %v, want %d", len(issues), issues, len(want))
+	}
+	for i, issue := range issues {
+		if issue.String() != want[i] {
+			t.Errorf("issue %d is %q, want %q", i, issue, want[i])
+		}
+	}
+	if !issues[2].fatal() || issues[0].fatal() {
+		t.Error("only the conversation without a contact is fatal")
+	}
+
+	for _, issue := range issues {
+		if err := issue.repair(s); err != nil {
+			t.Fatalf("%s: %v", issue, err)
+		}
+	}
+	if left := checkState(s); len(left) != 0 {
+		t.Errorf("issues left after repair: %v", left)
+	}
+	if s.Contacts[2].Nickname != "bob (2)" || s.Contacts[2].ID() != 3 {
+		t.Errorf("duplicate renamed to %q", s.Contacts[2].Nickname)
+	}
+	if exp, err := contactExpiration(s.Contacts[3]); err != nil || exp != catshadow.MessageExpirationDuration {
+		t.Errorf("expiration is %v, %v after repair", exp, err)
+	}
+	if s.Contacts[3].Nickname != "carol" || s.Contacts[3].ID() != 4 {
+		t.Error("resetting the expiration changed the contact")
+	}
+	if len(ghost.Plaintext) != 3 || !bytes.Equal(ghost.Plaintext, make([]byte, 3)) {
+		t.Error("deleted conversation was not zeroed")
+	}
+	if _, ok := s.Conversations["alice"]; !ok || s.Blob["UseTor"] == nil || s.Blob["avatar://alice"] == nil {
+		t.Error("repair removed consistent data")
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statefile")
+	writeTestState(t, path, []byte("hunter2"))
+	s := newBrokenState(t)
+	for _, issue := range checkState(s) {
+		issue.repair(s)
+	}
+	if err := saveState(path, s, []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	_, loaded, err := catshadow.LoadStateWriter(nil, path, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Contacts) != 4 || len(loaded.Conversations) != 1 {
+		t.Errorf("saved state has %d contacts and %d conversations", len(loaded.Contacts), len(loaded.Conversations))
+	}
+	if left := checkState(loaded); len(left) != 0 {
+		t.Errorf("saved state has issues: %v", left)
+	}
+}
+
+func TestCheckStateMain(t *testing.T) {
+	path := useStateFile(t)
+	if err := saveState(path, newBrokenState(t), []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := checkStateMain(strings.NewReader("wrong\n"), out, false); code != 2 {
+		t.Errorf("exit status %d with a wrong passphrase, want 2", code)
+	}
+	out.Reset()
+	if code := checkStateMain(strings.NewReader("hunter2\n"), out, false); code != 1 {
+		t.Errorf("exit status %d with issues, want 1", code)
+	}
+	if !strings.Contains(out.String(), "Conversation with ghost has no contact") || !strings.Contains(out.String(), "5 issues found, 5 left") {
+		t.Errorf("unexpected report:\n%s", out)
+	}
+	out.Reset()
+	if code := checkStateMain(strings.NewReader("hunter2"), out, true); code != 0 {
+		t.Errorf("exit status %d after repair, want 0:\n%s", code, out)
+	}
+	out.Reset()
+	if code := checkStateMain(strings.NewReader("hunter2\n"), out, false); code != 0 {
+		t.Errorf("exit status %d after repair, want 0:\n%s", code, out)
+	}
+}
+
+func TestStateReportPage(t *testing.T) {
+	s := newBrokenState(t)
+	resumed := make(chan bool, 1)
+	r := &stateReport{
+		state:  s,
+		issues: checkState(s),
+		resume: func(_ context.Context, _ chan interface{}, repaired bool) { resumed <- repaired },
+	}
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		return newStateReportPage(a, r)
+	})
+	p := h.current().(*StateReportPage)
+	h.screenshot("statereport")
+
+	// the conversation without a contact must be deleted first
+	p.proceed.Click()
+	h.frames(2)
+	if h.current() != Page(p) {
+		t.Fatal("continued with a fatal issue")
+	}
+	p.repairs[0].Click()
+	h.frames(2)
+	if !r.issues[0].repaired || r.issues[1].repaired {
+		t.Fatal("repair did not apply to its issue only")
+	}
+	p.repairAll.Click()
+	h.frames(2)
+	for _, issue := range r.issues {
+		if !issue.repaired {
+			t.Errorf("%s was not repaired", issue)
+		}
+	}
+	p.proceed.Click()
+	h.frames(2)
+	select {
+	case repaired := <-resumed:
+		if !repaired {
+			t.Error("resumed without saving the repairs")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("startup was not resumed")
+	}
+	if _, ok := h.current().(*unlockPage); !ok {
+		t.Errorf("current page is %T, want *unlockPage", h.current())
+	}
+}
+
+func TestStateReportCancel(t *testing.T) {
+	useStateFile(t)
+	s := newBrokenState(t)
+	r := &stateReport{state: s, issues: checkState(s), resume: func(context.Context, chan interface{}, bool) { t.Error("resumed after cancel") }}
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		return newStateReportPage(a, r)
+	})
+	h.current().(*StateReportPage).cancel.Click()
+	h.frames(2)
+	if _, ok := h.current().(*signInPage); !ok {
+		t.Errorf("current page is %T, want *signInPage", h.current())
+	}
+}
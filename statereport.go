@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// stateReport holds a loaded state with issues, until they are repaired and
+// the client is started with it
+type stateReport struct {
+	state  *catshadow.State
+	issues []*stateIssue
+	// passphrase is kept to save the repaired state, and destroyed once the
+	// report is resumed or discarded
+	passphrase *secureBuffer
+	// resume saves the state if it was repaired, and starts the client
+	resume func(ctx context.Context, result chan interface{}, repaired bool)
+}
+
+// discard destroys the passphrase of a report that is not resumed
+func (r *stateReport) discard() {
+	r.passphrase.destroy()
+}
+
+// unlockReport is sent when the unlocked statefile has issues
+type unlockReport struct {
+	report *stateReport
+}
+
+// StateCheckCancel is the event sent when the report is dismissed without
+// starting the client
+type StateCheckCancel struct{}
+
+// StateReportPage lists the issues found in the statefile, and repairs them
+// before the client is started
+type StateReportPage struct {
+	a         *App
+	report    *stateReport
+	list      *layout.List
+	repairs   []*widget.Clickable
+	repairAll *widget.Clickable
+	proceed   *widget.Clickable
+	cancel    *widget.Clickable
+	// repaired is set once the state was changed and must be saved
+	repaired bool
+	errMsg   string
+}
+
+// blocked returns true if an issue that catshadow cannot start with is left
+func (p *StateReportPage) blocked() bool {
+	for _, i := range p.report.issues {
+		if i.fatal() && !i.repaired {
+			return true
+		}
+	}
+	return false
+}
+
+// Layout returns the list of issues and their repairs
+func (p *StateReportPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.H6(th, "Statefile Check").Layout)
+					}),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Rigid(func(gtx C) D {
+				msg := fmt.Sprintf("%d issues were found in the statefile", len(p.report.issues))
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(p.report.issues), func(gtx C, i int) D {
+					issue := p.report.issues[i]
+					return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+						layout.Flexed(1, func(gtx C) D {
+							return inset.Layout(gtx, material.Body2(th, issue.String()).Layout)
+						}),
+						layout.Rigid(func(gtx C) D {
+							label := issue.action()
+							if issue.repaired {
+								label = "Repaired"
+								gtx = gtx.Disabled()
+							}
+							return inset.Layout(gtx, material.Button(th, p.repairs[i], label).Layout)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(func(gtx C) D {
+				msg := p.errMsg
+				if msg == "" && p.blocked() {
+					msg = "Conversations without a contact must be deleted to continue"
+				}
+				return inset.Layout(gtx, material.Caption(th, msg).Layout)
+			}),
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Spacing: layout.SpaceEvenly}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.repairAll, "Repair All").Layout)
+					}),
+					layout.Rigid(func(gtx C) D {
+						if p.blocked() {
+							gtx = gtx.Disabled()
+						}
+						return inset.Layout(gtx, material.Button(th, p.proceed, "Continue").Layout)
+					}),
+					layout.Rigid(func(gtx C) D {
+						return inset.Layout(gtx, material.Button(th, p.cancel, "Cancel").Layout)
+					}),
+				)
+			}),
+		)
+	})
+}
+
+// repair repairs the issues and records the first failure
+func (p *StateReportPage) repair(issues ...*stateIssue) {
+	p.errMsg = ""
+	for _, i := range issues {
+		if i.repaired {
+			continue
+		}
+		if err := i.repair(p.report.state); err != nil {
+			p.errMsg = fmt.Sprintf("%s: %s", i, err)
+			return
+		}
+		p.repaired = true
+	}
+}
+
+func (p *StateReportPage) Event(gtx layout.Context) interface{} {
+	for i, c := range p.repairs {
+		if c.Clicked(gtx) {
+			p.repair(p.report.issues[i])
+		}
+	}
+	if p.repairAll.Clicked(gtx) {
+		p.repair(p.report.issues...)
+	}
+	if p.cancel.Clicked(gtx) {
+		p.report.discard()
+		return StateCheckCancel{}
+	}
+	if p.proceed.Clicked(gtx) && !p.blocked() {
+		r, repaired := p.report, p.repaired
+		task := p.a.startUnlock(func(ctx context.Context, result chan interface{}) {
+			r.resume(ctx, result, repaired)
+		})
+		return signInStarted{task: task}
+	}
+	return nil
+}
+
+func (p *StateReportPage) Start(stop <-chan struct{}) {
+}
+
+func init() {
+	handle(func(a *App, e unlockReport) interface{} {
+		return Reset{newStateReportPage(a, e.report)}
+	})
+	handle(func(a *App, _ StateCheckCancel) interface{} {
+		return Reset{newSignInPage(a)}
+	})
+}
+
+func newStateReportPage(a *App, r *stateReport) *StateReportPage {
+	p := &StateReportPage{
+		a:         a,
+		report:    r,
+		list:      &layout.List{Axis: layout.Vertical},
+		repairAll: &widget.Clickable{},
+		proceed:   &widget.Clickable{},
+		cancel:    &widget.Clickable{},
+	}
+	for range r.issues {
+		p.repairs = append(p.repairs, &widget.Clickable{})
+	}
+	return p
+}
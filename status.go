@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// statusInterval is how often the StatusPage asks the client for its status
+const statusInterval = time.Second
+
+// StatusPage shows the connection state of the client and the recent
+// connection events, for diagnosing connection problems
+type StatusPage struct {
+	a *App
+	// c is the client whose status is shown, kept as a.c is cleared when
+	// katzen is locked
+	c       Messenger
+	back    *widget.Clickable
+	connect *widget.Clickable
+	list    *layout.List
+
+	// snapshot holds the rows asked from the client, which are collected
+	// in the background rather than every frame
+	l        *sync.Mutex
+	snapshot []detail
+}
+
+// ShowStatusClick is the event that opens the StatusPage
+type ShowStatusClick struct{}
+
+// details returns the rows shown for the connection
+func (p *StatusPage) details(gtx layout.Context) []detail {
+	rows := []detail{{"Connection", p.connection(gtx)}}
+	p.l.Lock()
+	rows = append(rows, p.snapshot...)
+	p.l.Unlock()
+
+	for _, e := range p.a.state.ConnectionEvents() {
+		value := "Disconnected"
+		if e.connected {
+			value = "Connected"
+		}
+		if e.err != nil {
+			value += ": " + e.err.Error()
+		}
+		rows = append(rows, detail{e.when.Format(time.TimeOnly), value})
+	}
+	return rows
+}
+
+// refresh collects the rows that are asked from the client
+func (p *StatusPage) refresh() {
+	rows := []detail{{"Tor", p.tor()}}
+	doc, err := p.c.GetPKIDocument()
+	if err != nil {
+		rows = append(rows, detail{"PKI epoch", err.Error()})
+	} else {
+		var gateways []string
+		for _, g := range doc.GatewayNodes {
+			gateways = append(gateways, g.Name)
+		}
+		// the client connects to one of the gateways at random, and
+		// does not tell which
+		rows = append(rows,
+			detail{"PKI epoch", fmt.Sprintf("%d", doc.Epoch)},
+			detail{"Available gateways", strings.Join(gateways, "\n")},
+		)
+	}
+
+	decoy := "Unknown"
+	if cfg := p.c.ClientConfig(); cfg != nil {
+		decoy = yesNo(cfg.Debug == nil || !cfg.Debug.DisableDecoyTraffic)
+	}
+	rows = append(rows, detail{"Decoy traffic", decoy})
+	rows = append(rows, detail{"Outbound queue", fmt.Sprintf("%d not sent", pendingMessages(p.c))})
+
+	p.l.Lock()
+	p.snapshot = rows
+	p.l.Unlock()
+}
+
+// connection returns the connection state
+func (p *StatusPage) connection(gtx layout.Context) string {
+	switch {
+	case p.a.state.Connected():
+		return "Connected"
+	case p.a.state.Connecting():
+		return "Connecting"
+	}
+	if at, ok := p.a.state.RetryAt(); ok {
+		return "Offline, " + retryText(gtx, at)
+	}
+	return "Offline"
+}
+
+// tor returns whether Tor is used, and its progress if connecting waits for
+// it
+func (p *StatusPage) tor() string {
+	if _, err := p.c.GetBlob("UseTor"); err != nil {
+		return "Not used"
+	}
+	cfg := p.c.ClientConfig()
+	if cfg == nil || cfg.UpstreamProxy == nil || cfg.UpstreamProxy.Address == "" {
+		return "Requested, but the client was started without a proxy"
+	}
+	msg := "Used through " + cfg.UpstreamProxy.Address
+	if t, ok := p.a.state.TorStatus(); ok {
+		msg += fmt.Sprintf("\nbootstrapping %d%%: %s", t.progress, t.summary)
+	}
+	return msg
+}
+
+// pendingMessages returns the number of outbound messages of c not yet sent
+func pendingMessages(c Messenger) int {
+	n := 0
+	for nickname := range c.GetContacts() {
+		for _, msg := range c.GetSortedConversation(nickname) {
+			if msg.Outbound && !msg.Sent {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Layout returns the connection details as a list of name and value rows
+func (p *StatusPage) Layout(gtx layout.Context) layout.Dimensions {
+	bg := Background{
+		Color: th.Bg,
+		Inset: layout.Inset{},
+	}
+	rows := p.details(gtx)
+	return bg.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.End}.Layout(gtx,
+			// topbar
+			layout.Rigid(func(gtx C) D {
+				return layout.Flex{Axis: layout.Horizontal, Spacing: layout.SpaceBetween, Alignment: layout.Baseline}.Layout(gtx,
+					layout.Rigid(button(th, p.back, backIcon).Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout),
+					layout.Rigid(material.H6(th, "Connection").Layout),
+					layout.Flexed(1, fill{th.Bg}.Layout))
+			}),
+			layout.Flexed(1, func(gtx C) D {
+				return p.list.Layout(gtx, len(rows), func(gtx C, i int) D {
+					return layout.Flex{Alignment: layout.Start}.Layout(gtx,
+						layout.Flexed(settingNameColumnWidth, func(gtx C) D {
+							return inset.Layout(gtx, material.Body2(th, rows[i].name).Layout)
+						}),
+						layout.Flexed(settingDetailsColumnWidth, func(gtx C) D {
+							return inset.Layout(gtx, material.Body2(th, rows[i].value).Layout)
+						}),
+					)
+				})
+			}),
+			layout.Rigid(func(gtx C) D {
+				label := "Connect"
+				if p.a.state.Connected() || p.a.state.Connecting() {
+					label = "Disconnect"
+				}
+				return inset.Layout(gtx, material.Button(th, p.connect, label).Layout)
+			}),
+		)
+	})
+}
+
+func (p *StatusPage) Event(gtx layout.Context) interface{} {
+	if p.back.Clicked(gtx) {
+		return BackEvent{}
+	}
+	if p.connect.Clicked(gtx) {
+		if !p.a.state.Connected() && !p.a.state.Connecting() {
+			return OnlineClick{}
+		}
+		return OfflineClick{}
+	}
+	return nil
+}
+
+// Start refreshes the status until the page is stopped, which it is before
+// the client is shut down when katzen is locked, or the client halts
+func (p *StatusPage) Start(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			p.refresh()
+			p.a.w.Invalidate()
+			select {
+			case <-stop:
+				return
+			case <-p.c.HaltCh():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func init() {
+	handle(func(a *App, _ ShowStatusClick) interface{} {
+		return Push{newStatusPage(a)}
+	})
+}
+
+func newStatusPage(a *App) *StatusPage {
+	return &StatusPage{
+		a:       a,
+		c:       a.c,
+		back:    &widget.Clickable{},
+		connect: &widget.Clickable{},
+		list:    &layout.List{Axis: layout.Vertical},
+		l:       new(sync.Mutex),
+	}
+}
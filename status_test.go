@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gioui.org/layout"
+	"github.com/katzenpost/katzenpost/client/config"
+	"github.com/katzenpost/katzenpost/core/pki"
+)
+
+func TestStatusPage(t *testing.T) {
+	f := newPopulatedMessenger()
+	f.contacts["alice"].LastMessage.Sent = false
+	f.online = true
+	f.doc = &pki.Document{
+		Epoch:        123456,
+		GatewayNodes: []*pki.MixDescriptor{{Name: "gateway1"}, {Name: "gateway2"}},
+	}
+	cfg, err := config.Load(cfgWithTor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.UpstreamProxy = proxySettings{}.upstreamProxy("127.0.0.1:9050")
+	f.cfg = cfg
+	f.AddBlob("UseTor", []byte{1})
+
+	var home *HomePage
+	h := newPageHarness(t, f, func(a *App) Page {
+		home = newHomePage(a)
+		home.UpdateContacts()
+		return home
+	})
+	when := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	h.a.state.AddConnectionEvent(connectionEvent{when: when, err: errors.New("dial tcp: i/o timeout")})
+	h.a.state.AddConnectionEvent(connectionEvent{when: when.Add(time.Minute), connected: true})
+	h.a.state.SetConnected(true)
+
+	home.connect.Click()
+	h.frames(2)
+	p, ok := h.current().(*StatusPage)
+	if !ok {
+		t.Fatalf("current page is %T after clicking the connect icon, want *StatusPage", h.current())
+	}
+	// the rows asked from the client are refreshed in the background
+	p.refresh()
+	rows := make(map[string]string)
+	for _, r := range p.details(layout.Context{Now: time.Now()}) {
+		rows[r.name] = r.value
+	}
+	for name, want := range map[string]string{
+		"Connection":         "Connected",
+		"Tor":                "Used through 127.0.0.1:9050",
+		"PKI epoch":          "123456",
+		"Available gateways": "gateway1\ngateway2",
+		"Decoy traffic":      "Yes",
+		"Outbound queue":     "1 not sent",
+		"12:30:00":           "Disconnected: dial tcp: i/o timeout",
+	} {
+		if rows[name] != want {
+			t.Errorf("%s is %q, want %q", name, rows[name], want)
+		}
+	}
+	h.screenshot("status")
+
+	p.connect.Click()
+	h.frames(2)
+	if h.a.state.Connected() {
+		t.Error("still connected after disconnecting")
+	}
+
+	// the page keeps the client it shows, which locking drops from the app
+	h.a.stack.Push(newStatusPage(h.a))
+	h.frames(2)
+	h.a.lock()
+	p.refresh()
+	h.frames(2)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+// The strength of a passphrase is estimated from the number of guesses an
+// attacker who knows common passwords, words and keyboard patterns would
+// need, in the manner of zxcvbn: the passphrase is split into the sequence
+// of patterns that is cheapest to guess, and the bits of each part are
+// summed. The passphrase is never copied to a string, which could not be
+// wiped.
+
+var (
+	//go:embed common_passwords.txt
+	commonPasswordList string
+	//go:embed common_words.txt
+	commonWordList string
+
+	// dictionaries rank the common passwords and words by frequency
+	dictionaries = []dictionary{
+		newDictionary("common password", commonPasswordList),
+		newDictionary("common word", commonWordList),
+	}
+
+	errWeakPassphrase = errors.New("passphrase is too weak")
+
+	// strengthNames are the labels of the scores
+	strengthNames = []string{"Very weak", "Weak", "Fair", "Strong", "Very strong"}
+
+	// strengthColors are the colors of the meter for each score
+	strengthColors = []color.NRGBA{rgb(0xcc3333), rgb(0xdd7722), rgb(0xddbb22), rgb(0x88bb33), rgb(0x33aa55)}
+)
+
+// scoreBits are the bits of guessing entropy needed for each score above 0.
+// The statefile key is stretched with argon2, which slows down offline
+// guessing, but not enough to make short passphrases safe.
+var scoreBits = []float64{20, 30, 40, 50}
+
+// leet maps the common character substitutions to the letters they replace
+var leet = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// keyboardRows is the layout used for keyboard walk detection, with the
+// offset of each row in key widths
+var keyboardRows = []struct {
+	keys, shifted string
+	offset        float64
+}{
+	{"`1234567890-=", "~!@#$%^&*()_+", 0},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|", 1.5},
+	{"asdfghjkl;'", "ASDFGHJKL:\"", 1.75},
+	{"zxcvbnm,./", "ZXCVBNM<>?", 2.25},
+}
+
+type keyPosition struct {
+	x, y    float64
+	shifted bool
+}
+
+var keyboard = func() map[rune]keyPosition {
+	m := make(map[rune]keyPosition)
+	for y, row := range keyboardRows {
+		for i, r := range row.keys {
+			m[r] = keyPosition{x: row.offset + float64(i), y: float64(y)}
+		}
+		for i, r := range row.shifted {
+			m[r] = keyPosition{x: row.offset + float64(i), y: float64(y), shifted: true}
+		}
+	}
+	return m
+}()
+
+type dictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+func newDictionary(name, list string) dictionary {
+	d := dictionary{name: name, ranks: make(map[string]int)}
+	for _, w := range strings.Fields(list) {
+		if _, ok := d.ranks[w]; !ok {
+			d.ranks[w] = len(d.ranks) + 1
+		}
+	}
+	return d
+}
+
+// passphraseStrength is the estimated strength of a passphrase
+type passphraseStrength struct {
+	bits  float64
+	score int
+	// warning describes the weakest pattern found, if the score is low
+	warning string
+}
+
+// String returns the label of the score
+func (s passphraseStrength) String() string {
+	return strengthNames[s.score]
+}
+
+// match is a part of the passphrase guessable as a pattern
+type match struct {
+	i, j    int
+	bits    float64
+	pattern string
+}
+
+// estimateStrength returns the strength of passphrase
+func estimateStrength(passphrase []byte) passphraseStrength {
+	pw := bytes.Runes(passphrase)
+	defer clear(pw)
+	return estimateRunes(pw)
+}
+
+// estimateRunes returns the strength of the passphrase pw
+func estimateRunes(pw []rune) passphraseStrength {
+	n := len(pw)
+	if n == 0 {
+		return passphraseStrength{warning: "the passphrase is empty"}
+	}
+
+	matches := findMatches(pw)
+	charBits := math.Log2(float64(cardinality(pw)))
+
+	// best[j] is the cheapest guess of pw[:j], reached with the match
+	// in last[j], or by guessing a single character if last[j] is nil
+	best := make([]float64, n+1)
+	last := make([]*match, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = best[j-1] + charBits
+		last[j] = nil
+		for k := range matches {
+			m := &matches[k]
+			if m.j == j && best[m.i]+m.bits < best[j] {
+				best[j] = best[m.i] + m.bits
+				last[j] = m
+			}
+		}
+	}
+
+	// the weakest pattern is the one covering the most characters
+	s := passphraseStrength{bits: best[n]}
+	covered := 0
+	for j := n; j > 0; {
+		m := last[j]
+		if m == nil {
+			j--
+			continue
+		}
+		if m.j-m.i > covered {
+			covered, s.warning = m.j-m.i, m.pattern
+		}
+		j = m.i
+	}
+	for s.score < len(scoreBits) && s.bits >= scoreBits[s.score] {
+		s.score++
+	}
+	switch {
+	case s.score >= 3:
+		s.warning = ""
+	case s.warning == "" && n < 12:
+		s.warning = "the passphrase is short"
+	case s.warning == "":
+		s.warning = "add more words or characters"
+	}
+	return s
+}
+
+// cardinality returns the size of the character classes used in pw
+func cardinality(pw []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range pw {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	c := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			c += class.size
+		}
+	}
+	return c
+}
+
+// findMatches returns the patterns found in every substring of pw
+func findMatches(pw []rune) []match {
+	var matches []match
+	n := len(pw)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j <= n; j++ {
+			sub := pw[i:j]
+			if bits, pattern, ok := dictionaryBits(sub); ok {
+				matches = append(matches, match{i, j, bits, pattern})
+			}
+			if j-i < 3 {
+				continue
+			}
+			if bits, ok := sequenceBits(sub); ok {
+				matches = append(matches, match{i, j, bits, "sequences like abc or 6543 are easy to guess"})
+			}
+			if bits, ok := walkBits(sub); ok {
+				matches = append(matches, match{i, j, bits, "keyboard patterns like qwerty are easy to guess"})
+			}
+			if bits, ok := repeatBits(sub); ok {
+				matches = append(matches, match{i, j, bits, "repeats like aaa or abcabc are easy to guess"})
+			}
+		}
+	}
+	return matches
+}
+
+// dictionaryBits returns the bits of sub as a dictionary word, allowing for
+// capitals, common substitutions and reversal
+func dictionaryBits(sub []rune) (float64, string, bool) {
+	word := make([]rune, len(sub))
+	for i, r := range sub {
+		word[i] = unicode.ToLower(r)
+	}
+	reversed := reverse(word)
+	unleeted, subs := unleet(word)
+	defer clear(word)
+	defer clear(reversed)
+	defer clear(unleeted)
+	variants := []struct {
+		word  []rune
+		extra float64
+	}{{word, 0}, {reversed, 1}}
+	if subs > 0 {
+		variants = append(variants, struct {
+			word  []rune
+			extra float64
+		}{unleeted, float64(subs)})
+	}
+	// the lookup with a converted []byte does not copy it to a string
+	buf := make([]byte, 0, len(sub)*utf8.UTFMax)
+	defer clear(buf[:cap(buf)])
+	for _, d := range dictionaries {
+		for _, v := range variants {
+			buf = buf[:0]
+			for _, r := range v.word {
+				buf = utf8.AppendRune(buf, r)
+			}
+			if rank, ok := d.ranks[string(buf)]; ok {
+				bits := math.Log2(float64(rank)) + v.extra + caseBits(sub)
+				return math.Max(bits, 1), d.name + "s are easy to guess", true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// caseBits returns the bits added by the capitalization of sub
+func caseBits(sub []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range sub {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case lower == 0, upper == 1 && unicode.IsUpper(sub[0]):
+		// all capitals or a capitalized word
+		return 1
+	default:
+		return math.Min(float64(upper), float64(lower)) * 2
+	}
+}
+
+// unleet returns a copy of word with common substitutions replaced and their
+// count
+func unleet(word []rune) ([]rune, int) {
+	subs := 0
+	out := make([]rune, len(word))
+	for i, r := range word {
+		if l, ok := leet[r]; ok {
+			r = l
+			subs++
+		}
+		out[i] = r
+	}
+	return out, subs
+}
+
+// reverse returns a reversed copy of word
+func reverse(word []rune) []rune {
+	out := make([]rune, len(word))
+	for i, r := range word {
+		out[len(word)-1-i] = r
+	}
+	return out
+}
+
+// sequenceBits returns the bits of sub if it is a run of consecutive
+// characters, such as abcd or 9753
+func sequenceBits(sub []rune) (float64, bool) {
+	delta := sub[1] - sub[0]
+	if delta == 0 || delta > 2 || delta < -2 {
+		return 0, false
+	}
+	for i := 2; i < len(sub); i++ {
+		if sub[i]-sub[i-1] != delta {
+			return 0, false
+		}
+	}
+	start := 26.0
+	switch {
+	case unicode.IsDigit(sub[0]):
+		start = 10
+	case !unicode.IsLetter(sub[0]):
+		start = 33
+	}
+	if sub[0] == 'a' || sub[0] == 'A' || sub[0] == '0' || sub[0] == '1' {
+		start = 2
+	}
+	bits := math.Log2(start) + math.Log2(float64(len(sub)))
+	if delta < 0 {
+		bits++
+	}
+	return bits, true
+}
+
+// walkBits returns the bits of sub if it is a path of adjacent keys, such
+// as qwerty or 1qaz2wsx
+func walkBits(sub []rune) (float64, bool) {
+	turns, shifted := 0, 0
+	var dx, dy float64
+	for i := 1; i < len(sub); i++ {
+		a, ok := keyboard[sub[i-1]]
+		b, ok2 := keyboard[sub[i]]
+		if !ok || !ok2 || sub[i] == sub[i-1] {
+			return 0, false
+		}
+		x, y := b.x-a.x, b.y-a.y
+		if math.Abs(x) > 1 || math.Abs(y) > 1 {
+			return 0, false
+		}
+		if i > 1 && (x != dx || y != dy) {
+			turns++
+		}
+		dx, dy = x, y
+		if b.shifted {
+			shifted++
+		}
+	}
+	if keyboard[sub[0]].shifted {
+		shifted++
+	}
+	// starting key, length, and the direction taken at each turn
+	bits := math.Log2(float64(len(keyboard)/2)) + math.Log2(float64(len(sub))) + float64(turns)*2
+	if shifted > 0 && shifted < len(sub) {
+		bits += math.Min(float64(shifted), float64(len(sub)-shifted))
+	} else if shifted > 0 {
+		bits++
+	}
+	return bits, true
+}
+
+// repeatBits returns the bits of sub if it is a shorter string repeated,
+// such as aaaa or abcabc
+func repeatBits(sub []rune) (float64, bool) {
+	n := len(sub)
+	for size := 1; size <= n/2; size++ {
+		if n%size != 0 {
+			continue
+		}
+		base := sub[:size]
+		repeated := true
+		for i := size; i < n && repeated; i++ {
+			repeated = sub[i] == base[i%size]
+		}
+		if repeated {
+			baseBits := estimateRunes(base).bits
+			return baseBits + math.Log2(float64(n/size)), true
+		}
+	}
+	return 0, false
+}
+
+// checkNewPassphrase returns an error if passphrase and the confirmation
+// differ or if the passphrase does not meet the minimum score
+func checkNewPassphrase(passphrase, confirmation []byte) error {
+	if !bytes.Equal(passphrase, confirmation) {
+		return errPassphraseMismatch
+	}
+	return checkPassphraseStrength(passphrase)
+}
+
+// checkMinScore returns an error if score is not one of the strength scores
+func checkMinScore(score int) error {
+	if score < 0 || score >= len(strengthNames) {
+		return fmt.Errorf("-min-score must be between 0 and %d, not %d", len(strengthNames)-1, score)
+	}
+	return nil
+}
+
+// checkPassphraseStrength returns an error if passphrase does not meet the
+// minimum score
+func checkPassphraseStrength(passphrase []byte) error {
+	if s := estimateStrength(passphrase); s.score < *minScore {
+		return fmt.Errorf("%w: %s", errWeakPassphrase, s.warning)
+	}
+	return nil
+}
+
+// editorStrength returns the strength of the passphrase typed in ed
+func editorStrength(ed *widget.Editor) passphraseStrength {
+	pass, err := editorSecret(ed)
+	if err != nil {
+		return estimateStrength(nil)
+	}
+	defer pass.destroy()
+	return estimateStrength(pass.Bytes())
+}
+
+// readNewPassphrase returns the new passphrase typed in ed, if it is
+// confirmed by the one typed in confirm and meets the minimum score
+func readNewPassphrase(ed, confirm *widget.Editor) (*secureBuffer, error) {
+	pass, err := editorSecret(ed)
+	if err != nil {
+		return nil, err
+	}
+	c, err := editorSecret(confirm)
+	if err == nil {
+		err = checkNewPassphrase(pass.Bytes(), c.Bytes())
+		c.destroy()
+	}
+	if err != nil {
+		pass.destroy()
+		return nil, err
+	}
+	return pass, nil
+}
+
+// layoutStrength lays out a meter showing the strength of a passphrase
+func layoutStrength(gtx C, s passphraseStrength) D {
+	return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			bar := material.ProgressBar(th, float32(s.score+1)/float32(len(strengthNames)))
+			bar.Color = strengthColors[s.score]
+			return bar.Layout(gtx)
+		}),
+		layout.Rigid(func(gtx C) D {
+			label := s.String()
+			if s.warning != "" {
+				label += ": " + s.warning
+			}
+			in := layout.Inset{Top: unit.Dp(4)}
+			return in.Layout(gtx, material.Caption(th, label).Layout)
+		}),
+	)
+}
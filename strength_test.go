@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	for _, tc := range []struct {
+		passphrase string
+		max        int
+		warning    string
+	}{
+		{"password", 0, "common passwords are easy to guess"},
+		{"P@ssw0rd", 0, "common passwords are easy to guess"},
+		{"qwertyuiop", 0, ""},
+		{"poiuytrewq", 0, ""},
+		{"zxcvbnmasdfghjkl", 0, ""},
+		{"aaaaaaaaaaaa", 0, "repeats like aaa or abcabc are easy to guess"},
+		{"abcdefghijklmnop", 0, "sequences like abc or 6543 are easy to guess"},
+		{"abcdefgh12345678", 1, ""},
+		{"Monkey", 0, ""},
+		{"dragonmonkey", 1, ""},
+		{"correct horse", 1, ""},
+	} {
+		s := estimateStrength([]byte(tc.passphrase))
+		if s.score > tc.max {
+			t.Errorf("%q scored %d (%.1f bits), want at most %d", tc.passphrase, s.score, s.bits, tc.max)
+		}
+		if tc.warning != "" && s.warning != tc.warning {
+			t.Errorf("%q has warning %q, want %q", tc.passphrase, s.warning, tc.warning)
+		}
+	}
+
+	for _, pw := range []string{
+		"correct horse battery staple",
+		"xK9#mQ2v!Lp7",
+		"w7tqz ukbnre pfoaxl",
+	} {
+		if s := estimateStrength([]byte(pw)); s.score < 3 || s.warning != "" {
+			t.Errorf("%q scored %d (%.1f bits) with warning %q, want at least 3", pw, s.score, s.bits, s.warning)
+		}
+	}
+
+	// every pattern found makes the passphrase weaker than random
+	// characters
+	if estimateStrength([]byte("qwertyasdf")).bits >= estimateStrength([]byte("qjwhtvasnf")).bits {
+		t.Error("keyboard walk is as strong as random characters")
+	}
+	if estimateStrength(nil).score != 0 {
+		t.Error("empty passphrase scored above 0")
+	}
+}
+
+func TestCheckNewPassphrase(t *testing.T) {
+	if err := checkNewPassphrase([]byte("correct horse battery staple"), []byte("correct horse battery stapel")); err != errPassphraseMismatch {
+		t.Errorf("got %v for mismatched passphrases, want errPassphraseMismatch", err)
+	}
+	if err := checkNewPassphrase([]byte("letmein1"), []byte("letmein1")); !errors.Is(err, errWeakPassphrase) {
+		t.Errorf("got %v for a weak passphrase, want errWeakPassphrase", err)
+	}
+	if err := checkNewPassphrase([]byte("correct horse battery staple"), []byte("correct horse battery staple")); err != nil {
+		t.Error(err)
+	}
+
+	prev := *minScore
+	defer func() { *minScore = prev }()
+	*minScore = 0
+	if err := checkNewPassphrase([]byte("letmein1"), []byte("letmein1")); err != nil {
+		t.Errorf("got %v with no minimum score", err)
+	}
+}
+
+func TestCheckMinScore(t *testing.T) {
+	for score := 0; score <= 4; score++ {
+		if err := checkMinScore(score); err != nil {
+			t.Errorf("score %d: %v", score, err)
+		}
+	}
+	for _, score := range []int{-1, 5, 100} {
+		if err := checkMinScore(score); err == nil {
+			t.Errorf("score %d was accepted", score)
+		}
+	}
+}
+
+func TestSignInFirstRun(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := useStateFile(t)
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		return newSignInPage(a)
+	})
+	p := h.current().(*signInPage)
+	if !p.firstRun {
+		t.Fatal("sign in page does not create a statefile when there is none")
+	}
+
+	h.typeText("hunter22")
+	if p.strength.score != 0 {
+		t.Errorf("meter shows a score of %d for a weak passphrase", p.strength.score)
+	}
+	p.confirm.SetText("hunter22")
+	p.submit.Click()
+	h.frames(2)
+	if h.current() != Page(p) || p.errMsg == "" {
+		t.Fatalf("sign in started with a weak passphrase")
+	}
+	h.screenshot("firstrun")
+
+	p.password.SetText("correct horse battery staple")
+	p.confirm.SetText("correct horse battery staple!")
+	p.submit.Click()
+	h.frames(2)
+	if h.current() != Page(p) || p.errMsg != errPassphraseMismatch.Error() {
+		t.Fatalf("got %q for mismatched passphrases", p.errMsg)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("statefile was created")
+	}
+
+	// with a statefile, the passphrase is only entered
+	writeTestState(t, path, []byte("hunter22"))
+	if newSignInPage(h.a).firstRun {
+		t.Error("sign in page creates a statefile when there is one")
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gioui.org/x/notify"
+	"github.com/katzenpost/hpqc/rand"
+)
+
+// The Tor control protocol is described in control-spec.txt of the Tor
+// specifications. Only the commands needed to wait for Tor to bootstrap are
+// implemented.
+
+// torCookieLen is the length of the authentication cookie, and of the
+// nonces of the SAFECOOKIE challenge
+const torCookieLen = 32
+
+// The keys of the SAFECOOKIE hashes, from control-spec.txt
+const (
+	torServerHashKey     = "Tor safe cookie authentication server-to-controller hash"
+	torControllerHashKey = "Tor safe cookie authentication controller-to-server hash"
+)
+
+var (
+	errTorControlClosed = errors.New("the Tor control connection was closed")
+	errTorControlAuth   = errors.New("the Tor control port has no supported authentication method")
+	errTorCookieRemote  = errors.New("the Tor control port is not on this computer, and needs a password")
+	errTorServerHash    = errors.New("the Tor control port does not know the cookie it asked for")
+)
+
+// torReply is a reply to a command, or an asynchronous event, with the text
+// of each of its lines
+type torReply struct {
+	code  int
+	lines []string
+}
+
+// torControlError is a reply with a status other than 250 OK
+type torControlError torReply
+
+func (e *torControlError) Error() string {
+	return fmt.Sprintf("Tor control port: %d %s", e.code, strings.Join(e.lines, " "))
+}
+
+// torBootstrap is the bootstrap phase of Tor
+type torBootstrap struct {
+	progress int
+	tag      string
+	summary  string
+}
+
+// torEvent is an event received after SETEVENTS. Either bootstrap or
+// circuit is set.
+type torEvent struct {
+	bootstrap *torBootstrap
+	// circuit is the status of a circuit, such as BUILT or FAILED
+	circuit string
+}
+
+// torControl is a connection to the control port of Tor
+type torControl struct {
+	conn    net.Conn
+	replies chan torReply
+	events  chan torEvent
+	// mu is held while a command waits for its reply
+	mu sync.Mutex
+	// done is closed when the connection fails or is closed
+	done chan struct{}
+	err  error
+	// closing is closed by Close
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// dialTorControl connects to the control port at address
+func dialTorControl(ctx context.Context, address string) (*torControl, error) {
+	d := net.Dialer{Timeout: proxyProbeTimeout}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c := &torControl{
+		conn:    conn,
+		replies: make(chan torReply),
+		events:  make(chan torEvent, 16),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// read dispatches the replies and events received until the connection fails
+func (c *torControl) read() {
+	r := bufio.NewReader(c.conn)
+	var err error
+	for {
+		var reply torReply
+		if reply, err = readTorReply(r); err != nil {
+			break
+		}
+		if reply.code == 650 {
+			if e, ok := parseTorEvent(reply); ok {
+				select {
+				case c.events <- e:
+				default:
+					// events are only shown, and dropped if not
+					// read in time
+				}
+			}
+			continue
+		}
+		select {
+		case c.replies <- reply:
+		case <-c.closing:
+		}
+	}
+	c.err = err
+	close(c.done)
+}
+
+// readTorReply reads the lines of a reply. Data lines, after a line with a +
+// separator, are joined to the line.
+func readTorReply(r *bufio.Reader) (torReply, error) {
+	var reply torReply
+	for {
+		line, err := readTorLine(r)
+		if err != nil {
+			return reply, err
+		}
+		if len(line) < 4 {
+			return reply, fmt.Errorf("Tor control port: malformed reply %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return reply, fmt.Errorf("Tor control port: malformed reply %q", line)
+		}
+		reply.code = code
+		text := line[4:]
+		switch line[3] {
+		case ' ':
+			reply.lines = append(reply.lines, text)
+			return reply, nil
+		case '-':
+			reply.lines = append(reply.lines, text)
+		case '+':
+			for {
+				data, err := readTorLine(r)
+				if err != nil {
+					return reply, err
+				}
+				if data == "." {
+					break
+				}
+				text += "\n" + strings.TrimPrefix(data, ".")
+			}
+			reply.lines = append(reply.lines, text)
+		default:
+			return reply, fmt.Errorf("Tor control port: malformed reply %q", line)
+		}
+	}
+}
+
+func readTorLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends cmd and returns the reply, or a *torControlError if it is
+// not 250 OK
+func (c *torControl) command(ctx context.Context, cmd string) (torReply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the write is interrupted once ctx is done
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { c.conn.SetWriteDeadline(time.Now()) })
+	_, err := c.conn.Write([]byte(cmd + "\r\n"))
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return torReply{}, ctx.Err()
+		}
+		return torReply{}, err
+	}
+	select {
+	case reply := <-c.replies:
+		if reply.code != 250 {
+			e := torControlError(reply)
+			return reply, &e
+		}
+		return reply, nil
+	case <-c.done:
+		if c.err != nil {
+			return torReply{}, c.err
+		}
+		return torReply{}, errTorControlClosed
+	case <-ctx.Done():
+		return torReply{}, ctx.Err()
+	}
+}
+
+// authenticate authenticates with password if it is set and Tor accepts
+// one, or else with the cookie file or without credentials. The cookie is
+// only used with SAFECOOKIE, which proves that the control port knows it,
+// and only with a control port on this computer.
+func (c *torControl) authenticate(ctx context.Context, password string) error {
+	reply, err := c.command(ctx, "PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	methods := make(map[string]bool)
+	var cookieFile string
+	for _, line := range reply.lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		kw := parseTorKeywords(strings.TrimPrefix(line, "AUTH "))
+		for _, m := range strings.Split(kw["METHODS"], ",") {
+			methods[m] = true
+		}
+		cookieFile = kw["COOKIEFILE"]
+	}
+
+	var cmd string
+	switch {
+	case methods["NULL"]:
+		cmd = "AUTHENTICATE"
+	case password != "" && methods["HASHEDPASSWORD"]:
+		cmd = "AUTHENTICATE " + quoteTorString(password)
+	case methods["SAFECOOKIE"] && cookieFile != "":
+		if !isLoopback(c.conn.RemoteAddr()) {
+			return errTorCookieRemote
+		}
+		hash, err := c.safeCookie(ctx, cookieFile)
+		if err != nil {
+			return err
+		}
+		cmd = "AUTHENTICATE " + hex.EncodeToString(hash)
+	case methods["HASHEDPASSWORD"]:
+		return errors.New("the Tor control port needs a password")
+	default:
+		return errTorControlAuth
+	}
+	_, err = c.command(ctx, cmd)
+	return err
+}
+
+// safeCookie answers the SAFECOOKIE challenge with the cookie in cookieFile,
+// and returns the hash to authenticate with once the control port has shown
+// that it knows the cookie
+func (c *torControl) safeCookie(ctx context.Context, cookieFile string) ([]byte, error) {
+	cookie, err := os.ReadFile(cookieFile)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(cookie)
+	if len(cookie) != torCookieLen {
+		return nil, fmt.Errorf("the Tor cookie file %s is %d bytes long", cookieFile, len(cookie))
+	}
+	clientNonce := make([]byte, torCookieLen)
+	if _, err := rand.Reader.Read(clientNonce); err != nil {
+		return nil, err
+	}
+	reply, err := c.command(ctx, "AUTHCHALLENGE SAFECOOKIE "+hex.EncodeToString(clientNonce))
+	if err != nil {
+		return nil, err
+	}
+	line, _ := strings.CutPrefix(reply.lines[0], "AUTHCHALLENGE ")
+	kw := parseTorKeywords(line)
+	serverHash, err := hex.DecodeString(kw["SERVERHASH"])
+	if err != nil {
+		return nil, fmt.Errorf("Tor control port: malformed challenge %q", reply.lines[0])
+	}
+	serverNonce, err := hex.DecodeString(kw["SERVERNONCE"])
+	if err != nil || len(serverNonce) != torCookieLen {
+		return nil, fmt.Errorf("Tor control port: malformed challenge %q", reply.lines[0])
+	}
+	if !hmac.Equal(serverHash, torCookieHash(torServerHashKey, cookie, clientNonce, serverNonce)) {
+		return nil, errTorServerHash
+	}
+	return torCookieHash(torControllerHashKey, cookie, clientNonce, serverNonce), nil
+}
+
+// torCookieHash returns a SAFECOOKIE hash of the cookie and nonces
+func torCookieHash(key string, cookie, clientNonce, serverNonce []byte) []byte {
+	m := hmac.New(sha256.New, []byte(key))
+	m.Write(cookie)
+	m.Write(clientNonce)
+	m.Write(serverNonce)
+	return m.Sum(nil)
+}
+
+// isLoopback returns true if addr is a loopback address
+func isLoopback(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	return ok && a.IP.IsLoopback()
+}
+
+// bootstrap returns the current bootstrap phase
+func (c *torControl) bootstrap(ctx context.Context) (torBootstrap, error) {
+	reply, err := c.command(ctx, "GETINFO status/bootstrap-phase")
+	if err != nil {
+		return torBootstrap{}, err
+	}
+	for _, line := range reply.lines {
+		if v, ok := strings.CutPrefix(line, "status/bootstrap-phase="); ok {
+			if b, ok := parseTorBootstrap(v); ok {
+				return b, nil
+			}
+		}
+	}
+	return torBootstrap{}, fmt.Errorf("Tor control port: no bootstrap phase in %q", reply.lines)
+}
+
+// watch asks for the bootstrap and circuit events
+func (c *torControl) watch(ctx context.Context) error {
+	_, err := c.command(ctx, "SETEVENTS STATUS_CLIENT CIRC")
+	return err
+}
+
+// Events returns the events asked for with watch
+func (c *torControl) Events() <-chan torEvent {
+	return c.events
+}
+
+// Done is closed when the connection fails or is closed
+func (c *torControl) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *torControl) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+	return c.conn.Close()
+}
+
+// parseTorEvent returns the bootstrap progress or circuit status in an
+// asynchronous reply
+func parseTorEvent(reply torReply) (torEvent, bool) {
+	if len(reply.lines) == 0 {
+		return torEvent{}, false
+	}
+	fields := strings.Fields(reply.lines[0])
+	switch {
+	case len(fields) >= 3 && fields[0] == "CIRC":
+		return torEvent{circuit: fields[2]}, true
+	case len(fields) >= 3 && fields[0] == "STATUS_CLIENT":
+		if b, ok := parseTorBootstrap(strings.Join(fields[1:], " ")); ok {
+			return torEvent{bootstrap: &b}, true
+		}
+	}
+	return torEvent{}, false
+}
+
+// parseTorBootstrap parses a bootstrap status such as
+// NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done"
+func parseTorBootstrap(s string) (torBootstrap, bool) {
+	_, args, ok := strings.Cut(s, "BOOTSTRAP ")
+	if !ok {
+		return torBootstrap{}, false
+	}
+	kw := parseTorKeywords(args)
+	progress, err := strconv.Atoi(kw["PROGRESS"])
+	if err != nil {
+		return torBootstrap{}, false
+	}
+	return torBootstrap{progress: progress, tag: kw["TAG"], summary: kw["SUMMARY"]}, true
+}
+
+// parseTorKeywords parses KEY=VALUE arguments, where values may be quoted
+func parseTorKeywords(s string) map[string]string {
+	kw := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok || strings.Contains(key, " ") {
+			// a keyword without a value
+			_, s, _ = strings.Cut(s, " ")
+			continue
+		}
+		var value strings.Builder
+		if strings.HasPrefix(rest, "\"") {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			v, r, _ := strings.Cut(rest, " ")
+			value.WriteString(v)
+			s = r
+		}
+		kw[key] = value.String()
+	}
+	return kw
+}
+
+// quoteTorString returns s as a quoted string
+func quoteTorString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", `\r`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// torStatus is the progress of Tor shown while connecting waits for it
+type torStatus struct {
+	progress int
+	summary  string
+	// circuits is the number of circuits built since watching
+	circuits int
+}
+
+// waitForTor reports the bootstrap progress of the Tor with the control port
+// at address until it has bootstrapped. It returns an error if the control
+// port cannot be used, or when ctx is done.
+func waitForTor(ctx context.Context, address, password string, report func(torStatus)) error {
+	c, err := dialTorControl(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.authenticate(ctx, password); err != nil {
+		return err
+	}
+	// events are asked for first, so that none are missed between
+	// reading the phase and watching
+	if err := c.watch(ctx); err != nil {
+		return err
+	}
+	b, err := c.bootstrap(ctx)
+	if err != nil {
+		return err
+	}
+	status := torStatus{progress: b.progress, summary: b.summary}
+	report(status)
+	for status.progress < 100 {
+		select {
+		case e := <-c.Events():
+			switch {
+			case e.bootstrap != nil:
+				status.progress, status.summary = e.bootstrap.progress, e.bootstrap.summary
+			case e.circuit == "BUILT":
+				status.circuits++
+			default:
+				continue
+			}
+			report(status)
+		case <-c.Done():
+			if c.err != nil {
+				return c.err
+			}
+			return errTorControlClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func warnTorControl(err error) {
+	go func() {
+		if n, err := notify.Push("Failure", fmt.Sprintf("The Tor control port cannot be used, %s. Connecting without waiting for Tor.", err)); err == nil {
+			<-time.After(notificationTimeout)
+			n.Cancel()
+		}
+	}()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTor is a Tor control port that reports phases of bootstrap progress,
+// the first in reply to GETINFO and each of the rest as an event after a
+// value is received from next, preceded by a circuit event
+type fakeTor struct {
+	t        *testing.T
+	methods  string
+	cookie   string
+	password string
+	// forged is set to answer the SAFECOOKIE challenge without the cookie
+	forged bool
+	phases []int
+	next   chan struct{}
+}
+
+// serve accepts connections to the fake control port and returns its address
+func (f *fakeTor) serve() string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f *fakeTor) handle(conn net.Conn) {
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	r := bufio.NewReader(conn)
+	// hash is the SAFECOOKIE hash expected from the controller
+	var hash string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch cmd {
+		case "PROTOCOLINFO":
+			fmt.Fprintf(conn, "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=%s COOKIEFILE=%s\r\n250-VERSION Tor=\"0.4.8.9\"\r\n250 OK\r\n",
+				f.methods, quoteTorString(f.cookie))
+		case "AUTHCHALLENGE":
+			serverNonce := make([]byte, torCookieLen)
+			clientNonce, err := hex.DecodeString(strings.TrimPrefix(arg, "SAFECOOKIE "))
+			cookie, _ := os.ReadFile(f.cookie)
+			if err != nil || f.forged {
+				cookie = make([]byte, torCookieLen)
+				cookie[0] = 1
+			}
+			hash = hex.EncodeToString(torCookieHash(torControllerHashKey, cookie, clientNonce, serverNonce))
+			fmt.Fprintf(conn, "250 AUTHCHALLENGE SERVERHASH=%X SERVERNONCE=%X\r\n",
+				torCookieHash(torServerHashKey, cookie, clientNonce, serverNonce), serverNonce)
+		case "AUTHENTICATE":
+			if !f.authenticated(arg, hash) {
+				fmt.Fprint(conn, "515 Authentication failed: Password did not match HashedControlPassword value from configuration\r\n")
+				return
+			}
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "SETEVENTS":
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "GETINFO":
+			fmt.Fprintf(conn, "250-status/bootstrap-phase=NOTICE BOOTSTRAP %s\r\n250 OK\r\n", f.phase(0))
+			for i := 1; i < len(f.phases); i++ {
+				select {
+				case <-f.next:
+				case <-stop:
+					return
+				}
+				fmt.Fprintf(conn, "650 CIRC %d BUILT $AB~relay PURPOSE=GENERAL\r\n", i)
+				fmt.Fprintf(conn, "650 STATUS_CLIENT NOTICE BOOTSTRAP %s\r\n", f.phase(i))
+			}
+		default:
+			fmt.Fprintf(conn, "510 Unrecognized command %q\r\n", cmd)
+		}
+	}
+}
+
+func (f *fakeTor) phase(i int) string {
+	return fmt.Sprintf("PROGRESS=%d TAG=phase%d SUMMARY=\"Phase \\\"%d\\\"\"", f.phases[i], i, i)
+}
+
+func (f *fakeTor) authenticated(arg, hash string) bool {
+	switch {
+	case strings.Contains(f.methods, "NULL"):
+		return true
+	case strings.HasPrefix(arg, `"`):
+		return arg == quoteTorString(f.password)
+	default:
+		return hash != "" && arg == hash
+	}
+}
+
+// writeCookie writes a Tor authentication cookie and returns its path
+func writeCookie(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "control_auth_cookie")
+	if err := os.WriteFile(path, make([]byte, n), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTorControlAuthenticate(t *testing.T) {
+	cookie := writeCookie(t, torCookieLen)
+	for _, tc := range []struct {
+		name     string
+		f        fakeTor
+		password string
+		code     int
+		err      error
+	}{
+		{name: "null", f: fakeTor{methods: "NULL"}},
+		{name: "cookie", f: fakeTor{methods: "COOKIE,SAFECOOKIE", cookie: cookie}},
+		{name: "password", f: fakeTor{methods: "HASHEDPASSWORD", password: `pass "word"`}, password: `pass "word"`},
+		{name: "cookie without password", f: fakeTor{methods: "SAFECOOKIE,HASHEDPASSWORD", cookie: cookie}},
+		{name: "wrong password", f: fakeTor{methods: "HASHEDPASSWORD", password: "right"}, password: "wrong", code: 515},
+		{name: "short cookie", f: fakeTor{methods: "SAFECOOKIE", cookie: writeCookie(t, 16)}, code: -1},
+		{name: "forged cookie", f: fakeTor{methods: "SAFECOOKIE", cookie: cookie, forged: true}, err: errTorServerHash},
+		{name: "plain cookie only", f: fakeTor{methods: "COOKIE", cookie: cookie}, err: errTorControlAuth},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.f.t = t
+			ctx := context.Background()
+			c, err := dialTorControl(ctx, tc.f.serve())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			err = c.authenticate(ctx, tc.password)
+			var e *torControlError
+			switch {
+			case tc.err != nil:
+				if !errors.Is(err, tc.err) {
+					t.Errorf("authenticated with %v, want %v", err, tc.err)
+				}
+			case tc.code > 0:
+				if !errors.As(err, &e) || e.code != tc.code {
+					t.Errorf("authenticated with %v, want %d", err, tc.code)
+				}
+			case tc.code < 0:
+				if err == nil {
+					t.Error("authenticated")
+				}
+			case err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseTorKeywords(t *testing.T) {
+	kw := parseTorKeywords(`METHODS=COOKIE,SAFECOOKIE COOKIEFILE="/var/lib/tor/a \"b\" \\c" FLAG`)
+	if kw["METHODS"] != "COOKIE,SAFECOOKIE" || kw["COOKIEFILE"] != `/var/lib/tor/a "b" \c` {
+		t.Errorf("parsed %q", kw)
+	}
+	b, ok := parseTorBootstrap(`NOTICE BOOTSTRAP PROGRESS=75 TAG=enough_dirinfo SUMMARY="Loaded enough directory info to build circuits"`)
+	if !ok || b.progress != 75 || b.tag != "enough_dirinfo" || !strings.HasPrefix(b.summary, "Loaded enough") {
+		t.Errorf("parsed %+v", b)
+	}
+	if _, ok := parseTorBootstrap("NOTICE CIRCUIT_ESTABLISHED"); ok {
+		t.Error("parsed a status without bootstrap progress")
+	}
+	if q := quoteTorString("a\"b\\\n"); q != `"a\"b\\\n"` {
+		t.Errorf("quoted %s", q)
+	}
+}
+
+func TestWaitForTor(t *testing.T) {
+	f := &fakeTor{t: t, methods: "NULL", phases: []int{10, 50, 100}, next: make(chan struct{})}
+	close(f.next)
+	var reports []torStatus
+	if err := waitForTor(context.Background(), f.serve(), "", func(s torStatus) {
+		reports = append(reports, s)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []torStatus{
+		{progress: 10, summary: `Phase "0"`},
+		{progress: 10, summary: `Phase "0"`, circuits: 1},
+		{progress: 50, summary: `Phase "1"`, circuits: 1},
+		{progress: 50, summary: `Phase "1"`, circuits: 2},
+		{progress: 100, summary: `Phase "2"`, circuits: 2},
+	}
+	if fmt.Sprint(reports) != fmt.Sprint(want) {
+		t.Errorf("reported %v, want %v", reports, want)
+	}
+
+	f = &fakeTor{t: t, methods: "NULL", phases: []int{10, 100}, next: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	err := waitForTor(ctx, f.serve(), "", func(torStatus) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waited with %v after cancel", err)
+	}
+	if err := waitForTor(context.Background(), closedAddress(t), "", func(torStatus) {}); err == nil {
+		t.Error("waited without a control port")
+	}
+}
+
+func TestOnlineWaitsForTor(t *testing.T) {
+	m := newFakeMessenger()
+	a := newTestApp(m)
+	f := &fakeTor{t: t, methods: "NULL", phases: []int{50, 100}, next: make(chan struct{})}
+	m.AddBlob("UseTor", []byte{1})
+	if err := a.setProxySettings(proxySettings{ControlAddress: f.serve()}); err != nil {
+		t.Fatal(err)
+	}
+	online := func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return m.online
+	}
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	bootstrapping := func() bool {
+		s, ok := a.state.TorStatus()
+		return ok && s.progress == 50
+	}
+
+	a.online()
+	waitFor(bootstrapping)
+	if online() || !a.state.Connecting() {
+		t.Fatal("connected before Tor bootstrapped")
+	}
+	f.next <- struct{}{}
+	waitFor(online)
+	if _, ok := a.state.TorStatus(); ok {
+		t.Error("still waiting for Tor after connecting")
+	}
+
+	a.navigate(OfflineClick{})
+	waitFor(func() bool { return !online() })
+	a.online()
+	waitFor(bootstrapping)
+	a.navigate(OfflineClick{})
+	waitFor(func() bool {
+		_, ok := a.state.TorStatus()
+		return !ok
+	})
+	if online() {
+		t.Error("connected after going offline while waiting for Tor")
+	}
+}
+
+func TestTorControlCommandCanceled(t *testing.T) {
+	f := &fakeTor{t: t, methods: "NULL"}
+	c, err := dialTorControl(context.Background(), f.serve())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.command(ctx, "PROTOCOLINFO 1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("command returned %v after ctx was canceled", err)
+	}
+	// the deadline of a done ctx is not kept for the next command
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.authenticate(ctx, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1":   true,
+		"::1":         true,
+		"192.0.2.1":   false,
+		"2001:db8::1": false,
+	} {
+		if got := isLoopback(&net.TCPAddr{IP: net.ParseIP(addr), Port: 9051}); got != want {
+			t.Errorf("isLoopback(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
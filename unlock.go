@@ -1,13 +1,123 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/widget"
 	"gioui.org/widget/material"
-	"github.com/katzenpost/katzenpost/catshadow"
 )
 
-type unlockPage struct {
+// unlockStage is a step of setupCatShadow
+type unlockStage int
+
+const (
+	stageConfig unlockStage = iota
+	stageDecrypt
+	stageTor
+	stageClient
+	numStages
+)
+
+// String returns the label of the stage
+func (s unlockStage) String() string {
+	switch s {
+	case stageConfig:
+		return "Loading configuration"
+	case stageDecrypt:
+		return "Decrypting statefile"
+	case stageTor:
+		return "Looking for Tor"
+	case stageClient:
+		return "Starting client"
+	}
+	return "Unlocking"
+}
+
+// unlockProgress is sent by setupCatShadow when it starts a stage
+type unlockProgress struct {
+	stage unlockStage
+}
+
+// unlockRedrawInterval is how often the elapsed time of a stage is redrawn
+const unlockRedrawInterval = 250 * time.Millisecond
+
+// sendProgress reports that stage started, and returns false if ctx is done
+func sendProgress(ctx context.Context, result chan interface{}, stage unlockStage) bool {
+	return sendResult(ctx, result, unlockProgress{stage: stage})
+}
+
+// sendResult sends v unless ctx is done, and returns true if it was sent
+func sendResult(ctx context.Context, result chan interface{}, v interface{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case result <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// unlockTask runs setupCatShadow, or the resumption of a stateReport, in the
+// background
+type unlockTask struct {
 	result chan interface{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startUnlock runs f with a result channel read by the unlockPage, and
+// redraws when it returns
+func (a *App) startUnlock(f func(ctx context.Context, result chan interface{})) *unlockTask {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &unlockTask{
+		// room for every stage and the result, so that f never waits for
+		// the page
+		result: make(chan interface{}, numStages+1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(t.done)
+		f(ctx, t.result)
+		a.w.Invalidate()
+	}()
+	return t
+}
+
+// abort cancels the task. A client it started before noticing is shut down,
+// and a report it sent is discarded.
+func (t *unlockTask) abort() {
+	t.cancel()
+	go func() {
+		<-t.done
+		for {
+			select {
+			case r := <-t.result:
+				switch r := r.(type) {
+				case Messenger:
+					r.Shutdown()
+				case *stateReport:
+					r.discard()
+				}
+			default:
+				return
+			}
+		}
+	}()
+}
+
+type unlockPage struct {
+	task   *unlockTask
+	cancel *widget.Clickable
+	// stage is the current stage, started at started
+	stage   unlockStage
+	started time.Time
 }
 
 func (p *unlockPage) Layout(gtx layout.Context) layout.Dimensions {
@@ -15,9 +125,35 @@ func (p *unlockPage) Layout(gtx layout.Context) layout.Dimensions {
 		Color: th.Bg,
 		Inset: layout.Inset{},
 	}
+	if p.started.IsZero() {
+		p.started = gtx.Now
+	}
+	gtx.Execute(op.InvalidateCmd{At: gtx.Now.Add(unlockRedrawInterval)})
 
 	return bg.Layout(gtx, func(gtx C) D {
-		return layout.Center.Layout(gtx, material.Caption(th, "Decrypting statefile...").Layout)
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			layout.Flexed(1, func(gtx C) D {
+				return layout.Center.Layout(gtx, func(gtx C) D {
+					var steps []layout.FlexChild
+					for s := stageConfig; s < numStages; s++ {
+						steps = append(steps, layout.Rigid(func(gtx C) D {
+							switch {
+							case s < p.stage:
+								return inset.Layout(gtx, material.Caption(th, s.String()+": done").Layout)
+							case s == p.stage:
+								label := fmt.Sprintf("%s... %d s", s, int(gtx.Now.Sub(p.started)/time.Second))
+								return inset.Layout(gtx, material.Body1(th, label).Layout)
+							}
+							return inset.Layout(gtx, material.Caption(th, s.String()).Layout)
+						}))
+					}
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx, steps...)
+				})
+			}),
+			layout.Rigid(func(gtx C) D {
+				return material.Button(th, p.cancel, "Cancel").Layout(gtx)
+			}),
+		)
 	})
 }
 
@@ -29,25 +165,52 @@ type unlockError struct {
 }
 
 type unlockSuccess struct {
-	client *catshadow.Client
+	client Messenger
 }
 
+// unlockCanceled is sent when unlocking is canceled
+type unlockCanceled struct{}
+
 func (p *unlockPage) Event(gtx layout.Context) interface{} {
-	select {
-	case r := <-p.result:
-		switch r := r.(type) {
-		case error:
-			return unlockError{err: r}
-		case *catshadow.Client:
-			return unlockSuccess{client: r}
+	if p.cancel.Clicked(gtx) {
+		p.task.abort()
+		return unlockCanceled{}
+	}
+	for {
+		select {
+		case r := <-p.task.result:
+			switch r := r.(type) {
+			case unlockProgress:
+				if r.stage != p.stage {
+					p.stage = r.stage
+					p.started = gtx.Now
+				}
+				continue
+			case error:
+				return unlockError{err: r}
+			case Messenger:
+				return unlockSuccess{client: r}
+			case *stateReport:
+				return unlockReport{report: r}
+			}
+		default:
 		}
-	default:
+		return nil
 	}
-	return nil
 }
 
-func newUnlockPage(result chan interface{}) *unlockPage {
-	p := new(unlockPage)
-	p.result = result
-	return p
+func init() {
+	handle(func(a *App, e signInStarted) interface{} {
+		return Reset{newUnlockPage(e.task)}
+	})
+	handle(func(a *App, _ unlockCanceled) interface{} {
+		return Reset{newSignInPage(a)}
+	})
+}
+
+func newUnlockPage(task *unlockTask) *unlockPage {
+	return &unlockPage{
+		task:   task,
+		cancel: &widget.Clickable{},
+	}
 }
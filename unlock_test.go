@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+// runUnlock runs f to completion, and returns the stages it reported and
+// the last value it sent, if any
+func runUnlock(f func(ctx context.Context, result chan interface{})) ([]unlockStage, interface{}) {
+	result := make(chan interface{}, numStages+1)
+	f(context.Background(), result)
+	close(result)
+	var stages []unlockStage
+	var last interface{}
+	for r := range result {
+		if p, ok := r.(unlockProgress); ok {
+			stages = append(stages, p.stage)
+		} else {
+			last = r
+		}
+	}
+	return stages, last
+}
+
+func TestUnlockProgress(t *testing.T) {
+	statefile := filepath.Join(t.TempDir(), "statefile")
+	writeTestState(t, statefile, []byte("hunter2"))
+	stages, r := runUnlock(func(ctx context.Context, result chan interface{}) {
+		setupCatShadow(ctx, statefile, "", testSecret(t, "hunter3"), true, result)
+	})
+	if len(stages) != 2 || stages[0] != stageConfig || stages[1] != stageDecrypt {
+		t.Errorf("stages %v, want %v", stages, []unlockStage{stageConfig, stageDecrypt})
+	}
+	if err, _ := r.(error); !errors.Is(err, catshadow.DecryptStateFailed) {
+		t.Errorf("result %v, want %v", r, catshadow.DecryptStateFailed)
+	}
+}
+
+func TestUnlockCanceled(t *testing.T) {
+	statefile := filepath.Join(t.TempDir(), "statefile")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := make(chan interface{}, numStages+1)
+	setupCatShadow(ctx, statefile, "", testSecret(t, "correct horse battery staple"), true, result)
+	if len(result) != 0 {
+		t.Errorf("%v was sent after cancel", <-result)
+	}
+	if _, err := os.Stat(statefile); !os.IsNotExist(err) {
+		t.Error("a statefile was created after cancel")
+	}
+}
+
+func TestUnlockPage(t *testing.T) {
+	useStateFile(t)
+	var task *unlockTask
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		task = a.startUnlock(func(ctx context.Context, result chan interface{}) {
+			for s := stageConfig; s <= stageTor; s++ {
+				sendProgress(ctx, result, s)
+			}
+			<-ctx.Done()
+		})
+		for len(task.result) < int(stageTor)+1 {
+			time.Sleep(time.Millisecond)
+		}
+		return newUnlockPage(task)
+	})
+	p := h.current().(*unlockPage)
+	if p.stage != stageTor {
+		t.Fatalf("stage is %v, want %v", p.stage, stageTor)
+	}
+	h.screenshot("unlock")
+
+	p.cancel.Click()
+	h.frames(2)
+	if _, ok := h.current().(*signInPage); !ok {
+		t.Errorf("current page is %T, want *signInPage", h.current())
+	}
+	select {
+	case <-task.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("unlocking was not canceled")
+	}
+}
+
+func TestUnlockAbort(t *testing.T) {
+	m := newFakeMessenger()
+	a := newTestApp(nil)
+	task := a.startUnlock(func(ctx context.Context, result chan interface{}) {
+		// the client started before the cancel was noticed
+		<-ctx.Done()
+		result <- Messenger(m)
+	})
+	task.abort()
+	select {
+	case <-m.HaltCh():
+	case <-time.After(10 * time.Second):
+		t.Fatal("client started by a canceled unlock was not shut down")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// setupCatShadow wraps its errors in these, so that the sign in page can
+// tell what went wrong
+var (
+	errCorruptStatefile = errors.New("the statefile is damaged")
+	errInvalidConfig    = errors.New("the client configuration is not valid")
+	errDataDir          = errors.New("the data directory is not writable")
+	errTorMismatch      = errors.New("the Tor setting does not match the client configuration")
+)
+
+// maxUnlockDelay is the longest wait after wrong passphrases
+const maxUnlockDelay = 5 * time.Minute
+
+// The wrong passphrases entered in a row are kept next to the statefile, so
+// that restarting katzen does not skip the delay, as:
+//
+//	failures (4) || retry at (8, unix nanoseconds)
+const (
+	unlockDelayExtension = ".unlock"
+	unlockDelaySize      = 4 + 8
+)
+
+// unlockDelayFile returns the path of the failed unlocks of statefile
+func unlockDelayFile(statefile string) string {
+	return statefile + unlockDelayExtension
+}
+
+// unlockProblem describes a failure to unlock to the user
+type unlockProblem struct {
+	message string
+	action  string
+	// passphrase is set if the passphrase was wrong
+	passphrase bool
+}
+
+// classifyUnlockError returns the problem that caused err
+func classifyUnlockError(err error) unlockProblem {
+	switch {
+	case errors.Is(err, catshadow.DecryptStateFailed):
+		return unlockProblem{
+			message:    "The passphrase is not correct",
+			action:     "Check that caps lock is off and the keyboard layout is the one you chose it with.",
+			passphrase: true,
+		}
+	case errors.Is(err, errWeakPassphrase):
+		return unlockProblem{
+			message: err.Error(),
+			action:  "Choose a longer passphrase, of several unrelated words.",
+		}
+	case errors.Is(err, errCorruptStatefile):
+		return unlockProblem{
+			message: "The statefile is damaged and cannot be read",
+			action:  "Restore a backup from Profiles, or replace the statefile with the copy ending in ~ next to it.",
+		}
+	case errors.Is(err, errInvalidConfig):
+		return unlockProblem{
+			message: "The client configuration is not valid",
+			action:  "Choose another configuration file for the profile, or start without -f.",
+		}
+	case errors.Is(err, errTorMismatch):
+		return unlockProblem{
+			message: "Tor is turned on, but the client cannot use it",
+			action:  "Start Tor, or the SOCKS5 proxy set in the settings, and sign in again.",
+		}
+	case errors.Is(err, errDataDir), errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return unlockProblem{
+			message: "The statefile cannot be written",
+			action:  "Check the permissions of the data directory and that the disk is not full or read-only.",
+		}
+	}
+	return unlockProblem{
+		message: "Katzen could not start",
+		action:  err.Error(),
+	}
+}
+
+// stateNonceSize is the size of the secretbox nonce that a statefile written
+// by catshadow starts with
+const stateNonceSize = 24
+
+// checkStatefile returns errCorruptStatefile if the statefile at path is too
+// short to be decrypted, which catshadow does not check
+func checkStatefile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() < stateNonceSize+secretbox.Overhead {
+		return fmt.Errorf("%w: it is %d bytes long", errCorruptStatefile, fi.Size())
+	}
+	return nil
+}
+
+// checkWritable returns errDataDir if a file cannot be created in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".katzen-*")
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDataDir, err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// unlockDelay returns how long to wait before signing in again after n wrong
+// passphrases in a row
+func unlockDelay(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	d := time.Second
+	for i := 1; i < n && d < maxUnlockDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxUnlockDelay)
+}
+
+// unlockFailed records a failure to unlock, and delays the next attempt if
+// the passphrase was wrong
+func (a *App) unlockFailed(err error) unlockProblem {
+	problem := classifyUnlockError(err)
+	if problem.passphrase {
+		a.failedUnlocks++
+		a.retryUnlockAt = time.Now().Add(unlockDelay(a.failedUnlocks))
+		if err := a.saveUnlockDelay(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save the unlock delay: %v\n", err)
+		}
+	}
+	return problem
+}
+
+// loadUnlockDelay reads the failed unlocks of the statefile in use, unless
+// they were read for it already
+func (a *App) loadUnlockDelay() {
+	statefile, err := a.statefile()
+	if err != nil || statefile == a.unlockDelayOf {
+		return
+	}
+	a.unlockDelayOf = statefile
+	a.failedUnlocks, a.retryUnlockAt = 0, time.Time{}
+	b, err := os.ReadFile(unlockDelayFile(statefile))
+	if err != nil || len(b) != unlockDelaySize {
+		return
+	}
+	a.failedUnlocks = int(binary.BigEndian.Uint32(b))
+	a.retryUnlockAt = time.Unix(0, int64(binary.BigEndian.Uint64(b[4:])))
+}
+
+// saveUnlockDelay writes the failed unlocks of the statefile in use, or
+// removes them if there are none
+func (a *App) saveUnlockDelay() error {
+	statefile, err := a.statefile()
+	if err != nil {
+		return err
+	}
+	path := unlockDelayFile(statefile)
+	if a.failedUnlocks == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b := make([]byte, unlockDelaySize)
+	binary.BigEndian.PutUint32(b, uint32(a.failedUnlocks))
+	binary.BigEndian.PutUint64(b[4:], uint64(a.retryUnlockAt.UnixNano()))
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := writeFileSync(tmp, b); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
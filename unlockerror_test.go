@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/katzenpost/katzenpost/catshadow"
+)
+
+func TestClassifyUnlockError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{catshadow.DecryptStateFailed, "The passphrase is not correct"},
+		{fmt.Errorf("%w: unexpected EOF", errCorruptStatefile), "The statefile is damaged and cannot be read"},
+		{fmt.Errorf("%w: toml", errInvalidConfig), "The client configuration is not valid"},
+		{&fs.PathError{Op: "open", Path: "statefile", Err: fs.ErrPermission}, "The statefile cannot be written"},
+		{fmt.Errorf("%w: %w", errDataDir, errors.New("no space left")), "The statefile cannot be written"},
+		{fmt.Errorf("%w: no SOCKS5 proxy was found", errTorMismatch), "Tor is turned on, but the client cannot use it"},
+		{errors.New("something else"), "Katzen could not start"},
+	} {
+		p := classifyUnlockError(tc.err)
+		if p.message != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.err, p.message, tc.want)
+		}
+		if p.action == "" {
+			t.Errorf("%v: no suggested action", tc.err)
+		}
+		if p.passphrase != errors.Is(tc.err, catshadow.DecryptStateFailed) {
+			t.Errorf("%v: passphrase is %v", tc.err, p.passphrase)
+		}
+	}
+}
+
+func TestUnlockDelay(t *testing.T) {
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for n, d := range want {
+		if got := unlockDelay(n); got != d {
+			t.Errorf("delay after %d failures is %v, want %v", n, got, d)
+		}
+	}
+	if got := unlockDelay(100); got != maxUnlockDelay {
+		t.Errorf("delay after 100 failures is %v, want %v", got, maxUnlockDelay)
+	}
+}
+
+func TestSetupCatShadowErrors(t *testing.T) {
+	setup := func(statefile, configFile, passphrase string) error {
+		t.Helper()
+		_, r := runUnlock(func(ctx context.Context, result chan interface{}) {
+			setupCatShadow(ctx, statefile, configFile, testSecret(t, passphrase), true, result)
+		})
+		err, ok := r.(error)
+		if !ok {
+			t.Fatal("setupCatShadow did not fail")
+		}
+		return err
+	}
+	dir := t.TempDir()
+	statefile := filepath.Join(dir, "statefile")
+	writeTestState(t, statefile, []byte("hunter2"))
+
+	if err := setup(statefile, "", "hunter3"); !errors.Is(err, catshadow.DecryptStateFailed) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+
+	badConfig := filepath.Join(dir, "client.toml")
+	if err := os.WriteFile(badConfig, []byte("[Logging"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(statefile, badConfig, "hunter2"); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("invalid configuration: %v", err)
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte("katzen"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(short, "", "hunter2"); !errors.Is(err, errCorruptStatefile) {
+		t.Errorf("truncated statefile: %v", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage")
+	if err := writeStateFile(garbage, []byte{0xff, 0xff, 0xff}, []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(garbage, "", "hunter2"); !errors.Is(err, errCorruptStatefile) {
+		t.Errorf("statefile that does not decode: %v", err)
+	}
+
+	// Tor is turned on, and no proxy is found
+	saved := proxyProbeAddresses
+	t.Cleanup(func() { proxyProbeAddresses = saved })
+	proxyProbeAddresses = []string{closedAddress(t)}
+	tor := filepath.Join(dir, "tor")
+	torState := &catshadow.State{
+		Contacts:      make([]*catshadow.Contact, 0),
+		Conversations: make(map[string]map[catshadow.MessageID]*catshadow.Message),
+		Blob:          map[string][]byte{"UseTor": {1}, "AutoConnect": {1}},
+	}
+	if err := saveState(tor, torState, []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup(tor, "", "hunter2"); !errors.Is(err, errTorMismatch) {
+		t.Errorf("Tor without a proxy: %v", err)
+	}
+
+	if os.Geteuid() != 0 {
+		readOnly := filepath.Join(dir, "readonly")
+		if err := os.Mkdir(readOnly, 0500); err != nil {
+			t.Fatal(err)
+		}
+		if err := setup(filepath.Join(readOnly, "statefile"), "", "correct horse battery staple"); !errors.Is(err, errDataDir) {
+			t.Errorf("read only data directory: %v", err)
+		}
+	}
+}
+
+func TestSignInUnlockError(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("hunter2"))
+	h := newPageHarness(t, newFakeMessenger(), func(a *App) Page {
+		return newSignInPage(a)
+	})
+
+	h.a.navigate(unlockError{err: catshadow.DecryptStateFailed})
+	p, ok := h.current().(*signInPage)
+	if !ok || p.problem == nil || !p.problem.passphrase {
+		t.Fatalf("current page is %T, want the sign in page with the problem", h.current())
+	}
+	if h.a.failedUnlocks != 1 {
+		t.Errorf("%d failed unlocks, want 1", h.a.failedUnlocks)
+	}
+	h.a.retryUnlockAt = time.Time{}
+	h.frame()
+	h.screenshot("signinerror")
+
+	// other problems do not delay the next attempt
+	h.a.navigate(unlockError{err: fmt.Errorf("%w: toml", errInvalidConfig)})
+	if p := h.current().(*signInPage); p.problem.passphrase || h.a.failedUnlocks != 1 {
+		t.Error("a configuration problem counted as a wrong passphrase")
+	}
+
+	h.a.failedUnlocks = 4
+	h.a.navigate(unlockError{err: catshadow.DecryptStateFailed})
+	p = h.current().(*signInPage)
+	if d := time.Until(h.a.retryUnlockAt); d < 10*time.Second {
+		t.Fatalf("delay after 5 wrong passphrases is %v", d)
+	}
+	p.password.SetText("hunter3")
+	p.submit.Click()
+	h.frame()
+	if h.current() != Page(p) {
+		t.Fatal("signed in during the delay")
+	}
+
+	// the harness clock moves a second each frame
+	h.frames(20)
+	p.password.SetText("hunter3")
+	p.submit.Click()
+	h.frame()
+	if _, ok := h.current().(*unlockPage); !ok {
+		t.Fatalf("current page is %T after the delay, want *unlockPage", h.current())
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for h.a.failedUnlocks != 6 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		h.frame()
+	}
+	if h.a.failedUnlocks != 6 {
+		t.Fatalf("%d failed unlocks, want 6", h.a.failedUnlocks)
+	}
+
+	h.a.navigate(unlockSuccess{client: newPopulatedMessenger()})
+	if h.a.failedUnlocks != 0 {
+		t.Error("failed unlocks were not reset by signing in")
+	}
+}
+
+func TestUnlockDelayPersists(t *testing.T) {
+	path := useStateFile(t)
+	writeTestState(t, path, []byte("hunter2"))
+	a := newTestApp(newFakeMessenger())
+	a.loadUnlockDelay()
+	a.unlockFailed(catshadow.DecryptStateFailed)
+	a.unlockFailed(catshadow.DecryptStateFailed)
+
+	// restarting katzen keeps the delay
+	b := newTestApp(newFakeMessenger())
+	b.loadUnlockDelay()
+	if b.failedUnlocks != 2 || !b.retryUnlockAt.Equal(a.retryUnlockAt) {
+		t.Errorf("loaded %d failed unlocks until %v, want 2 until %v", b.failedUnlocks, b.retryUnlockAt, a.retryUnlockAt)
+	}
+
+	b.navigate(unlockSuccess{client: newPopulatedMessenger()})
+	if _, err := os.Stat(unlockDelayFile(path)); !os.IsNotExist(err) {
+		t.Error("failed unlocks were kept after signing in")
+	}
+	c := newTestApp(newFakeMessenger())
+	c.loadUnlockDelay()
+	if c.failedUnlocks != 0 || !c.retryUnlockAt.IsZero() {
+		t.Error("delay was loaded after signing in")
+	}
+}